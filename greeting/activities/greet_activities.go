@@ -2,8 +2,14 @@ package activities
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
 	"time"
+
+	"go.temporal.io/sdk/temporal"
 )
 
 type UserDetails struct {
@@ -15,9 +21,36 @@ type UserDetails struct {
 
 type UserPreferences struct {
 	Language string
+	Timezone string // IANA name, e.g. "Europe/Madrid"; empty means UTC
+}
+
+// SMTPConfig configures real email delivery. An empty Host disables SMTP and
+// greetings are printed to stdout instead.
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// SendMailFunc matches smtp.SendMail so tests can inject a fake
+type SendMailFunc func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+// EmailDeliveryError represents a transient SMTP failure that should be retried
+type EmailDeliveryError struct {
+	Msg string
+}
+
+func (e *EmailDeliveryError) Error() string {
+	return e.Msg
 }
 
 type GreetActivities struct {
+	SMTP     SMTPConfig
+	SendMail SendMailFunc // defaults to smtp.SendMail
+	// Sleep waits out the simulated delays; defaults to time.Sleep
+	Sleep func(d time.Duration)
 }
 
 func (a *GreetActivities) GetUserDetails(ctx context.Context, userId string) (*UserDetails, error) {
@@ -42,13 +75,61 @@ func (a *GreetActivities) SendGreeting(ctx context.Context, email string, messag
 		return fmt.Errorf("message is empty")
 	}
 
-	// Simulate sending email
-	fmt.Printf("Sending greeting to %s: %s\n", email, message)
+	if a.SMTP.Host == "" {
+		// Simulate sending email
+		fmt.Printf("Sending greeting to %s: %s\n", email, message)
 
-	// Simulate some delay
-	time.Sleep(100 * time.Millisecond)
+		// Simulate some delay
+		a.sleep(100 * time.Millisecond)
 
-	return nil
+		return nil
+	}
+
+	return a.sendSMTP(email, message)
+}
+
+func (a *GreetActivities) sleep(d time.Duration) {
+	if a.Sleep == nil {
+		time.Sleep(d)
+		return
+	}
+	a.Sleep(d)
+}
+
+func (a *GreetActivities) sendSMTP(email string, message string) error {
+	sendMail := a.SendMail
+	if sendMail == nil {
+		sendMail = smtp.SendMail
+	}
+
+	port := a.SMTP.Port
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if a.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", a.SMTP.Username, a.SMTP.Password, a.SMTP.Host)
+	}
+
+	msg := []byte("From: " + a.SMTP.From + "\r\n" +
+		"To: " + email + "\r\n" +
+		"Subject: A greeting for you\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		message + "\r\n")
+
+	err := sendMail(net.JoinHostPort(a.SMTP.Host, port), auth, a.SMTP.From, []string{email}, msg)
+	if err == nil {
+		return nil
+	}
+
+	// 5xx replies are permanent (bad recipient, rejected message); everything else may succeed on retry
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return temporal.NewNonRetryableApplicationError("smtp rejected greeting", "PermanentError", err)
+	}
+	return &EmailDeliveryError{Msg: fmt.Sprintf("smtp delivery failed: %v", err)}
 }
 
 func (a *GreetActivities) LogGreeting(ctx context.Context, userId string, message string) error {
@@ -64,5 +145,6 @@ func (a *GreetActivities) GetUserPreferencesId(ctx context.Context, userId strin
 
 	return &UserPreferences{
 		Language: "ES",
+		Timezone: "Europe/Madrid",
 	}, nil
 }
@@ -5,18 +5,29 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/robfig/cron"
 	"go.temporal.io/sdk/client"
 
 	"go-temporal-fast-course/greeting/workflows"
+	"go-temporal-fast-course/shared/encryption"
+	"go-temporal-fast-course/shared/retry"
 )
 
 func main() {
+	// Payloads are encrypted when PAYLOAD_ENCRYPTION_KEY is set
+	dataConverter, err := encryption.DataConverterFromEnv()
+	if err != nil {
+		log.Fatalln("Unable to configure payload encryption", err)
+	}
+
 	// Create Temporal client
-	c, err := client.Dial(client.Options{
-		HostPort: getEnv("TEMPORAL_HOST", "localhost:7233"),
-	})
+	c, err := retry.DialWithRetry(client.Options{
+		HostPort:      getEnv("TEMPORAL_HOST", "localhost:7233"),
+		DataConverter: dataConverter,
+	}, retry.DialAttempts, retry.DialBackoff)
 	if err != nil {
 		log.Fatalln("Unable to create Temporal client", err)
 	}
@@ -25,16 +36,35 @@ func main() {
 	// Get task queue name
 	taskQueue := getEnv("ORDER_TASK_QUEUE", "order-task-queue")
 
-	runGreetWorkflow(c, taskQueue)
+	// Determine which workflow to run
+	workflowType := getEnv("WORKFLOW_TYPE", "greet")
+
+	switch workflowType {
+	case "greet":
+		runGreetWorkflow(c, taskQueue)
+	case "greet-cron":
+		runScheduledGreet(c, taskQueue)
+	case "greet-batch":
+		runBatchGreet(c, taskQueue)
+	default:
+		log.Fatalf("Unknown workflow type: %s (use 'greet', 'greet-cron' or 'greet-batch')", workflowType)
+	}
 }
 
 func runGreetWorkflow(c client.Client, taskQueue string) {
 	// Generate workflow ID
 	workflowID := fmt.Sprintf("greet-workflow-%d", time.Now().Unix())
 
+	// A positive REPLY_TIMEOUT waits that long for a "user-reply" signal
+	replyTimeout, err := time.ParseDuration(getEnv("REPLY_TIMEOUT", "0s"))
+	if err != nil {
+		log.Fatalf("Invalid REPLY_TIMEOUT %q: %v", os.Getenv("REPLY_TIMEOUT"), err)
+	}
+
 	// Prepare workflow input
 	input := workflows.GreetUserInput{
-		UserID: getEnv("USER_ID", "user-123"),
+		UserID:       getEnv("USER_ID", "user-123"),
+		ReplyTimeout: replyTimeout,
 	}
 
 	// Configure workflow options
@@ -52,6 +82,9 @@ func runGreetWorkflow(c client.Client, taskQueue string) {
 	}
 
 	log.Printf("Started workflow - WorkflowID: %s, RunID: %s\n", we.GetID(), we.GetRunID())
+	if replyTimeout > 0 {
+		log.Printf("Reply within %s with: temporal workflow signal --workflow-id %s --name %s --input '{\"Message\":\"...\"}'\n", replyTimeout, workflowID, workflows.SignalUserReply)
+	}
 
 	// Wait for workflow result
 	var result workflows.GreetUserOutput
@@ -63,6 +96,81 @@ func runGreetWorkflow(c client.Client, taskQueue string) {
 	log.Printf("✅ Workflow completed successfully!\n")
 	log.Printf("Message: %s\n", result.Message)
 	log.Printf("Sent at: %s\n", result.SentAt)
+	log.Printf("Language: %s, Time of day: %s (%s)\n", result.Language, result.TimeOfDay, result.Timezone)
+	if replyTimeout > 0 {
+		log.Printf("Reply: %q\n", result.Reply)
+	}
+}
+
+func runScheduledGreet(c client.Client, taskQueue string) {
+	// Validate the cron expression before handing it to the server
+	cronSchedule := getEnv("GREET_CRON", "0 9 * * *")
+	schedule, err := cron.ParseStandard(cronSchedule)
+	if err != nil {
+		log.Fatalf("Invalid GREET_CRON expression %q: %v (expected 5 fields: minute hour day-of-month month day-of-week)", cronSchedule, err)
+	}
+
+	userID := getEnv("USER_ID", "user-123")
+	workflowID := fmt.Sprintf("greet-cron-workflow-%s", userID)
+
+	input := workflows.GreetUserInput{
+		UserID: userID,
+	}
+
+	// Configure workflow options with the cron schedule
+	workflowOptions := client.StartWorkflowOptions{
+		ID:           workflowID,
+		TaskQueue:    taskQueue,
+		CronSchedule: cronSchedule,
+	}
+
+	log.Printf("Starting scheduled GreetUser workflow: %s (cron: %s)\n", workflowID, cronSchedule)
+
+	// Start workflow - cron workflows never complete, so don't wait on the result
+	we, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.GreetUser, input)
+	if err != nil {
+		log.Fatalln("Unable to start workflow", err)
+	}
+
+	log.Printf("Started workflow - WorkflowID: %s, RunID: %s\n", we.GetID(), we.GetRunID())
+	// Temporal evaluates cron schedules in UTC
+	log.Printf("⏰ Next scheduled run: %s\n", schedule.Next(time.Now().UTC()).Format(time.RFC1123))
+	log.Printf("Stop the schedule with: temporal workflow terminate --workflow-id %s\n", workflowID)
+}
+
+func runBatchGreet(c client.Client, taskQueue string) {
+	// USER_IDS is a comma-separated list
+	var userIDs []string
+	for _, userID := range strings.Split(getEnv("USER_IDS", "user-1,user-2,user-3"), ",") {
+		if userID = strings.TrimSpace(userID); userID != "" {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	workflowID := fmt.Sprintf("greet-batch-workflow-%d", time.Now().Unix())
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        workflowID,
+		TaskQueue: taskQueue,
+	}
+
+	log.Printf("Starting GreetUsers workflow for %d users: %s\n", len(userIDs), workflowID)
+	we, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.GreetUsers, userIDs)
+	if err != nil {
+		log.Fatalln("Unable to start workflow", err)
+	}
+
+	log.Printf("Started workflow - WorkflowID: %s, RunID: %s\n", we.GetID(), we.GetRunID())
+	log.Printf("Progress: temporal workflow query --workflow-id %s --type get-progress\n", workflowID)
+
+	var result workflows.BatchGreetResult
+	if err := we.Get(context.Background(), &result); err != nil {
+		log.Fatalln("Workflow execution failed", err)
+	}
+
+	log.Printf("✅ Greeted %d of %d users\n", len(result.Greeted), result.Total)
+	for userID, reason := range result.Failed {
+		log.Printf("❌ %s: %s\n", userID, reason)
+	}
 }
 
 func getEnv(key, defaultValue string) string {
@@ -1,21 +1,54 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"go-temporal-fast-course/greeting/activities"
 	"go-temporal-fast-course/greeting/workflows"
+	"go-temporal-fast-course/shared/encryption"
+	"go-temporal-fast-course/shared/health"
+	"go-temporal-fast-course/shared/interceptors"
+	"go-temporal-fast-course/shared/logging"
+	"go-temporal-fast-course/shared/retry"
 
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/worker"
 )
 
 func main() {
+	// Health endpoints for orchestrators; /readyz returns 503 once draining
+	healthServer := health.NewServer(":" + getEnv("HEALTH_PORT", "9091"))
+	go func() {
+		if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalln("Unable to start health server", err)
+		}
+	}()
+
+	// Payloads are encrypted when PAYLOAD_ENCRYPTION_KEY is set
+	dataConverter, err := encryption.DataConverterFromEnv()
+	if err != nil {
+		log.Fatalln("Unable to configure payload encryption", err)
+	}
+
+	// LOG_FORMAT=json writes one JSON object per line instead of key=value text
+	logger, err := logging.NewCorrelatedLoggerFromEnv()
+	if err != nil {
+		log.Fatalln("Unable to configure logging", err)
+	}
+
 	// Create Temporal client
-	c, err := client.Dial(client.Options{
-		HostPort: getEnv("TEMPORAL_HOST", "localhost:7233"),
-	})
+	c, err := retry.DialWithRetry(client.Options{
+		HostPort:      getEnv("TEMPORAL_HOST", "localhost:7233"),
+		DataConverter: dataConverter,
+		// Prefixes every workflow/activity log line with workflowID
+		Logger: logger,
+	}, retry.DialAttempts, retry.DialBackoff)
 	if err != nil {
 		log.Fatalln("Unable to create Temporal client", err)
 	}
@@ -24,17 +57,47 @@ func main() {
 	// Get task queue name from environment
 	taskQueue := getEnv("ORDER_TASK_QUEUE", "order-task-queue")
 
+	// Activity retry attempts are tunable per deployment
+	retry.MaxAttempts = int32(getEnvInt("RETRY_MAX_ATTEMPTS", int(retry.MaxAttempts)))
+
+	// Concurrency limits are tunable per deployment
+	maxConcurrentActivities := getEnvInt("MAX_CONCURRENT_ACTIVITIES", 100)
+	maxConcurrentWorkflowTasks := getEnvInt("MAX_CONCURRENT_WORKFLOW_TASKS", 50)
+
 	// Create worker with options
 	w := worker.New(c, taskQueue, worker.Options{
 		Identity:                               "order-worker-" + hostname(),
-		MaxConcurrentActivityExecutionSize:     100,
-		MaxConcurrentWorkflowTaskExecutionSize: 50,
+		MaxConcurrentActivityExecutionSize:     maxConcurrentActivities,
+		MaxConcurrentWorkflowTaskExecutionSize: maxConcurrentWorkflowTasks,
+		Interceptors: []interceptor.WorkerInterceptor{
+			interceptors.NewActivityTimingInterceptor(),
+		},
 	})
+	// Greeting hour boundaries, evaluated in each user's timezone
+	workflows.GreetingBoundaries = workflows.DayBoundaries{
+		Morning:   getEnvHour("GREETING_MORNING_HOUR", workflows.GreetingBoundaries.Morning),
+		Afternoon: getEnvHour("GREETING_AFTERNOON_HOUR", workflows.GreetingBoundaries.Afternoon),
+		Evening:   getEnvHour("GREETING_EVENING_HOUR", workflows.GreetingBoundaries.Evening),
+	}
+	if b := workflows.GreetingBoundaries; b.Morning > b.Afternoon || b.Afternoon > b.Evening {
+		log.Fatalf("Invalid greeting hours %+v: must be in increasing order", b)
+	}
+
 	// Register workflows
 	w.RegisterWorkflow(workflows.GreetUser)
+	w.RegisterWorkflow(workflows.GreetUsers)
 
 	// Greet activities (for simple example)
-	greetActivities := &activities.GreetActivities{}
+	// SMTP delivery is enabled when SMTP_HOST is set
+	greetActivities := &activities.GreetActivities{
+		SMTP: activities.SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     getEnv("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     getEnv("SMTP_FROM", "greetings@example.com"),
+		},
+	}
 	w.RegisterActivity(greetActivities.GetUserDetails)
 	w.RegisterActivity(greetActivities.SendGreeting)
 	w.RegisterActivity(greetActivities.LogGreeting)
@@ -42,9 +105,23 @@ func main() {
 
 	log.Println("Worker starting on task queue:", taskQueue)
 	log.Println("Worker identity:", "order-worker-"+hostname())
+	if greetActivities.SMTP.Host != "" {
+		log.Println("Sending greetings via SMTP:", greetActivities.SMTP.Host)
+	}
+	log.Println("Health endpoints:", "http://localhost"+healthServer.Addr+"/healthz", "/readyz")
+	log.Println("Retry max attempts:", retry.MaxAttempts)
+	log.Println("Max concurrent activities:", maxConcurrentActivities)
+	log.Println("Max concurrent workflow tasks:", maxConcurrentWorkflowTasks)
+
+	// Start worker; SIGTERM flips /readyz to 503 before the worker stops
+	err = w.Run(health.InterruptCh())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if shutdownErr := healthServer.Shutdown(shutdownCtx); shutdownErr != nil {
+		log.Println("Health server shutdown failed:", shutdownErr)
+	}
 
-	// Start worker
-	err = w.Run(worker.InterruptCh())
 	if err != nil {
 		log.Fatalln("Unable to start worker", err)
 	}
@@ -65,3 +142,29 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvInt reads a positive integer from the environment, exiting on invalid values
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid %s=%q: must be a positive integer", key, value)
+	}
+	return n
+}
+
+// getEnvHour reads an hour of the day (0-23) from the environment
+func getEnvHour(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > 23 {
+		log.Fatalf("Invalid %s=%q: must be an hour between 0 and 23", key, value)
+	}
+	return n
+}
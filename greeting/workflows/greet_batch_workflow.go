@@ -0,0 +1,79 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+)
+
+// maxConcurrentGreetings bounds how many GreetUser children a batch runs at once
+const maxConcurrentGreetings = 5
+
+// BatchGreetResult is returned by GreetUsers and, while it runs, by its
+// "get-progress" query
+type BatchGreetResult struct {
+	Total   int               // distinct users in the batch
+	Greeted []string          // user IDs greeted, in completion order
+	Failed  map[string]string // user ID -> error of its GreetUser child
+}
+
+// GreetUsers greets every user with a GreetUser child workflow, at most
+// maxConcurrentGreetings at a time. A failed greeting is recorded in the result
+// and the batch carries on; GreetUsers itself only fails when it can't run.
+// Repeated user IDs are greeted once.
+func GreetUsers(ctx workflow.Context, userIDs []string) (*BatchGreetResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	seen := make(map[string]bool, len(userIDs))
+	var users []string
+	for _, userID := range userIDs {
+		if !seen[userID] {
+			seen[userID] = true
+			users = append(users, userID)
+		}
+	}
+	logger.Info("GreetUsers workflow started", "Users", len(users))
+
+	result := BatchGreetResult{Total: len(users), Failed: map[string]string{}}
+	err := workflow.SetQueryHandler(ctx, "get-progress", func() (BatchGreetResult, error) {
+		return result, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Each child completes into the selector; a free slot starts the next user
+	parentID := workflow.GetInfo(ctx).WorkflowExecution.ID
+	selector := workflow.NewSelector(ctx)
+	next, running := 0, 0
+	startNext := func() {
+		userID := users[next]
+		next++
+		running++
+		childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+			WorkflowID: parentID + "-" + userID,
+		})
+		future := workflow.ExecuteChildWorkflow(childCtx, GreetUser, GreetUserInput{UserID: userID})
+		selector.AddFuture(future, func(f workflow.Future) {
+			running--
+			var output GreetUserOutput
+			if err := f.Get(ctx, &output); err != nil {
+				logger.Warn("Greeting failed", "UserID", userID, "Error", err)
+				result.Failed[userID] = err.Error()
+				return
+			}
+			result.Greeted = append(result.Greeted, userID)
+		})
+	}
+
+	for next < len(users) && running < maxConcurrentGreetings {
+		startNext()
+	}
+	for running > 0 {
+		selector.Select(ctx)
+		if next < len(users) {
+			startNext()
+		}
+	}
+
+	logger.Info("GreetUsers workflow completed", "Greeted", len(result.Greeted), "Failed", len(result.Failed))
+	return &result, nil
+}
@@ -1,40 +1,84 @@
 package workflows
 
 import (
+	"strings"
 	"time"
+	_ "time/tzdata" // every worker resolves timezones the same way
 
 	"go-temporal-fast-course/greeting/activities"
+	"go-temporal-fast-course/shared/retry"
 
-	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/log"
 	"go.temporal.io/sdk/workflow"
 )
 
+// DayBoundaries are the local hours at which each part of the day starts.
+// Hours before Morning count as the previous evening.
+type DayBoundaries struct {
+	Morning   int
+	Afternoon int
+	Evening   int
+}
+
+// GreetingBoundaries is set by the worker at startup. Each greeting records the
+// boundaries it used, so a worker restarted with other ones still replays.
+var GreetingBoundaries = DayBoundaries{Morning: 0, Afternoon: 12, Evening: 18}
+
 type GreetUserInput struct {
 	UserID string
+	// ReplyTimeout is how long to wait for a "user-reply" signal after the
+	// greeting is sent. Zero skips the wait.
+	ReplyTimeout time.Duration
 }
 
 type GreetUserOutput struct {
+	Message   string
+	SentAt    time.Time
+	Success   bool
+	Language  string // ISO 639-1 code, one of supportedLanguages
+	TimeOfDay string // "morning", "afternoon" or "evening"
+	Timezone  string // location SentAt and TimeOfDay are expressed in
+	// Reply is the user's response, empty when none arrived within ReplyTimeout
+	Reply string
+}
+
+// SignalUserReply carries a UserReply to an interactive GreetUser
+const SignalUserReply = "user-reply"
+
+// UserReply is the user's response to the greeting
+type UserReply struct {
 	Message string
-	SentAt  time.Time
-	Success bool
+}
+
+// Conversation is returned by the "get-conversation" query. Greeting is empty
+// until the greeting is sent and Reply until the user answers.
+type Conversation struct {
+	Greeting  string
+	SentAt    time.Time
+	Reply     string
+	RepliedAt time.Time
+	TimedOut  bool // ReplyTimeout passed without a reply
 }
 
 func GreetUser(ctx workflow.Context, input GreetUserInput) (*GreetUserOutput, error) {
 	// Configure activity options (timeouts, retries)
 	activityOptions := workflow.ActivityOptions{
 		StartToCloseTimeout: 10 * time.Second, // Activity must complete within 10s
-		RetryPolicy: &temporal.RetryPolicy{
-			InitialInterval:    1 * time.Second,
-			BackoffCoefficient: 2.0,
-			MaximumInterval:    10 * time.Second,
-			MaximumAttempts:    3,
-		},
+		RetryPolicy:         retry.DefaultPolicy(),
 	}
 	ctx = workflow.WithActivityOptions(ctx, activityOptions)
 
 	logger := workflow.GetLogger(ctx)
 	logger.Info("GreetUser workflow started", "UserID", input.UserID)
 
+	var conversation Conversation
+	err := workflow.SetQueryHandler(ctx, "get-conversation", func() (Conversation, error) {
+		return conversation, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	// Step 1: Get User Details and Preferences
 	// Simultaneously execute both activities
 	var userDetails *activities.UserDetails
@@ -57,59 +101,168 @@ func GreetUser(ctx workflow.Context, input GreetUserInput) (*GreetUserOutput, er
 	logger.Info("GetUserDetails activity completed", "UserID", input.UserID)
 
 	// Step 2: Create Greeting Message
-	currentTime := workflow.Now(ctx)
-	hour := currentTime.Hour()
+	// Older histories used the worker clock's hour; new runs use the user's timezone
+	loc := time.Local
+	if workflow.GetVersion(ctx, "user-timezone", workflow.DefaultVersion, 1) == 1 {
+		loc = userLocation(userPreferences.Timezone, logger)
+	}
+	currentTime := workflow.Now(ctx).In(loc)
+
+	// The boundaries are recorded with SideEffect; older histories read them directly
+	boundaries := GreetingBoundaries
+	if workflow.GetVersion(ctx, "greeting-boundaries", workflow.DefaultVersion, 1) >= 1 {
+		err = workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+			return GreetingBoundaries
+		}).Get(&boundaries)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Workflow logic
-	message := formatMessage(hour, *userDetails, userPreferences.Language)
+	timeOfDay := timeOfDayForHour(currentTime.Hour(), boundaries)
+	language := greetingLanguage(userPreferences.Language, logger)
+	message := formatMessage(timeOfDay, *userDetails, language)
 
 	// Step 3: Send Greeting
-	err := workflow.ExecuteActivity(ctx, "SendGreeting", userDetails.Email, message).Get(ctx, nil)
+	err = workflow.ExecuteActivity(ctx, "SendGreeting", userDetails.Email, message).Get(ctx, nil)
 	if err != nil {
 		logger.Error("SendGreeting activity failed", "Error", err)
 		return nil, err
 	}
 
 	// Step 4: Log Greeting
-	sendAt := workflow.Now(ctx)
+	sendAt := workflow.Now(ctx).In(loc)
+	conversation.Greeting, conversation.SentAt = message, sendAt
 	err = workflow.ExecuteActivity(ctx, "LogGreeting", input.UserID, message).Get(ctx, nil)
 	if err != nil {
 		logger.Error("LogGreeting activity failed", "Error", err)
 		return nil, err
 	}
 
+	// Step 5: Wait for the user's reply (interactive mode only)
+	if input.ReplyTimeout > 0 {
+		waitForReply(ctx, input.ReplyTimeout, &conversation, loc)
+	}
+
 	logger.Info("GreetUser workflow completed successfully")
 
 	// Log Greeting
 	output := GreetUserOutput{
-		Message: message,
-		SentAt:  sendAt,
-		Success: true,
+		Message:   message,
+		SentAt:    sendAt,
+		Success:   true,
+		Language:  language,
+		TimeOfDay: timeOfDay,
+		Timezone:  loc.String(),
+		Reply:     conversation.Reply,
 	}
 
 	return &output, nil
 }
-func formatMessage(hour int, userDetails activities.UserDetails, language string) string {
+
+// waitForReply records the first "user-reply" signal that arrives within
+// timeout in conversation. Replies after the timeout are dropped with the run.
+func waitForReply(ctx workflow.Context, timeout time.Duration, conversation *Conversation, loc *time.Location) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("Waiting for user reply", "Timeout", timeout)
+
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalUserReply), func(ch workflow.ReceiveChannel, more bool) {
+		var reply UserReply
+		ch.Receive(ctx, &reply)
+		conversation.Reply = reply.Message
+		conversation.RepliedAt = workflow.Now(ctx).In(loc)
+		logger.Info("User replied", "Reply", reply.Message)
+	})
+	selector.AddFuture(workflow.NewTimer(timerCtx, timeout), func(f workflow.Future) {
+		conversation.TimedOut = true
+		logger.Info("No user reply before timeout", "Timeout", timeout)
+	})
+	selector.Select(ctx)
+}
+
+// userLocation resolves the user's timezone, falling back to UTC when it is
+// empty or unknown so a bad preference never fails the greeting
+func userLocation(name string, logger log.Logger) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		logger.Warn("Unknown user timezone, using UTC", "Timezone", name, "Error", err)
+		return time.UTC
+	}
+	return loc
+}
+
+func timeOfDayForHour(hour int, b DayBoundaries) string {
+	switch {
+	case hour < b.Morning:
+		return "evening"
+	case hour < b.Afternoon:
+		return "morning"
+	case hour < b.Evening:
+		return "afternoon"
+	}
+	return "evening"
+}
+
+// defaultLanguage is used when the user has no language preference or an
+// unsupported one
+const defaultLanguage = "en"
+
+// supportedLanguages are the ISO 639-1 codes formatMessage has greetings for
+var supportedLanguages = map[string]bool{"en": true, "es": true, "fr": true}
+
+// greetingLanguage normalizes the preferred language to a lowercase ISO 639-1
+// code, so "ES", " es" and "es" all greet in Spanish. Unsupported codes fall
+// back to English with a warning.
+func greetingLanguage(language string, logger log.Logger) string {
+	code := strings.ToLower(strings.TrimSpace(language))
+	if code == "" {
+		return defaultLanguage
+	}
+	if !supportedLanguages[code] {
+		logger.Warn("Unsupported user language, using default", "Language", language, "Default", defaultLanguage)
+		return defaultLanguage
+	}
+	return code
+}
+
+func formatMessage(timeOfDay string, userDetails activities.UserDetails, language string) string {
 	var greeting string
-	if language == "ES" {
-		if hour < 12 {
+	switch language {
+	case "es":
+		switch timeOfDay {
+		case "morning":
 			greeting = "¡Buenos días"
-		} else if hour < 18 {
+		case "afternoon":
 			greeting = "¡Buenas tardes"
-		} else {
+		default:
 			greeting = "¡Buenas noches"
 		}
-		message := greeting + ", " + userDetails.FirstName + " " + userDetails.LastName + "!"
-		return message
-	} else {
-		if hour < 12 {
+	case "fr":
+		switch timeOfDay {
+		case "morning":
+			greeting = "Bonjour"
+		case "afternoon":
+			greeting = "Bon après-midi"
+		default:
+			greeting = "Bonsoir"
+		}
+	default:
+		switch timeOfDay {
+		case "morning":
 			greeting = "Good Morning"
-		} else if hour < 18 {
+		case "afternoon":
 			greeting = "Good Afternoon"
-		} else {
+		default:
 			greeting = "Good Evening"
 		}
-		message := greeting + ", " + userDetails.FirstName + " " + userDetails.LastName + "!"
-		return message
 	}
+	message := greeting + ", " + userDetails.FirstName + " " + userDetails.LastName + "!"
+	return message
 }
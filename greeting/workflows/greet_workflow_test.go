@@ -0,0 +1,144 @@
+package workflows
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+
+	"go-temporal-fast-course/greeting/activities"
+)
+
+func TestTimeOfDayForHour(t *testing.T) {
+	defaults := DayBoundaries{Morning: 0, Afternoon: 12, Evening: 18}
+	early := DayBoundaries{Morning: 6, Afternoon: 12, Evening: 18}
+	tests := []struct {
+		hour       int
+		boundaries DayBoundaries
+		want       string
+	}{
+		{0, defaults, "morning"},
+		{11, defaults, "morning"},
+		{12, defaults, "afternoon"},
+		{17, defaults, "afternoon"},
+		{18, defaults, "evening"},
+		{23, defaults, "evening"},
+		{5, early, "evening"},
+		{6, early, "morning"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, timeOfDayForHour(tt.hour, tt.boundaries), "hour %d with %+v", tt.hour, tt.boundaries)
+	}
+}
+
+func TestGreetUserBoundaries(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	require.NoError(t, err)
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, time.March, 4, hour, minute, 0, 0, madrid)
+	}
+
+	tests := []struct {
+		language string
+		at       time.Time
+		want     string
+	}{
+		{"en", day(0, 0), "Good Morning, John Doe!"},
+		{"en", day(11, 59), "Good Morning, John Doe!"},
+		{"en", day(12, 0), "Good Afternoon, John Doe!"},
+		{"en", day(17, 59), "Good Afternoon, John Doe!"},
+		{"en", day(18, 0), "Good Evening, John Doe!"},
+		{"ES", day(0, 0), "¡Buenos días, John Doe!"},
+		{"ES", day(11, 59), "¡Buenos días, John Doe!"},
+		{"ES", day(12, 0), "¡Buenas tardes, John Doe!"},
+		{"ES", day(17, 59), "¡Buenas tardes, John Doe!"},
+		{"ES", day(18, 0), "¡Buenas noches, John Doe!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.language+" "+tt.at.Format("15:04"), func(t *testing.T) {
+			output := runGreetUser(t, tt.at, activities.UserPreferences{Language: tt.language, Timezone: "Europe/Madrid"})
+			require.Equal(t, tt.want, output.Message)
+			require.Equal(t, "Europe/Madrid", output.Timezone)
+		})
+	}
+}
+
+// The hour is taken in the user's timezone: 10:00 UTC is 11:00 in Madrid
+// (morning) but 05:00 in New York, which is evening with a 6am morning
+func TestGreetUserUsesTimezoneAndConfiguredBoundaries(t *testing.T) {
+	previous := GreetingBoundaries
+	GreetingBoundaries = DayBoundaries{Morning: 6, Afternoon: 12, Evening: 18}
+	t.Cleanup(func() { GreetingBoundaries = previous })
+
+	at := time.Date(2024, time.March, 4, 10, 0, 0, 0, time.UTC)
+	madrid := runGreetUser(t, at, activities.UserPreferences{Language: "en", Timezone: "Europe/Madrid"})
+	require.Equal(t, "morning", madrid.TimeOfDay)
+	newYork := runGreetUser(t, at, activities.UserPreferences{Language: "es", Timezone: "America/New_York"})
+	require.Equal(t, "evening", newYork.TimeOfDay)
+	require.Equal(t, "¡Buenas noches, John Doe!", newYork.Message)
+}
+
+// warningLog is a log.Logger that keeps the warning messages
+type warningLog struct {
+	warnings []string
+}
+
+func (l *warningLog) Debug(string, ...interface{}) {}
+func (l *warningLog) Info(string, ...interface{})  {}
+func (l *warningLog) Warn(msg string, _ ...interface{}) {
+	l.warnings = append(l.warnings, msg)
+}
+func (l *warningLog) Error(string, ...interface{}) {}
+
+func TestGreetingLanguage(t *testing.T) {
+	tests := []struct {
+		language string
+		want     string
+		warns    bool
+	}{
+		{"es", "es", false},
+		{"ES", "es", false},
+		{" Es ", "es", false},
+		{"FR", "fr", false},
+		{"fR\n", "fr", false},
+		{"EN", "en", false},
+		{"", "en", false},
+		{"  ", "en", false},
+		{"DE", "en", true},
+		{"spanish", "en", true},
+	}
+	for _, tt := range tests {
+		logger := &warningLog{}
+		require.Equal(t, tt.want, greetingLanguage(tt.language, logger), "language %q", tt.language)
+		require.Equal(t, tt.warns, len(logger.warnings) > 0, "language %q", tt.language)
+	}
+}
+
+func TestGreetUserInFrench(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	require.NoError(t, err)
+	for hour, want := range map[int]string{9: "Bonjour", 15: "Bon après-midi", 20: "Bonsoir"} {
+		at := time.Date(2024, time.March, 4, hour, 0, 0, 0, madrid)
+		output := runGreetUser(t, at, activities.UserPreferences{Language: " FR", Timezone: "Europe/Madrid"})
+		require.Equal(t, want+", John Doe!", output.Message)
+	}
+}
+
+// runGreetUser runs GreetUser at the given time for a user with prefs
+func runGreetUser(t *testing.T, at time.Time, prefs activities.UserPreferences) *GreetUserOutput {
+	t.Helper()
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetStartTime(at)
+	env.RegisterActivity(&activities.GreetActivities{Sleep: func(time.Duration) {}})
+	env.OnActivity("GetUserPreferencesId", mock.Anything, mock.Anything).Return(&prefs, nil)
+
+	env.ExecuteWorkflow(GreetUser, GreetUserInput{UserID: "user-1"})
+
+	require.True(t, env.IsWorkflowCompleted())
+	var output GreetUserOutput
+	require.NoError(t, env.GetWorkflowResult(&output))
+	return &output
+}
@@ -0,0 +1,87 @@
+package activities
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState string
+
+const (
+	// BreakerClosed lets every call through and counts consecutive failures
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails calls fast until the cooldown has passed
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets calls through again after the cooldown; the next
+	// outcome closes the breaker or opens it for another cooldown
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// CircuitBreaker stops calls to a failing dependency. It is shared by every
+// activity execution on a worker, so once the dependency is known to be down
+// retries fail fast instead of each order probing it on its own.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int       // consecutive failures while closed
+	openedAt time.Time // when the breaker last opened
+}
+
+// NewCircuitBreaker creates a closed breaker that opens after threshold
+// consecutive failures and stays open for cooldown
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: max(threshold, 1), cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow reports whether a call may go to the dependency. Once the cooldown has
+// passed the breaker turns half-open and lets calls through again; calls that
+// start at the same time all get through.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = BreakerHalfOpen
+	}
+	return b.state != BreakerOpen
+}
+
+// Record reports the outcome of an allowed call and returns the state after
+// it, and whether the call changed it. A success closes the breaker. A failure
+// opens it when half-open or at the threshold; a failure of a call that started
+// before the breaker opened does not extend the cooldown.
+func (b *CircuitBreaker) Record(failed bool) (BreakerState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	previous := b.state
+	switch {
+	case !failed:
+		b.state = BreakerClosed
+		b.failures = 0
+	case b.state == BreakerHalfOpen:
+		b.open()
+	case b.state == BreakerClosed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open()
+		}
+	}
+	return b.state, b.state != previous
+}
+
+// State returns the current state without moving an expired open breaker to
+// half-open
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+	b.failures = 0
+}
@@ -0,0 +1,59 @@
+package activities
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	breaker := NewCircuitBreaker(1, 20*time.Millisecond)
+	state, changed := breaker.Record(true)
+	require.Equal(t, BreakerOpen, state)
+	require.True(t, changed)
+	require.False(t, breaker.Allow())
+
+	time.Sleep(20 * time.Millisecond)
+	require.True(t, breaker.Allow())
+	require.Equal(t, BreakerHalfOpen, breaker.State())
+	state, _ = breaker.Record(true)
+	require.Equal(t, BreakerOpen, state)
+	require.False(t, breaker.Allow())
+}
+
+// Consecutive gateway timeouts open the breaker, which then fails charges
+// without calling the gateway; after the cooldown a successful charge closes it
+func TestProcessPaymentCircuitBreaker(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+	breaker := NewCircuitBreaker(2, cooldown)
+	payments := NewPaymentActivities(0, breaker, FailureConfig{PaymentTimeoutRate: 1})
+	gatewayCalls := 0
+	payments.Latency = Latency{Sleep: func(time.Duration) { gatewayCalls++ }}
+	env := newActivityEnv(Set{Payment: payments})
+	charge := func(n int) error {
+		_, err := env.ExecuteActivity(ActivityProcessPayment, types.PaymentRequest{
+			OrderID: "ORDER-1", Amount: 10, Currency: "USD", IdempotencyKey: fmt.Sprintf("pay-%d", n),
+		})
+		return err
+	}
+
+	require.ErrorContains(t, charge(1), "gateway timeout")
+	require.Equal(t, BreakerClosed, breaker.State())
+	require.ErrorContains(t, charge(2), "gateway timeout")
+	require.Equal(t, BreakerOpen, breaker.State())
+
+	require.ErrorContains(t, charge(3), "payment gateway circuit open")
+	require.Equal(t, 2, gatewayCalls)
+
+	// The gateway is back; the first call after the cooldown probes it
+	payments.failures = FailureConfig{}
+	time.Sleep(cooldown)
+	require.NoError(t, charge(4))
+	require.Equal(t, 3, gatewayCalls)
+	require.Equal(t, BreakerClosed, breaker.State())
+	require.NoError(t, charge(5))
+}
@@ -0,0 +1,142 @@
+package activities
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// EventBroker delivers a serialized event to a topic on a message broker
+type EventBroker interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+// EventActivities publishes order lifecycle events
+type EventActivities struct {
+	// Broker receives the events; nil discards them like NoopBroker
+	Broker EventBroker
+}
+
+// Publish sends the event as JSON to topic. Broker errors are retried.
+func (a *EventActivities) Publish(ctx context.Context, topic string, event types.OrderEvent) (err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Publishing order event", "orderID", event.OrderID, "type", event.Type, "topic", topic)
+
+	if a.Broker == nil {
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return &types.ValidationError{Msg: fmt.Sprintf("encode %s event: %v", event.Type, err)}
+	}
+	if err := a.Broker.Publish(ctx, topic, payload); err != nil {
+		return fmt.Errorf("publishing %s event for order %s: %w", event.Type, event.OrderID, err)
+	}
+
+	logger.Info("Order event published", "orderID", event.OrderID, "type", event.Type)
+	return nil
+}
+
+// NoopBroker discards every event; the default when no broker is configured
+type NoopBroker struct{}
+
+// Publish does nothing
+func (NoopBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	return nil
+}
+
+// NATSBroker publishes to a NATS server with the plain-text client protocol.
+// Each Publish opens its own connection and waits for the server's PONG, so an
+// event is only acknowledged once the server has processed it.
+type NATSBroker struct {
+	Addr    string        // host:port of the NATS server
+	Timeout time.Duration // per publish; 0 means 5s
+}
+
+// Publish sends payload to the NATS subject topic
+func (b *NATSBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	timeout := b.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", b.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	r := bufio.NewReader(conn)
+	// The server greets with INFO before accepting commands
+	if line, err := r.ReadString('\n'); err != nil {
+		return err
+	} else if !strings.HasPrefix(line, "INFO") {
+		return fmt.Errorf("unexpected NATS greeting: %q", strings.TrimSpace(line))
+	}
+
+	msg := fmt.Sprintf("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"order-worker\"}\r\nPUB %s %d\r\n%s\r\nPING\r\n", topic, len(payload), payload)
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS server: %s", strings.TrimPrefix(line, "-ERR "))
+		}
+	}
+}
+
+// FakeBroker records events instead of publishing them, for tests
+type FakeBroker struct {
+	mu        sync.Mutex
+	Published []PublishedEvent
+	Err       error // returned from every Publish when set
+}
+
+// PublishedEvent is an event captured by FakeBroker
+type PublishedEvent struct {
+	Topic string
+	Event types.OrderEvent
+}
+
+// Publish decodes and records the event and returns Err
+func (b *FakeBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	var event types.OrderEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Published = append(b.Published, PublishedEvent{Topic: topic, Event: event})
+	return b.Err
+}
+
+// Events returns a copy of the recorded events
+func (b *FakeBroker) Events() []PublishedEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]PublishedEvent(nil), b.Published...)
+}
@@ -0,0 +1,34 @@
+package activities
+
+import "math/rand"
+
+// FailureConfig holds the probabilities (0 to 1) of the simulated failures in
+// the activities. 0 never fails and 1 always fails, so demos can force the happy
+// path or a specific compensation path. The zero value never fails.
+type FailureConfig struct {
+	InventoryErrorRate    float64 // ReserveStock transient error, per SKU
+	PaymentTimeoutRate    float64 // ProcessPayment gateway timeout (retryable)
+	PaymentDeclineRate    float64 // ProcessPayment card decline (permanent)
+	StatusUpdateErrorRate float64 // UpdateOrderStatus database timeout
+	ShipmentErrorRate     float64 // CreateShipment carrier outage
+	EmailErrorRate        float64 // EmailChannel send failure
+	SMSErrorRate          float64 // SMSChannel send failure
+}
+
+// DefaultFailureConfig returns the failure rates the course demos use
+func DefaultFailureConfig() FailureConfig {
+	return FailureConfig{
+		InventoryErrorRate:    0.05,
+		PaymentTimeoutRate:    0.2,
+		PaymentDeclineRate:    0.05,
+		StatusUpdateErrorRate: 0.05,
+		ShipmentErrorRate:     0.05,
+		EmailErrorRate:        0.1,
+		SMSErrorRate:          0.05,
+	}
+}
+
+// fails reports whether a simulated failure with the given rate happens
+func fails(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
@@ -0,0 +1,104 @@
+package activities
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// A rate of 1 makes the simulated failure happen on every call
+func TestFailureRateOneAlwaysFails(t *testing.T) {
+	noDelay := Latency{Sleep: NoSleep}
+	notifyVia := func(prefs types.UserPreferences, c NotificationChannel) *NotificationActivities {
+		return &NotificationActivities{
+			Channels:    map[string]NotificationChannel{prefs.Channel: c},
+			Preferences: func(string) types.UserPreferences { return prefs },
+		}
+	}
+	payments := func(failures FailureConfig) *PaymentActivities {
+		p := NewPaymentActivities(0, nil, failures)
+		p.Latency = noDelay
+		return p
+	}
+	charge := func(env *testsuite.TestActivityEnvironment, i int) error {
+		_, err := env.ExecuteActivity(ActivityProcessPayment, types.PaymentRequest{
+			OrderID: "ORDER-1", Amount: 10, Currency: "USD", IdempotencyKey: fmt.Sprintf("pay-%d", i),
+		})
+		return err
+	}
+	cancelNotice := func(env *testsuite.TestActivityEnvironment, _ int) error {
+		_, err := env.ExecuteActivity(ActivitySendCancellationEmail, "ORDER-1", "out of stock")
+		return err
+	}
+
+	tests := []struct {
+		name string
+		set  Set
+		call func(env *testsuite.TestActivityEnvironment, i int) error
+		want string
+	}{
+		{
+			name: "InventoryErrorRate",
+			set:  Set{Inventory: NewInventoryActivities(DemoWarehouses(), FailureConfig{InventoryErrorRate: 1})},
+			call: func(env *testsuite.TestActivityEnvironment, _ int) error {
+				_, err := env.ExecuteActivity(ActivityReserveStock, "ORDER-1", []types.LineItem{{SKU: "BOOK-001", Quantity: 1}}, PrimaryWarehouse)
+				return err
+			},
+			want: "temporary inventory system error",
+		},
+		{
+			name: "PaymentTimeoutRate",
+			set:  Set{Payment: payments(FailureConfig{PaymentTimeoutRate: 1})},
+			call: charge,
+			want: "gateway timeout",
+		},
+		{
+			name: "PaymentDeclineRate",
+			set:  Set{Payment: payments(FailureConfig{PaymentDeclineRate: 1})},
+			call: charge,
+			want: "card declined",
+		},
+		{
+			name: "StatusUpdateErrorRate",
+			set:  Set{Order: &OrderActivities{Latency: noDelay, Failures: FailureConfig{StatusUpdateErrorRate: 1}}},
+			call: func(env *testsuite.TestActivityEnvironment, _ int) error {
+				_, err := env.ExecuteActivity(ActivityUpdateOrderStatus, "ORDER-1", "COMPLETED")
+				return err
+			},
+			want: "database connection timeout",
+		},
+		{
+			name: "ShipmentErrorRate",
+			set:  Set{Shipping: &ShippingActivities{Latency: noDelay, Failures: FailureConfig{ShipmentErrorRate: 1}}},
+			call: func(env *testsuite.TestActivityEnvironment, _ int) error {
+				_, err := env.ExecuteActivity(ActivityCreateShipment, "ORDER-1", []types.LineItem{{SKU: "BOOK-001", Quantity: 1}})
+				return err
+			},
+			want: "carrier service unavailable",
+		},
+		{
+			name: "EmailErrorRate",
+			set:  Set{Notification: notifyVia(types.UserPreferences{Channel: "email", Email: "jane@example.com"}, &EmailChannel{Latency: noDelay, FailureRate: 1})},
+			call: cancelNotice,
+			want: "email service unavailable",
+		},
+		{
+			name: "SMSErrorRate",
+			set:  Set{Notification: notifyVia(types.UserPreferences{Channel: "sms", Phone: "+1-555-0100"}, &SMSChannel{Latency: noDelay, FailureRate: 1})},
+			call: cancelNotice,
+			want: "sms gateway unavailable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newActivityEnv(tt.set)
+			for i := 0; i < 5; i++ {
+				require.ErrorContains(t, tt.call(env, i), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package activities
+
+import (
+	"sync"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// DemoStock returns the starting quantities for the SKUs used by the starter and README examples.
+// LAST-001 has a single unit for the starter's contend-last-unit example.
+func DemoStock() map[string]int {
+	return map[string]int{
+		"BOOK-001": 100,
+		"PEN-042":  500,
+		"ITEM-999": 50,
+		"LAST-001": 1,
+	}
+}
+
+// DemoWarehouses returns a primary and a secondary warehouse, each seeded with DemoStock
+func DemoWarehouses() map[string]*InventoryStore {
+	return map[string]*InventoryStore{
+		PrimaryWarehouse:   NewInventoryStore(DemoStock()),
+		SecondaryWarehouse: NewInventoryStore(DemoStock()),
+	}
+}
+
+// InventoryStore is a concurrency-safe in-memory stock ledger. Reservations are
+// tracked per order so releases return exactly what the order took.
+type InventoryStore struct {
+	mu           sync.Mutex
+	stock        map[string]int             // SKU -> units on hand
+	reservations map[string]map[string]int  // orderID -> SKU -> units reserved
+	applied      map[string]map[string]bool // orderID -> request lines already reserved
+}
+
+// NewInventoryStore creates a store seeded with the given SKU quantities
+func NewInventoryStore(seed map[string]int) *InventoryStore {
+	stock := make(map[string]int, len(seed))
+	for sku, qty := range seed {
+		stock[sku] = qty
+	}
+	return &InventoryStore{
+		stock:        stock,
+		reservations: make(map[string]map[string]int),
+		applied:      make(map[string]map[string]bool),
+	}
+}
+
+// Available returns the units on hand for each SKU of the items
+func (s *InventoryStore) Available(items []types.LineItem) map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	availability := make(map[string]int, len(items))
+	for _, item := range items {
+		availability[item.SKU] = s.stock[item.SKU]
+	}
+	return availability
+}
+
+// Reserve takes item.Quantity units of item.SKU for the order. lineID names
+// the line of the reservation request; Reserve is idempotent per lineID so
+// activity retries never reserve twice, while a later request for more of a
+// SKU the order already holds adds to it.
+func (s *InventoryStore) Reserve(orderID, lineID string, item types.LineItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.applied[orderID][lineID] {
+		return nil
+	}
+	if s.stock[item.SKU] < item.Quantity {
+		return &types.InsufficientInventoryError{OrderID: orderID, SKUs: []string{item.SKU}}
+	}
+
+	s.stock[item.SKU] -= item.Quantity
+	if s.reservations[orderID] == nil {
+		s.reservations[orderID] = make(map[string]int)
+		s.applied[orderID] = make(map[string]bool)
+	}
+	s.reservations[orderID][item.SKU] += item.Quantity
+	s.applied[orderID][lineID] = true
+	return nil
+}
+
+// Unreserve undoes the Reserve of lineID, if it was applied, so a retry of the
+// same request reserves the line again
+func (s *InventoryStore) Unreserve(orderID, lineID string, item types.LineItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.applied[orderID][lineID] {
+		return
+	}
+	qty := min(item.Quantity, s.reservations[orderID][item.SKU])
+	s.stock[item.SKU] += qty
+	s.reservations[orderID][item.SKU] -= qty
+	delete(s.applied[orderID], lineID)
+}
+
+// Release returns everything the order reserved to stock
+func (s *InventoryStore) Release(orderID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sku, qty := range s.reservations[orderID] {
+		s.stock[sku] += qty
+	}
+	delete(s.reservations, orderID)
+	delete(s.applied, orderID)
+}
+
+// ReleaseItems returns part of the order's reservation, never more than it holds
+func (s *InventoryStore) ReleaseItems(orderID string, items []types.LineItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reserved := s.reservations[orderID]
+	for _, item := range items {
+		qty := min(item.Quantity, reserved[item.SKU])
+		if qty <= 0 {
+			continue
+		}
+		s.stock[item.SKU] += qty
+		reserved[item.SKU] -= qty
+	}
+}
@@ -0,0 +1,46 @@
+package activities
+
+import (
+	"context"
+	"time"
+)
+
+// SleepFunc pauses the calling activity for d
+type SleepFunc func(d time.Duration)
+
+// Latency simulates the response time of the systems the activities stand in
+// for. It is embedded in every activity struct that sleeps; tests set Sleep to
+// a no-op so they don't wait for the simulated delays.
+type Latency struct {
+	Sleep SleepFunc // defaults to time.Sleep
+}
+
+// sleep waits d with Sleep, or time.Sleep when none is set
+func (l Latency) sleep(d time.Duration) {
+	if l.Sleep == nil {
+		time.Sleep(d)
+		return
+	}
+	l.Sleep(d)
+}
+
+// wait is sleep for activities that must stop when ctx is done: it returns
+// ctx.Err() as soon as ctx is cancelled or past its deadline. A Sleep set by
+// tests is called instead of waiting, so NoSleep makes it return at once.
+func (l Latency) wait(ctx context.Context, d time.Duration) error {
+	if l.Sleep != nil {
+		l.Sleep(d)
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// NoSleep is a SleepFunc that returns at once
+func NoSleep(time.Duration) {}
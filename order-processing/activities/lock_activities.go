@@ -0,0 +1,49 @@
+package activities
+
+import (
+	"context"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// Signals exchanged between order workflows and InventoryLockWorkflow
+const (
+	SignalRequestLock = "request-lock"
+	SignalLockGranted = "lock-granted"
+	SignalReleaseLock = "release-lock"
+)
+
+// InventoryLockWorkflowID is the workflow ID of the lock serializing
+// reservations of sku. There is at most one running lock workflow per SKU.
+func InventoryLockWorkflowID(sku string) string {
+	return "inventory-lock-" + sku
+}
+
+// LockActivities talks to the per-SKU InventoryLockWorkflow on behalf of order
+// workflows, which cannot signal-with-start a workflow themselves
+type LockActivities struct {
+	Client client.Client
+}
+
+// RequestInventoryLock queues req on the SKU's lock workflow, starting it on
+// the activity's task queue if it is not running. The grant arrives later as a
+// "lock-granted" signal to the requesting workflow. Signal-with-start is
+// idempotent per request only in effect: a retried request is queued twice and
+// the lock workflow skips the duplicate.
+func (a *LockActivities) RequestInventoryLock(ctx context.Context, req types.LockRequest) (err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Requesting inventory lock", "sku", req.SKU, "workflowID", req.WorkflowID)
+
+	workflowID := InventoryLockWorkflowID(req.SKU)
+	_, err = a.Client.SignalWithStartWorkflow(ctx, workflowID, SignalRequestLock, req,
+		client.StartWorkflowOptions{
+			ID:        workflowID,
+			TaskQueue: activity.GetInfo(ctx).TaskQueue,
+		},
+		"InventoryLockWorkflow", req.SKU, []types.LockRequest(nil))
+	return err
+}
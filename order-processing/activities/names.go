@@ -0,0 +1,42 @@
+package activities
+
+// Activity names shared by worker registration and workflow invocation. The
+// worker registers each method under its constant, so renaming a method or a
+// constant can't leave a workflow calling an activity that isn't registered.
+const (
+	ActivityReserveStock           = "ReserveStock"
+	ActivityReleaseStock           = "ReleaseStock"
+	ActivityReleaseStockItems      = "ReleaseStockItems"
+	ActivityFetchInventorySnapshot = "FetchInventorySnapshot"
+	ActivityRequestInventoryLock   = "RequestInventoryLock"
+
+	ActivityProcessPayment = "ProcessPayment"
+	ActivityRefundPayment  = "RefundPayment"
+
+	ActivityFetchCustomerProfile   = "FetchCustomerProfile"
+	ActivityFetchCustomerEmail     = "FetchCustomerEmail"
+	ActivityFetchNotificationPrefs = "FetchNotificationPrefs"
+	ActivityFetchRecommendations   = "FetchRecommendations"
+
+	ActivityUpdateOrderStatus = "UpdateOrderStatus"
+	ActivityPersistStatus     = "PersistStatus"
+	ActivityLoadStatus        = "LoadStatus"
+	ActivityMarkHandedOff     = "MarkHandedOff"
+
+	ActivitySelectCarrier       = "SelectCarrier"
+	ActivityCreateShippingLabel = "CreateShippingLabel"
+	ActivityCreateShipment      = "CreateShipment"
+	ActivityCancelShipment      = "CancelShipment"
+
+	ActivityCalculateShippingCost = "CalculateShippingCost"
+
+	ActivityCalculateTax  = "CalculateTax"
+	ActivityValidatePromo = "ValidatePromo"
+	ActivityConvert       = "Convert"
+
+	ActivitySendOrderConfirmation = "SendOrderConfirmation"
+	ActivitySendCancellationEmail = "SendCancellationEmail"
+
+	ActivityNotifyCompletion = "NotifyCompletion"
+	ActivityPublishEvent     = "PublishEvent"
+)
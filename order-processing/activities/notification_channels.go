@@ -0,0 +1,95 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// NotificationChannel delivers a message to a recipient (an email address or phone number)
+type NotificationChannel interface {
+	Send(ctx context.Context, recipient, message string) error
+}
+
+// EmailChannel simulates an email provider
+type EmailChannel struct {
+	Latency
+	FailureRate float64
+}
+
+// Send simulates sending an email
+func (c *EmailChannel) Send(ctx context.Context, recipient, message string) error {
+	activity.GetLogger(ctx).Info("Sending email", "to", recipient)
+
+	// Simulate email sending
+	c.sleep(200 * time.Millisecond)
+
+	// Simulate occasional failures
+	if fails(c.FailureRate) {
+		return fmt.Errorf("email service unavailable")
+	}
+	return nil
+}
+
+// SMSChannel simulates an SMS gateway
+type SMSChannel struct {
+	Latency
+	FailureRate float64
+}
+
+// Send simulates sending a text message
+func (c *SMSChannel) Send(ctx context.Context, recipient, message string) error {
+	activity.GetLogger(ctx).Info("Sending SMS", "to", recipient)
+
+	// Simulate gateway call
+	c.sleep(100 * time.Millisecond)
+
+	// Simulate occasional failures
+	if fails(c.FailureRate) {
+		return fmt.Errorf("sms gateway unavailable")
+	}
+	return nil
+}
+
+// FakeChannel records messages instead of sending them, for tests
+type FakeChannel struct {
+	mu   sync.Mutex
+	Sent []SentNotification
+	Err  error // returned from every Send when set
+}
+
+// SentNotification is a message captured by FakeChannel
+type SentNotification struct {
+	Recipient string
+	Message   string
+}
+
+// Send records the message and returns Err
+func (c *FakeChannel) Send(ctx context.Context, recipient, message string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Sent = append(c.Sent, SentNotification{Recipient: recipient, Message: message})
+	return c.Err
+}
+
+// Messages returns a copy of the recorded messages
+func (c *FakeChannel) Messages() []SentNotification {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]SentNotification(nil), c.Sent...)
+}
+
+// simulatedPreferences stands in for a customer-profile lookup: most customers
+// prefer email, some SMS
+func simulatedPreferences(orderID string) types.UserPreferences {
+	if rand.Float32() < 0.2 {
+		return types.UserPreferences{Channel: "sms", Phone: "+1-555-0100"}
+	}
+	return types.UserPreferences{Channel: "email", Email: "customer@example.com"}
+}
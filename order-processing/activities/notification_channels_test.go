@@ -0,0 +1,120 @@
+package activities
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// newFakeNotifications returns notification activities whose email and SMS
+// channels are fakes, for a customer with prefs
+func newFakeNotifications(prefs types.UserPreferences) (*NotificationActivities, *FakeChannel, *FakeChannel) {
+	email, sms := &FakeChannel{}, &FakeChannel{}
+	notifications := &NotificationActivities{
+		Channels:    map[string]NotificationChannel{"email": email, "sms": sms},
+		Preferences: func(string) types.UserPreferences { return prefs },
+	}
+	return notifications, email, sms
+}
+
+// Without an address on file the confirmation goes to the email the workflow passed
+func TestSendOrderConfirmationRecordsEmail(t *testing.T) {
+	notifications, email, sms := newFakeNotifications(types.UserPreferences{Channel: "email"})
+	env := newActivityEnv(Set{Notification: notifications})
+
+	_, err := env.ExecuteActivity(ActivitySendOrderConfirmation, "ORDER-1", "jane@example.com", 24.99, "USD", (*types.GiftInfo)(nil))
+	require.NoError(t, err)
+	require.Equal(t, []SentNotification{{Recipient: "jane@example.com", Message: "Order ORDER-1 confirmed, charged 24.99 USD"}}, email.Messages())
+	require.Empty(t, sms.Messages())
+}
+
+func TestSendCancellationEmailRoutesToSMS(t *testing.T) {
+	notifications, email, sms := newFakeNotifications(types.UserPreferences{Channel: "sms", Phone: "+1-555-0100"})
+	env := newActivityEnv(Set{Notification: notifications})
+
+	_, err := env.ExecuteActivity(ActivitySendCancellationEmail, "ORDER-1", "payment timeout")
+	require.NoError(t, err)
+	require.Equal(t, []SentNotification{{Recipient: "+1-555-0100", Message: "Order ORDER-1 cancelled: payment timeout"}}, sms.Messages())
+	require.Empty(t, email.Messages())
+}
+
+func TestNotificationChannelErrors(t *testing.T) {
+	notifications, email, _ := newFakeNotifications(types.UserPreferences{Channel: "email", Email: "jane@example.com"})
+	email.Err = errors.New("mailbox full")
+	env := newActivityEnv(Set{Notification: notifications})
+
+	_, err := env.ExecuteActivity(ActivitySendCancellationEmail, "ORDER-1", "out of stock")
+	require.ErrorContains(t, err, "mailbox full")
+	require.Len(t, email.Messages(), 1)
+
+	notifications.Preferences = func(string) types.UserPreferences { return types.UserPreferences{Channel: "pigeon"} }
+	_, err = env.ExecuteActivity(ActivitySendCancellationEmail, "ORDER-1", "out of stock")
+	require.ErrorContains(t, err, `no notification channel "pigeon"`)
+}
+
+// A gift order confirms to the buyer and, by email, to the recipient with the
+// sender's note, or a default one when there is none
+func TestSendOrderConfirmationForGift(t *testing.T) {
+	tests := []struct {
+		message string
+		note    string
+	}{
+		{"Happy birthday!", "Happy birthday!"},
+		{"", "Enjoy your gift!"},
+	}
+	for _, tt := range tests {
+		notifications, email, sms := newFakeNotifications(types.UserPreferences{Channel: "sms", Phone: "+1-555-0100"})
+		env := newActivityEnv(Set{Notification: notifications})
+
+		gift := &types.GiftInfo{RecipientEmail: "friend@example.com", Message: tt.message}
+		_, err := env.ExecuteActivity(ActivitySendOrderConfirmation, "ORDER-1", "jane@example.com", 24.99, "USD", gift)
+		require.NoError(t, err)
+		require.Equal(t, []SentNotification{{
+			Recipient: "+1-555-0100",
+			Message:   "Order ORDER-1 confirmed, charged 24.99 USD (gift for friend@example.com)",
+		}}, sms.Messages())
+		require.Equal(t, []SentNotification{{
+			Recipient: "friend@example.com",
+			Message:   "A gift is on its way to you (order ORDER-1). Note from the sender: " + tt.note,
+		}}, email.Messages())
+	}
+}
+
+// With no address for the buyer a gift order is still confirmed to the recipient
+func TestSendOrderConfirmationToGiftRecipientOnly(t *testing.T) {
+	notifications, email, sms := newFakeNotifications(types.UserPreferences{Channel: "email"})
+	env := newActivityEnv(Set{Notification: notifications})
+
+	gift := &types.GiftInfo{RecipientEmail: "friend@example.com", Message: "Happy birthday!"}
+	_, err := env.ExecuteActivity(ActivitySendOrderConfirmation, "ORDER-1", "", 24.99, "USD", gift)
+	require.NoError(t, err)
+	require.Equal(t, []SentNotification{{
+		Recipient: "friend@example.com",
+		Message:   "A gift is on its way to you (order ORDER-1). Note from the sender: Happy birthday!",
+	}}, email.Messages())
+	require.Empty(t, sms.Messages())
+}
+
+// A failed buyer send doesn't stop the recipient's, and the retry only resends
+// what its heartbeat doesn't list as done
+func TestSendOrderConfirmationSendsIndependently(t *testing.T) {
+	notifications, email, sms := newFakeNotifications(types.UserPreferences{Channel: "sms", Phone: "+1-555-0100"})
+	sms.Err = errors.New("carrier unavailable")
+	env := newActivityEnv(Set{Notification: notifications})
+
+	gift := &types.GiftInfo{RecipientEmail: "friend@example.com"}
+	_, err := env.ExecuteActivity(ActivitySendOrderConfirmation, "ORDER-1", "jane@example.com", 24.99, "USD", gift)
+	require.ErrorContains(t, err, "carrier unavailable")
+	require.Len(t, sms.Messages(), 1)
+	require.Len(t, email.Messages(), 1)
+
+	sms.Err = nil
+	env.SetHeartbeatDetails(confirmationProgress{Recipient: true})
+	_, err = env.ExecuteActivity(ActivitySendOrderConfirmation, "ORDER-1", "jane@example.com", 24.99, "USD", gift)
+	require.NoError(t, err)
+	require.Len(t, sms.Messages(), 2)
+	require.Len(t, email.Messages(), 1)
+}
@@ -2,112 +2,375 @@ package activities
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"go.temporal.io/sdk/activity"
+	"golang.org/x/time/rate"
 
 	"go-temporal-fast-course/order-processing/types"
 )
 
-// InventoryActivities contains inventory-related activities
-type InventoryActivities struct{}
+// Warehouses ReserveStock can take stock from. Orders reserve from the primary
+// and fall back to the secondary when the primary is unavailable.
+const (
+	PrimaryWarehouse   = "primary"
+	SecondaryWarehouse = "secondary"
+)
+
+// InventoryActivities contains inventory-related activities, backed by one
+// InventoryStore per warehouse
+type InventoryActivities struct {
+	Latency
+	warehouses map[string]*InventoryStore
+	failures   FailureConfig
+}
+
+// NewInventoryActivities creates inventory activities over the given stores,
+// keyed by warehouse. The map must contain PrimaryWarehouse.
+func NewInventoryActivities(warehouses map[string]*InventoryStore, failures FailureConfig) *InventoryActivities {
+	return &InventoryActivities{warehouses: warehouses, failures: failures}
+}
 
-// ReserveStock reserves inventory for an order
-func (a *InventoryActivities) ReserveStock(ctx context.Context, orderID string, items []types.LineItem) error {
+// store returns the stock ledger of the warehouse. An empty name is the
+// primary, for reservations scheduled before there were several warehouses.
+func (a *InventoryActivities) store(warehouse string) (*InventoryStore, error) {
+	if warehouse == "" {
+		warehouse = PrimaryWarehouse
+	}
+	store, ok := a.warehouses[warehouse]
+	if !ok {
+		return nil, &types.PermanentError{Msg: fmt.Sprintf("unknown warehouse %q", warehouse)}
+	}
+	return store, nil
+}
+
+// ReserveStock reserves inventory in the warehouse one SKU at a time, heartbeating
+// after each item so long reservations stay within the HeartbeatTimeout and can be
+// cancelled. If item N fails, items 1..N-1 are released before returning, so a
+// failed attempt holds nothing. Each call adds to what the order already holds,
+// so items added to an order after its reservation are reserved by another call.
+func (a *InventoryActivities) ReserveStock(ctx context.Context, orderID string, items []types.LineItem, warehouse string) (_ types.ReservationResult, err error) {
+	defer recoverActivity(ctx, &err)
 	logger := activity.GetLogger(ctx)
-	logger.Info("Reserving stock", "orderID", orderID, "items", items)
+	logger.Info("Reserving stock", "orderID", orderID, "items", items, "warehouse", warehouse)
 
-	// Simulate reservation logic
-	time.Sleep(100 * time.Millisecond)
+	result := types.ReservationResult{OrderID: orderID}
+	store, err := a.store(warehouse)
+	if err != nil {
+		return result, err
+	}
 
-	// Simulate occasional transient failures
-	if rand.Float32() < 0.1 {
-		return fmt.Errorf("temporary inventory system error")
+	// Items before the last heartbeat were reserved by a previous attempt; they skip
+	// the simulated latency but are still passed to the (idempotent) store
+	start := 0
+	if activity.HasHeartbeatDetails(ctx) {
+		if err := activity.GetHeartbeatDetails(ctx, &start); err == nil {
+			logger.Info("Resuming reservation from heartbeat", "orderID", orderID, "reserved", start)
+		}
 	}
 
-	logger.Info("Stock reserved successfully", "orderID", orderID)
-	return nil
+	// Lines are keyed by this activity execution, which all its retries share
+	info := activity.GetInfo(ctx)
+	lineID := func(i int) string {
+		return fmt.Sprintf("%s/%s/%d", info.WorkflowExecution.RunID, info.ActivityID, i)
+	}
+	rollback := func(reserved []types.LineItem) {
+		for i, item := range reserved {
+			store.Unreserve(orderID, lineID(i), item)
+		}
+		logger.Warn("Rolled back partial reservation", "orderID", orderID, "released", skusOf(reserved))
+	}
+
+	for i, item := range items {
+		if i >= start {
+			// Simulate reservation latency, honoring cancellation
+			if err := a.wait(ctx, 100*time.Millisecond); err != nil {
+				logger.Warn("Stock reservation cancelled", "orderID", orderID, "reserved", i)
+				rollback(items[:i])
+				return result, err
+			}
+
+			// Simulate occasional transient failures
+			if fails(a.failures.InventoryErrorRate) {
+				rollback(items[:i])
+				return result, fmt.Errorf("temporary inventory system error reserving %s", item.SKU)
+			}
+		}
+
+		if err := store.Reserve(orderID, lineID(i), item); err != nil {
+			logger.Warn("Insufficient stock", "orderID", orderID, "sku", item.SKU)
+			rollback(items[:i])
+			return result, err
+		}
+		result.ReservedSKUs = append(result.ReservedSKUs, item.SKU)
+
+		// Report progress: number of items reserved so far
+		activity.RecordHeartbeat(ctx, i+1)
+		logger.Debug("Item reserved", "orderID", orderID, "sku", item.SKU, "progress", i+1)
+	}
+
+	logger.Info("Stock reserved successfully", "orderID", orderID, "skus", result.ReservedSKUs, "warehouse", warehouse)
+	return result, nil
+}
+
+// skusOf lists the SKUs of the items
+func skusOf(items []types.LineItem) []string {
+	skus := make([]string, 0, len(items))
+	for _, item := range items {
+		skus = append(skus, item.SKU)
+	}
+	return skus
 }
 
 // ReleaseStock releases reserved inventory (compensation)
-func (a *InventoryActivities) ReleaseStock(ctx context.Context, orderID string) error {
+func (a *InventoryActivities) ReleaseStock(ctx context.Context, orderID string) (err error) {
+	defer recoverActivity(ctx, &err)
 	logger := activity.GetLogger(ctx)
 	logger.Info("Releasing stock", "orderID", orderID)
 
-	// Simulate release logic
-	time.Sleep(50 * time.Millisecond)
+	// Simulate release latency. An order holds stock in one warehouse at most;
+	// releasing from the others is a no-op.
+	a.sleep(50 * time.Millisecond)
+	for _, store := range a.warehouses {
+		store.Release(orderID)
+	}
 
 	logger.Info("Stock released successfully", "orderID", orderID)
 	return nil
 }
 
-// FetchInventorySnapshot checks if items are available in inventory
-func (a *InventoryActivities) FetchInventorySnapshot(ctx context.Context, items []types.LineItem) (bool, error) {
+// ReleaseStockItems releases part of an order's reservation, e.g. after a line item is removed
+func (a *InventoryActivities) ReleaseStockItems(ctx context.Context, orderID string, items []types.LineItem) (err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Releasing stock for items", "orderID", orderID, "items", items)
+
+	// Simulate release latency
+	a.sleep(50 * time.Millisecond)
+	for _, store := range a.warehouses {
+		store.ReleaseItems(orderID, items)
+	}
+
+	logger.Info("Stock released successfully", "orderID", orderID, "items", len(items))
+	return nil
+}
+
+// FetchInventorySnapshot returns the available quantity per SKU for the given
+// items in the primary warehouse
+func (a *InventoryActivities) FetchInventorySnapshot(ctx context.Context, items []types.LineItem) (_ map[string]int, err error) {
+	defer recoverActivity(ctx, &err)
 	logger := activity.GetLogger(ctx)
 	logger.Info("Fetching inventory snapshot", "items", items)
 
-	// Simulate inventory check
-	time.Sleep(200 * time.Millisecond)
+	// Simulate inventory check latency
+	a.sleep(200 * time.Millisecond)
 
-	// Simulate inventory availability (90% available)
-	available := rand.Float32() > 0.1
+	availability := a.warehouses[PrimaryWarehouse].Available(items)
 
-	logger.Info("Inventory check complete", "available", available)
-	return available, nil
+	logger.Info("Inventory check complete", "availability", availability)
+	return availability, nil
 }
 
+// AmountExceedsLimit starts the message of the PermanentError ProcessPayment
+// returns for a charge over PaymentActivities.MaxCharge
+const AmountExceedsLimit = "amount exceeds limit"
+
 // PaymentActivities contains payment-related activities
-type PaymentActivities struct{}
+type PaymentActivities struct {
+	Latency
+	// MaxCharge is the largest amount a single payment may charge; requests
+	// above it are refused before reaching the gateway. 0 means no limit.
+	MaxCharge float64
+	mu        sync.Mutex
+	// processed caches the outcome of each idempotency key already charged
+	processed map[string]paymentOutcome
+	// limiter throttles calls to the payment gateway; nil means unlimited
+	limiter *rate.Limiter
+	// breaker fails charges fast while the gateway is down; nil disables it
+	breaker  *CircuitBreaker
+	failures FailureConfig
+}
+
+// paymentOutcome is a final ProcessPayment result kept for deduplication
+type paymentOutcome struct {
+	receipt types.PaymentReceipt
+	err     error
+}
+
+// NewPaymentActivities creates payment activities that call the gateway at most
+// ratePerSec times per second. A rate <= 0 disables the limit. breaker trips on
+// gateway timeouts; nil disables it.
+func NewPaymentActivities(ratePerSec float64, breaker *CircuitBreaker, failures FailureConfig) *PaymentActivities {
+	a := &PaymentActivities{breaker: breaker, failures: failures}
+	if ratePerSec > 0 {
+		a.limiter = rate.NewLimiter(rate.Limit(ratePerSec), 1)
+	}
+	return a
+}
 
-// ProcessPayment processes payment for an order
-func (a *PaymentActivities) ProcessPayment(ctx context.Context, orderID string) error {
+// ProcessPayment processes payment for an order. Requests are deduplicated by
+// IdempotencyKey so a retried activity returns the cached outcome instead of charging again.
+func (a *PaymentActivities) ProcessPayment(ctx context.Context, req types.PaymentRequest) (_ types.PaymentReceipt, err error) {
+	defer recoverActivity(ctx, &err)
 	logger := activity.GetLogger(ctx)
-	logger.Info("Processing payment", "orderID", orderID)
+	logger.Info("Processing payment", "orderID", req.OrderID, "amount", req.Amount, "currency", req.Currency, "idempotencyKey", req.IdempotencyKey)
+
+	if req.Amount <= 0 {
+		return types.PaymentReceipt{}, &types.ValidationError{Msg: fmt.Sprintf("payment amount for order %s must be positive, got %.2f", req.OrderID, req.Amount)}
+	}
+	if req.Method != nil {
+		if err := req.Method.Validate(); err != nil {
+			return types.PaymentReceipt{}, err
+		}
+	}
+	if a.MaxCharge > 0 && req.Amount > a.MaxCharge {
+		logger.Error("Payment amount exceeds limit", "orderID", req.OrderID, "amount", req.Amount, "limit", a.MaxCharge)
+		return types.PaymentReceipt{}, &types.PermanentError{Msg: fmt.Sprintf("%s: %.2f %s is over %.2f", AmountExceedsLimit, req.Amount, req.Currency, a.MaxCharge)}
+	}
+
+	a.mu.Lock()
+	outcome, seen := a.processed[req.IdempotencyKey]
+	a.mu.Unlock()
+	if seen {
+		logger.Info("Duplicate payment request, returning cached result", "orderID", req.OrderID, "idempotencyKey", req.IdempotencyKey)
+		return outcome.receipt, outcome.err
+	}
+
+	// While the gateway is known to be down, fail fast with a retryable error
+	// and leave the rate budget to calls that can succeed
+	if a.breaker != nil && !a.breaker.Allow() {
+		logger.Warn("Payment gateway circuit open, failing fast", "orderID", req.OrderID)
+		activity.GetMetricsHandler(ctx).Counter("payment_circuit_rejections").Inc(1)
+		return types.PaymentReceipt{}, &types.PaymentTransientError{Msg: "payment gateway circuit open"}
+	}
+
+	// Block until the gateway rate allows another call, aborting if the activity is cancelled
+	if a.limiter != nil {
+		if err := a.limiter.Wait(ctx); err != nil {
+			return types.PaymentReceipt{}, err
+		}
+	}
+
+	receipt, err := a.charge(ctx, req)
+
+	// Declines are answers from a working gateway; only timeouts count as failures
+	var transient *types.PaymentTransientError
+	isTransient := errors.As(err, &transient)
+	if a.breaker != nil {
+		if state, changed := a.breaker.Record(isTransient); changed {
+			logger.Warn("Payment gateway circuit changed state", "state", state, "orderID", req.OrderID)
+			open := 0.0
+			if state != BreakerClosed {
+				open = 1
+			}
+			activity.GetMetricsHandler(ctx).Gauge("payment_circuit_open").Update(open)
+		}
+	}
+
+	// Only final outcomes are cached; transient errors and charges abandoned at
+	// the deadline must be retried for real
+	if !isTransient && ctx.Err() == nil {
+		a.mu.Lock()
+		if a.processed == nil {
+			a.processed = make(map[string]paymentOutcome)
+		}
+		a.processed[req.IdempotencyKey] = paymentOutcome{receipt: receipt, err: err}
+		a.mu.Unlock()
+	}
+	return receipt, err
+}
+
+// paymentMethodProfile is how the simulated gateway treats a payment method
+type paymentMethodProfile struct {
+	latency     time.Duration
+	declineRate float64 // multiplier for FailureConfig.PaymentDeclineRate
+}
+
+// paymentMethodProfiles: wallets are pre-authorized and rarely decline, bank
+// transfers are slow and decline more often on insufficient funds
+var paymentMethodProfiles = map[types.PaymentMethodType]paymentMethodProfile{
+	types.PaymentMethodCard:   {latency: 300 * time.Millisecond, declineRate: 1},
+	types.PaymentMethodWallet: {latency: 150 * time.Millisecond, declineRate: 0.5},
+	types.PaymentMethodBank:   {latency: 800 * time.Millisecond, declineRate: 2},
+}
+
+// charge simulates the call to the payment gateway
+func (a *PaymentActivities) charge(ctx context.Context, req types.PaymentRequest) (types.PaymentReceipt, error) {
+	logger := activity.GetLogger(ctx)
+	orderID := req.OrderID
+
+	methodType := types.PaymentMethodCard
+	if req.Method != nil {
+		methodType = req.Method.Type
+	}
+	profile := paymentMethodProfiles[methodType]
 
-	// Simulate payment processing
-	time.Sleep(300 * time.Millisecond)
+	// Simulate payment processing. Past the activity's deadline the gateway is
+	// never reached, so a timed-out ProcessPayment leaves nothing charged.
+	if err := a.wait(ctx, profile.latency); err != nil {
+		logger.Warn("Payment abandoned before reaching the gateway", "orderID", orderID, "error", err)
+		return types.PaymentReceipt{}, err
+	}
 
-	// Simulate different failure scenarios
-	r := rand.Float32()
+	// Simulate different failure scenarios; one draw keeps the two outcomes
+	// exclusive so each rate is the overall probability of that outcome
+	r := rand.Float64()
 	switch {
-	case r < 0.2:
+	case r < a.failures.PaymentTimeoutRate:
 		// Temporary gateway issue (retryable)
 		logger.Warn("Payment gateway timeout", "orderID", orderID)
-		return &types.PaymentTransientError{Msg: "gateway timeout"}
-	case r < 0.25:
-		// Permanent card decline (non-retryable)
-		logger.Error("Card declined", "orderID", orderID)
-		return &types.PermanentError{Msg: "card declined"}
+		return types.PaymentReceipt{}, &types.PaymentTransientError{Msg: "gateway timeout"}
+	case r < a.failures.PaymentTimeoutRate+a.failures.PaymentDeclineRate*profile.declineRate:
+		// Permanent decline (non-retryable)
+		logger.Error("Payment declined", "orderID", orderID, "method", methodType)
+		activity.GetMetricsHandler(ctx).Counter("order_payment_declines").Inc(1)
+		return types.PaymentReceipt{}, &types.PermanentError{Msg: fmt.Sprintf("%s declined", methodType)}
 	}
 
-	logger.Info("Payment processed successfully", "orderID", orderID)
-	return nil
+	receipt := types.PaymentReceipt{
+		TransactionID: "txn-" + uuid.NewString(),
+		Amount:        req.Amount,
+		ChargedAt:     time.Now(),
+	}
+	logger.Info("Payment processed successfully", "orderID", orderID, "amount", req.Amount, "currency", req.Currency, "transactionID", receipt.TransactionID)
+	return receipt, nil
 }
 
-// RefundPayment refunds a payment (compensation)
-func (a *PaymentActivities) RefundPayment(ctx context.Context, orderID string) error {
+// RefundPayment refunds the given payment transaction (compensation)
+func (a *PaymentActivities) RefundPayment(ctx context.Context, orderID string, transactionID string) (err error) {
+	defer recoverActivity(ctx, &err)
 	logger := activity.GetLogger(ctx)
-	logger.Info("Refunding payment", "orderID", orderID)
+	logger.Info("Refunding payment", "orderID", orderID, "transactionID", transactionID)
 
 	// Simulate refund logic
-	time.Sleep(200 * time.Millisecond)
+	a.sleep(200 * time.Millisecond)
 
-	logger.Info("Payment refunded successfully", "orderID", orderID)
+	logger.Info("Payment refunded successfully", "orderID", orderID, "transactionID", transactionID)
 	return nil
 }
 
 // CustomerActivities contains customer-related activities
-type CustomerActivities struct{}
+type CustomerActivities struct {
+	Latency
+}
 
 // FetchCustomerProfile fetches customer tier information
-func (a *CustomerActivities) FetchCustomerProfile(ctx context.Context, orderID string) (string, error) {
+func (a *CustomerActivities) FetchCustomerProfile(ctx context.Context, orderID string) (_ string, err error) {
+	defer recoverActivity(ctx, &err)
 	logger := activity.GetLogger(ctx)
 	logger.Info("Fetching customer profile", "orderID", orderID)
 
 	// Simulate customer lookup
-	time.Sleep(150 * time.Millisecond)
+	a.sleep(150 * time.Millisecond)
 
 	// Simulate customer tiers
 	tiers := []string{"Bronze", "Silver", "Gold", "Platinum"}
@@ -117,36 +380,89 @@ func (a *CustomerActivities) FetchCustomerProfile(ctx context.Context, orderID s
 	return tier, nil
 }
 
+// FetchCustomerEmail looks up the email address the order confirmation goes
+// to. A malformed address on file fails with a non-retryable ValidationError,
+// since retrying won't fix the record.
+func (a *CustomerActivities) FetchCustomerEmail(ctx context.Context, orderID string) (_ string, err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Fetching customer email", "orderID", orderID)
+
+	// Simulate customer lookup
+	a.sleep(50 * time.Millisecond)
+	email := strings.ToLower(orderID) + "@example.com"
+
+	if err := types.ValidateEmail(email); err != nil {
+		return "", &types.ValidationError{Msg: fmt.Sprintf("customer email for order %s: %v", orderID, err)}
+	}
+
+	logger.Info("Customer email fetched", "orderID", orderID, "email", email)
+	return email, nil
+}
+
+// FetchNotificationPrefs looks up which notifications the customer opted into.
+// Simulated: one customer in ten has opted out of email.
+func (a *CustomerActivities) FetchNotificationPrefs(ctx context.Context, orderID string) (_ types.NotificationPrefs, err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Fetching notification preferences", "orderID", orderID)
+
+	// Simulate customer lookup
+	a.sleep(50 * time.Millisecond)
+	prefs := types.NotificationPrefs{EmailOptIn: rand.Float64() >= 0.1}
+
+	logger.Info("Notification preferences fetched", "orderID", orderID, "emailOptIn", prefs.EmailOptIn)
+	return prefs, nil
+}
+
 // RecommendationActivities contains recommendation-related activities
-type RecommendationActivities struct{}
+type RecommendationActivities struct {
+	Latency
+}
 
-// FetchRecommendations fetches product recommendations
-func (a *RecommendationActivities) FetchRecommendations(ctx context.Context, orderID string) ([]string, error) {
+// FetchRecommendations returns the candidate pool of recommended products with
+// their relevance scores; the workflow ranks them and keeps the top picks
+func (a *RecommendationActivities) FetchRecommendations(ctx context.Context, orderID string) (_ []types.Recommendation, err error) {
+	defer recoverActivity(ctx, &err)
 	logger := activity.GetLogger(ctx)
 	logger.Info("Fetching recommendations", "orderID", orderID)
 
 	// Simulate recommendation engine
-	time.Sleep(100 * time.Millisecond)
-
-	recommendations := []string{"Product-A", "Product-B", "Product-C"}
+	a.sleep(100 * time.Millisecond)
+
+	recommendations := []types.Recommendation{
+		{SKU: "Product-A", Score: 0.42},
+		{SKU: "Product-B", Score: 0.87},
+		{SKU: "Product-C", Score: 0.63},
+		{SKU: "Product-D", Score: 0.87},
+		{SKU: "Product-E", Score: 0.15},
+		{SKU: "Product-F", Score: 0.71},
+		{SKU: "Product-G", Score: 0.30},
+		{SKU: "Product-H", Score: 0.56},
+	}
 
 	logger.Info("Recommendations fetched", "count", len(recommendations))
 	return recommendations, nil
 }
 
 // OrderActivities contains order-related activities
-type OrderActivities struct{}
+type OrderActivities struct {
+	Latency
+	DB       *sql.DB // nil disables PersistStatus (see schema.sql)
+	Failures FailureConfig
+}
 
 // UpdateOrderStatus updates the order status in the database
-func (a *OrderActivities) UpdateOrderStatus(ctx context.Context, orderID string, status string) error {
+func (a *OrderActivities) UpdateOrderStatus(ctx context.Context, orderID string, status string) (err error) {
+	defer recoverActivity(ctx, &err)
 	logger := activity.GetLogger(ctx)
 	logger.Info("Updating order status", "orderID", orderID, "status", status)
 
 	// Simulate database update
-	time.Sleep(100 * time.Millisecond)
+	a.sleep(100 * time.Millisecond)
 
 	// Simulate occasional transient failures
-	if rand.Float32() < 0.05 {
+	if fails(a.Failures.StatusUpdateErrorRate) {
 		return fmt.Errorf("database connection timeout")
 	}
 
@@ -154,35 +470,517 @@ func (a *OrderActivities) UpdateOrderStatus(ctx context.Context, orderID string,
 	return nil
 }
 
-// NotificationActivities contains notification-related activities
-type NotificationActivities struct{}
+// PersistStatus upserts the status snapshot into the order_status table so
+// external systems can read order state without querying the workflow
+func (a *OrderActivities) PersistStatus(ctx context.Context, status types.OrderWorkflowStatus) (err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+
+	if a.DB == nil {
+		logger.Info("No database configured, skipping status persistence", "orderID", status.OrderID, "stage", status.Stage)
+		return nil
+	}
+
+	snapshot, err := json.Marshal(status)
+	if err != nil {
+		return &types.PermanentError{Msg: fmt.Sprintf("encoding status for order %s: %v", status.OrderID, err)}
+	}
+
+	_, err = a.DB.ExecContext(ctx, `
+		INSERT INTO order_status (order_id, stage, snapshot, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (order_id) DO UPDATE
+		SET stage = excluded.stage, snapshot = excluded.snapshot, updated_at = excluded.updated_at`,
+		status.OrderID, status.Stage, string(snapshot), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("persisting status for order %s: %w", status.OrderID, err)
+	}
+
+	logger.Info("Order status persisted", "orderID", status.OrderID, "stage", status.Stage)
+	return nil
+}
+
+// LoadStatus reads the last persisted status snapshot of an order, for
+// ReprocessOrderWorkflow. It needs DB_DSN, since snapshots only exist there.
+func (a *OrderActivities) LoadStatus(ctx context.Context, orderID string) (_ types.OrderWorkflowStatus, err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Loading persisted status", "orderID", orderID)
+
+	var status types.OrderWorkflowStatus
+	if a.DB == nil {
+		return status, &types.ValidationError{Msg: "no database configured: set DB_DSN to reprocess orders"}
+	}
 
-// SendOrderConfirmation sends order confirmation email
-func (a *NotificationActivities) SendOrderConfirmation(ctx context.Context, orderID string, email string) error {
+	var snapshot string
+	var handedOffTo sql.NullString
+	err = a.DB.QueryRowContext(ctx,
+		`SELECT snapshot, handed_off_to FROM order_status WHERE order_id = $1`, orderID).
+		Scan(&snapshot, &handedOffTo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status, &types.ValidationError{Msg: fmt.Sprintf("no persisted status for order %s", orderID)}
+	}
+	if err != nil {
+		return status, fmt.Errorf("loading status for order %s: %w", orderID, err)
+	}
+	if err := json.Unmarshal([]byte(snapshot), &status); err != nil {
+		return status, &types.PermanentError{Msg: fmt.Sprintf("decoding status for order %s: %v", orderID, err)}
+	}
+	status.HandedOffTo = handedOffTo.String
+
+	logger.Info("Persisted status loaded", "orderID", orderID, "stage", status.Stage)
+	return status, nil
+}
+
+// MarkHandedOff records that workflowID has taken over a failed order, so a
+// second reprocess is rejected. Marking again with the same workflowID succeeds.
+func (a *OrderActivities) MarkHandedOff(ctx context.Context, orderID string, workflowID string) (err error) {
+	defer recoverActivity(ctx, &err)
 	logger := activity.GetLogger(ctx)
-	logger.Info("Sending order confirmation", "orderID", orderID, "email", email)
 
-	// Simulate email sending
-	time.Sleep(200 * time.Millisecond)
+	if a.DB == nil {
+		return &types.ValidationError{Msg: "no database configured: set DB_DSN to reprocess orders"}
+	}
 
-	// Simulate occasional failures (non-critical)
-	if rand.Float32() < 0.1 {
-		logger.Warn("Failed to send confirmation email", "orderID", orderID)
-		return fmt.Errorf("email service unavailable")
+	result, err := a.DB.ExecContext(ctx, `
+		UPDATE order_status SET handed_off_to = $2
+		WHERE order_id = $1 AND (handed_off_to IS NULL OR handed_off_to = $2)`,
+		orderID, workflowID)
+	if err != nil {
+		return fmt.Errorf("marking order %s handed off: %w", orderID, err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("marking order %s handed off: %w", orderID, err)
+	} else if n == 0 {
+		return &types.PermanentError{Msg: fmt.Sprintf("order %s is already handed off to another workflow", orderID)}
 	}
 
-	logger.Info("Order confirmation sent", "orderID", orderID)
+	logger.Info("Order handed off", "orderID", orderID, "workflowID", workflowID)
 	return nil
 }
 
-// SendCancellationEmail sends cancellation email
-func (a *NotificationActivities) SendCancellationEmail(ctx context.Context, orderID string, reason string) error {
+// ShippingActivities contains shipping-related activities
+type ShippingActivities struct {
+	Latency
+	Failures FailureConfig
+}
+
+// SelectCarrier picks a carrier for the shipment based on the items
+func (a *ShippingActivities) SelectCarrier(ctx context.Context, items []types.LineItem) (_ string, err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Selecting carrier", "items", items)
+
+	// Simulate rate shopping
+	a.sleep(100 * time.Millisecond)
+
+	carrier := "UPS"
+	total := 0
+	for _, item := range items {
+		total += item.Quantity
+	}
+	if total > 10 {
+		carrier = "FedEx Freight"
+	}
+
+	logger.Info("Carrier selected", "carrier", carrier)
+	return carrier, nil
+}
+
+// CreateShippingLabel creates a shipping label with the chosen carrier
+func (a *ShippingActivities) CreateShippingLabel(ctx context.Context, orderID string, carrier string) (_ string, err error) {
+	defer recoverActivity(ctx, &err)
 	logger := activity.GetLogger(ctx)
-	logger.Info("Sending cancellation email", "orderID", orderID, "reason", reason)
+	logger.Info("Creating shipping label", "orderID", orderID, "carrier", carrier)
+
+	// Simulate carrier API call
+	a.sleep(150 * time.Millisecond)
 
-	// Simulate email sending
-	time.Sleep(150 * time.Millisecond)
+	labelID := fmt.Sprintf("LBL-%s-%06d", orderID, rand.Intn(1000000))
 
-	logger.Info("Cancellation email sent", "orderID", orderID)
+	logger.Info("Shipping label created", "orderID", orderID, "labelID", labelID)
+	return labelID, nil
+}
+
+// CreateShipment creates a shipment for an order and returns its tracking number
+func (a *ShippingActivities) CreateShipment(ctx context.Context, orderID string, items []types.LineItem) (_ string, err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Creating shipment", "orderID", orderID, "items", items)
+
+	// Simulate carrier API call
+	a.sleep(200 * time.Millisecond)
+
+	// Simulate occasional transient failures
+	if fails(a.Failures.ShipmentErrorRate) {
+		return "", fmt.Errorf("carrier service unavailable")
+	}
+
+	trackingNumber := fmt.Sprintf("TRK-%s-%06d", orderID, rand.Intn(1000000))
+
+	logger.Info("Shipment created", "orderID", orderID, "trackingNumber", trackingNumber)
+	return trackingNumber, nil
+}
+
+// CancelShipment cancels a previously created shipment (compensation)
+func (a *ShippingActivities) CancelShipment(ctx context.Context, orderID string) (err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Cancelling shipment", "orderID", orderID)
+
+	// Simulate carrier API call
+	a.sleep(100 * time.Millisecond)
+
+	logger.Info("Shipment cancelled successfully", "orderID", orderID)
 	return nil
 }
+
+// shippingZone prices a shipment: a base fee plus a rate per kilogram
+type shippingZone struct {
+	base  float64
+	perKg float64
+}
+
+// shippingZones maps a country code to its zone; other countries are international
+var shippingZones = map[string]shippingZone{
+	"US": {base: 5, perKg: 0.5},
+	"CA": {base: 12, perKg: 1.5},
+	"GB": {base: 15, perKg: 2},
+	"DE": {base: 15, perKg: 2},
+	"FR": {base: 15, perKg: 2},
+	"ES": {base: 15, perKg: 2},
+}
+
+// internationalZone applies to countries missing from shippingZones
+var internationalZone = shippingZone{base: 25, perKg: 4}
+
+// skuWeights is the shipping weight of one unit in kilograms
+var skuWeights = map[string]float64{
+	"BOOK-001": 0.8,
+	"PEN-042":  0.02,
+	"ITEM-999": 2.5,
+	"LAST-001": 1.2,
+}
+
+// defaultSKUWeight applies to SKUs missing from skuWeights
+const defaultSKUWeight = 0.5
+
+// FreeShippingThreshold is the item subtotal, in the items' currency, from
+// which shipping is free
+const FreeShippingThreshold = 100.0
+
+// CalculateShippingCost prices shipping the items to the address by weight and
+// destination zone. Orders whose item subtotal reaches FreeShippingThreshold ship
+// free. An invalid address fails with a ValidationError.
+func (a *ShippingActivities) CalculateShippingCost(ctx context.Context, items []types.LineItem, address types.ShippingAddress) (_ float64, err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Calculating shipping cost", "items", len(items), "country", address.Country)
+
+	if err := address.Validate(); err != nil {
+		return 0, err
+	}
+
+	subtotal, weight := 0.0, 0.0
+	for _, item := range items {
+		subtotal += float64(item.Quantity) * item.UnitPrice
+		unitWeight, ok := skuWeights[item.SKU]
+		if !ok {
+			unitWeight = defaultSKUWeight
+		}
+		weight += float64(item.Quantity) * unitWeight
+	}
+	if len(items) == 0 || subtotal >= FreeShippingThreshold {
+		logger.Info("Shipping is free", "subtotal", subtotal)
+		return 0, nil
+	}
+
+	zone, ok := shippingZones[strings.ToUpper(address.Country)]
+	if !ok {
+		zone = internationalZone
+	}
+	// Round to cents
+	cost := math.Round((zone.base+weight*zone.perKg)*100) / 100
+
+	logger.Info("Shipping cost calculated", "weightKg", weight, "cost", cost)
+	return cost, nil
+}
+
+// TaxActivities contains tax-related activities
+type TaxActivities struct{}
+
+// taxRates maps a country code to its sales tax rate
+var taxRates = map[string]float64{
+	"US": 0.07,
+	"CA": 0.13,
+	"GB": 0.20,
+	"DE": 0.19,
+	"FR": 0.20,
+	"ES": 0.21,
+}
+
+// defaultTaxRate applies to regions missing from the rate table
+const defaultTaxRate = 0.10
+
+// CalculateTax returns the tax owed on the subtotal for the shipping address region
+func (a *TaxActivities) CalculateTax(ctx context.Context, subtotal float64, address types.ShippingAddress) (_ float64, err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Calculating tax", "subtotal", subtotal, "country", address.Country)
+
+	if subtotal < 0 {
+		return 0, &types.ValidationError{Msg: "subtotal cannot be negative"}
+	}
+
+	rate, ok := taxRates[strings.ToUpper(address.Country)]
+	if !ok {
+		rate = defaultTaxRate
+	}
+
+	// Round to cents
+	tax := math.Round(subtotal*rate*100) / 100
+
+	logger.Info("Tax calculated", "rate", rate, "tax", tax)
+	return tax, nil
+}
+
+// PromoActivities contains promotion-related activities
+type PromoActivities struct {
+	Latency
+}
+
+// promotion describes a discount code: a percentage off, a flat amount off, and a minimum subtotal
+type promotion struct {
+	percentOff  float64
+	amountOff   float64
+	minSubtotal float64
+}
+
+// promotions is the catalog of active promo codes
+var promotions = map[string]promotion{
+	"SAVE10":   {percentOff: 0.10},
+	"WELCOME5": {amountOff: 5, minSubtotal: 20},
+	"BIGSPEND": {percentOff: 0.15, minSubtotal: 100},
+}
+
+// ValidatePromo validates a promo code and returns the discount it grants on the subtotal
+func (a *PromoActivities) ValidatePromo(ctx context.Context, code string, subtotal float64) (_ float64, err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Validating promo code", "code", code, "subtotal", subtotal)
+
+	// Simulate promotion service lookup
+	a.sleep(50 * time.Millisecond)
+
+	promo, ok := promotions[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		return 0, &types.ValidationError{Msg: fmt.Sprintf("unknown promo code %q", code)}
+	}
+	if subtotal < promo.minSubtotal {
+		return 0, &types.ValidationError{Msg: fmt.Sprintf("promo code %q requires a subtotal of at least %.2f", code, promo.minSubtotal)}
+	}
+
+	discount := math.Round((subtotal*promo.percentOff+promo.amountOff)*100) / 100
+	if discount > subtotal {
+		discount = subtotal
+	}
+
+	logger.Info("Promo code valid", "code", code, "discount", discount)
+	return discount, nil
+}
+
+// CurrencyActivities contains currency conversion activities
+type CurrencyActivities struct{}
+
+// usdRates holds the value of one unit of each currency in USD
+var usdRates = map[string]float64{
+	"USD": 1.0,
+	"EUR": 1.08,
+	"GBP": 1.27,
+	"CAD": 0.73,
+	"JPY": 0.0067,
+	"MXN": 0.058,
+}
+
+// Convert converts an amount between currencies using the static rate table
+func (a *CurrencyActivities) Convert(ctx context.Context, amount float64, from, to string) (_ float64, err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Converting currency", "amount", amount, "from", from, "to", to)
+
+	fromRate, ok := usdRates[strings.ToUpper(from)]
+	if !ok {
+		return 0, &types.ValidationError{Msg: fmt.Sprintf("unknown currency code %q", from)}
+	}
+	toRate, ok := usdRates[strings.ToUpper(to)]
+	if !ok {
+		return 0, &types.ValidationError{Msg: fmt.Sprintf("unknown currency code %q", to)}
+	}
+
+	// Round to cents
+	converted := math.Round(amount*fromRate/toRate*100) / 100
+
+	logger.Info("Currency converted", "amount", converted, "currency", to)
+	return converted, nil
+}
+
+// NotificationActivities contains notification-related activities. Messages are
+// routed through the channel the customer prefers.
+type NotificationActivities struct {
+	Channels    map[string]NotificationChannel // keyed by UserPreferences.Channel
+	Preferences func(orderID string) types.UserPreferences
+}
+
+// NewNotificationActivities creates notification activities with email and SMS channels
+func NewNotificationActivities(failures FailureConfig) *NotificationActivities {
+	return &NotificationActivities{
+		Channels: map[string]NotificationChannel{
+			"email": &EmailChannel{FailureRate: failures.EmailErrorRate},
+			"sms":   &SMSChannel{FailureRate: failures.SMSErrorRate},
+		},
+		Preferences: simulatedPreferences,
+	}
+}
+
+// SendOrderConfirmation sends the order confirmation including the amount charged.
+// email is used when the customer prefers email but has no address on file.
+// For a gift order the recipient is also emailed, with the gift message and
+// without the amount; a gift order without any address for the buyer only
+// confirms to the recipient. The two sends are independent: one failing doesn't
+// stop the other, and a retry skips the sends heartbeated as done.
+func (a *NotificationActivities) SendOrderConfirmation(ctx context.Context, orderID string, email string, amount float64, currency string, gift *types.GiftInfo) (err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Sending order confirmation", "orderID", orderID, "email", email, "amount", amount, "currency", currency)
+
+	var sent confirmationProgress
+	if activity.HasHeartbeatDetails(ctx) {
+		if err := activity.GetHeartbeatDetails(ctx, &sent); err != nil {
+			logger.Warn("Ignoring unreadable confirmation progress", "orderID", orderID, "error", err)
+		}
+	}
+
+	var buyerErr error
+	if !sent.Buyer {
+		message := fmt.Sprintf("Order %s confirmed, charged %.2f %s", orderID, amount, currency)
+		if gift != nil {
+			message += fmt.Sprintf(" (gift for %s)", gift.RecipientEmail)
+		}
+		name, channel, recipient, err := a.route(orderID, email)
+		switch {
+		case err == nil && recipient == "" && gift != nil:
+			logger.Warn("No address for the buyer, confirming to the gift recipient only", "orderID", orderID)
+		case err != nil:
+			buyerErr = err
+		default:
+			buyerErr = deliver(ctx, orderID, name, channel, recipient, message)
+			if buyerErr == nil {
+				sent.Buyer = true
+				activity.RecordHeartbeat(ctx, sent)
+			}
+		}
+		if buyerErr != nil {
+			// Non-critical, the workflow logs and continues
+			logger.Warn("Failed to send confirmation", "orderID", orderID, "error", buyerErr)
+		}
+	}
+
+	var recipientErr error
+	if gift != nil && !sent.Recipient {
+		if recipientErr = a.notifyGiftRecipient(ctx, orderID, *gift); recipientErr != nil {
+			logger.Warn("Failed to send gift confirmation", "orderID", orderID, "recipient", gift.RecipientEmail, "error", recipientErr)
+		} else {
+			sent.Recipient = true
+			activity.RecordHeartbeat(ctx, sent)
+		}
+	}
+
+	if buyerErr != nil {
+		return buyerErr
+	}
+	if recipientErr != nil {
+		return recipientErr
+	}
+	logger.Info("Order confirmation sent", "orderID", orderID, "charged", fmt.Sprintf("%.2f %s", amount, currency))
+	return nil
+}
+
+// confirmationProgress is the SendOrderConfirmation heartbeat: the sends done
+type confirmationProgress struct {
+	Buyer     bool
+	Recipient bool
+}
+
+// SendCancellationEmail sends the cancellation notice. The name is kept for
+// existing workflow histories; delivery follows the customer's channel.
+func (a *NotificationActivities) SendCancellationEmail(ctx context.Context, orderID string, reason string) (err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Sending cancellation notice", "orderID", orderID, "reason", reason)
+
+	message := fmt.Sprintf("Order %s cancelled: %s", orderID, reason)
+	if err := a.notify(ctx, orderID, "", message); err != nil {
+		return err
+	}
+
+	logger.Info("Cancellation notice sent", "orderID", orderID)
+	return nil
+}
+
+// notifyGiftRecipient emails the gift recipient. The customer's channel
+// preference does not apply to them, and a gift without a message gets a
+// default note.
+func (a *NotificationActivities) notifyGiftRecipient(ctx context.Context, orderID string, gift types.GiftInfo) error {
+	channel, ok := a.Channels["email"]
+	if !ok {
+		return &types.PermanentError{Msg: fmt.Sprintf("no email channel for the gift recipient of order %s", orderID)}
+	}
+
+	note := strings.TrimSpace(gift.Message)
+	if note == "" {
+		note = "Enjoy your gift!"
+	}
+	message := fmt.Sprintf("A gift is on its way to you (order %s). Note from the sender: %s", orderID, note)
+	return channel.Send(ctx, gift.RecipientEmail, message)
+}
+
+// notify sends message through the customer's preferred channel
+func (a *NotificationActivities) notify(ctx context.Context, orderID, email, message string) error {
+	name, channel, recipient, err := a.route(orderID, email)
+	if err != nil {
+		return err
+	}
+	return deliver(ctx, orderID, name, channel, recipient, message)
+}
+
+// deliver sends message to recipient on the channel route picked
+func deliver(ctx context.Context, orderID, name string, channel NotificationChannel, recipient, message string) error {
+	if recipient == "" {
+		return &types.ValidationError{Msg: fmt.Sprintf("no %s recipient for order %s", name, orderID)}
+	}
+	activity.GetLogger(ctx).Info("Routing notification", "orderID", orderID, "channel", name)
+	return channel.Send(ctx, recipient, message)
+}
+
+// route returns the customer's preferred channel and their address on it, or
+// email when the preferences have none. The address is empty when neither does.
+func (a *NotificationActivities) route(orderID, email string) (name string, channel NotificationChannel, recipient string, err error) {
+	prefs := types.UserPreferences{Channel: "email"}
+	if a.Preferences != nil {
+		prefs = a.Preferences(orderID)
+	}
+
+	channel, ok := a.Channels[prefs.Channel]
+	if !ok {
+		return "", nil, "", &types.PermanentError{Msg: fmt.Sprintf("no notification channel %q for order %s", prefs.Channel, orderID)}
+	}
+
+	recipient = prefs.Email
+	if prefs.Channel == "sms" {
+		recipient = prefs.Phone
+	}
+	if recipient == "" {
+		recipient = email
+	}
+	return prefs.Channel, channel, recipient, nil
+}
@@ -0,0 +1,215 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// newActivityEnv returns a test activity environment with the activities in set registered
+func newActivityEnv(set Set) *testsuite.TestActivityEnvironment {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	set.Register(env)
+	return env
+}
+
+// newTestPayments returns payment activities that don't sleep or fail
+func newTestPayments() *PaymentActivities {
+	payments := NewPaymentActivities(0, nil, FailureConfig{})
+	payments.Latency = Latency{Sleep: NoSleep}
+	return payments
+}
+
+// A charge of exactly MaxCharge goes through; a cent more is refused before
+// reaching the gateway with a PermanentError the workflow cancels on
+func TestProcessPaymentMaxCharge(t *testing.T) {
+	payments := newTestPayments()
+	payments.MaxCharge = 100
+	env := newActivityEnv(Set{Payment: payments})
+
+	value, err := env.ExecuteActivity(ActivityProcessPayment, types.PaymentRequest{OrderID: "ORDER-1", Amount: 100, Currency: "USD", IdempotencyKey: "pay-at-limit"})
+	require.NoError(t, err)
+	var receipt types.PaymentReceipt
+	require.NoError(t, value.Get(&receipt))
+	require.Equal(t, 100.0, receipt.Amount)
+
+	_, err = env.ExecuteActivity(ActivityProcessPayment, types.PaymentRequest{OrderID: "ORDER-2", Amount: 100.01, Currency: "USD", IdempotencyKey: "pay-over-limit"})
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr), "%T: %v", err, err)
+	require.Equal(t, "PermanentError", appErr.Type())
+	require.Equal(t, AmountExceedsLimit+": 100.01 USD is over 100.00", appErr.Message())
+}
+
+// reservationItems returns one unit each of n DemoStock SKUs, cycling through them
+func reservationItems(n int) []types.LineItem {
+	skus := []string{"BOOK-001", "PEN-042", "ITEM-999"}
+	items := make([]types.LineItem, n)
+	for i := range items {
+		items[i] = types.LineItem{SKU: skus[i%len(skus)], Quantity: 1}
+	}
+	return items
+}
+
+// ReserveStock heartbeats the number of items reserved after each one. The SDK
+// throttles heartbeats, so only the first reaches the listener at once.
+func TestReserveStockHeartbeatsProgress(t *testing.T) {
+	warehouses := DemoWarehouses()
+	env := newActivityEnv(Set{Inventory: NewInventoryActivities(warehouses, FailureConfig{})})
+	var progress []int
+	env.SetOnActivityHeartbeatListener(func(_ *activity.Info, details converter.EncodedValues) {
+		var reserved int
+		require.NoError(t, details.Get(&reserved))
+		progress = append(progress, reserved)
+	})
+
+	items := reservationItems(3)
+	value, err := env.ExecuteActivity(ActivityReserveStock, "ORDER-1", items, PrimaryWarehouse)
+	require.NoError(t, err)
+	var result types.ReservationResult
+	require.NoError(t, value.Get(&result))
+	require.Equal(t, []string{"BOOK-001", "PEN-042", "ITEM-999"}, result.ReservedSKUs)
+	require.Equal(t, 1, progress[0])
+	require.Equal(t, map[string]int{"BOOK-001": 99, "PEN-042": 499, "ITEM-999": 49}, warehouses[PrimaryWarehouse].Available(items))
+}
+
+// The per-item reservation latency goes through the injectable sleeper, so
+// tests using NoSleep don't wait for it
+func TestReserveStockWaitsWithLatencySleep(t *testing.T) {
+	inventory := NewInventoryActivities(DemoWarehouses(), FailureConfig{})
+	var slept []time.Duration
+	inventory.Latency = Latency{Sleep: func(d time.Duration) { slept = append(slept, d) }}
+	env := newActivityEnv(Set{Inventory: inventory})
+
+	_, err := env.ExecuteActivity(ActivityReserveStock, "ORDER-1", reservationItems(3), PrimaryWarehouse)
+	require.NoError(t, err)
+	require.Equal(t, []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond}, slept)
+}
+
+// A retry resumes after the items its last heartbeat reported, skipping their
+// latency, and still reserves the whole list
+func TestReserveStockResumesFromHeartbeat(t *testing.T) {
+	warehouses := DemoWarehouses()
+	env := newActivityEnv(Set{Inventory: NewInventoryActivities(warehouses, FailureConfig{})})
+	env.SetHeartbeatDetails(9)
+
+	items := reservationItems(10)
+	start := time.Now()
+	value, err := env.ExecuteActivity(ActivityReserveStock, "ORDER-1", items, PrimaryWarehouse)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+	var result types.ReservationResult
+	require.NoError(t, value.Get(&result))
+	require.Len(t, result.ReservedSKUs, 10)
+	require.Equal(t, map[string]int{"BOOK-001": 96, "PEN-042": 497, "ITEM-999": 47}, warehouses[PrimaryWarehouse].Available(items))
+}
+
+// Cancelling the activity stops the reservation at the next item instead of
+// after the whole list, and returns what it had reserved to stock
+func TestReserveStockStopsWhenCancelled(t *testing.T) {
+	warehouses := DemoWarehouses()
+	env := newActivityEnv(Set{Inventory: NewInventoryActivities(warehouses, FailureConfig{})})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	env.SetWorkerOptions(worker.Options{BackgroundActivityContext: ctx})
+	env.SetOnActivityHeartbeatListener(func(*activity.Info, converter.EncodedValues) { cancel() })
+
+	// 20 items take two seconds to reserve when not cancelled
+	items := reservationItems(20)
+	start := time.Now()
+	_, err := env.ExecuteActivity(ActivityReserveStock, "ORDER-1", items, PrimaryWarehouse)
+	require.True(t, temporal.IsCanceledError(err), "%T: %v", err, err)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+	require.Equal(t, map[string]int{"BOOK-001": 100, "PEN-042": 500, "ITEM-999": 50}, warehouses[PrimaryWarehouse].Available(items))
+}
+
+// A burst of charges is spread out at the configured rate rather than refused
+func TestProcessPaymentRateLimit(t *testing.T) {
+	payments := NewPaymentActivities(20, nil, FailureConfig{})
+	payments.Latency = Latency{Sleep: NoSleep}
+	env := newActivityEnv(Set{Payment: payments})
+
+	// The first charge uses the single token; the other four wait 50ms each
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := env.ExecuteActivity(ActivityProcessPayment, types.PaymentRequest{
+			OrderID: "ORDER-1", Amount: 10, Currency: "USD", IdempotencyKey: fmt.Sprintf("pay-%d", i),
+		})
+		require.NoError(t, err)
+	}
+	require.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+}
+
+// Waiting for the rate gives up when the activity can't get a token before its deadline
+func TestProcessPaymentRateLimitHonorsDeadline(t *testing.T) {
+	payments := NewPaymentActivities(0.5, nil, FailureConfig{})
+	payments.Latency = Latency{Sleep: NoSleep}
+	_, err := newActivityEnv(Set{Payment: payments}).ExecuteActivity(ActivityProcessPayment, types.PaymentRequest{
+		OrderID: "ORDER-1", Amount: 10, Currency: "USD", IdempotencyKey: "pay-1",
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	env := newActivityEnv(Set{Payment: payments})
+	env.SetWorkerOptions(worker.Options{BackgroundActivityContext: ctx})
+	start := time.Now()
+	_, err = env.ExecuteActivity(ActivityProcessPayment, types.PaymentRequest{
+		OrderID: "ORDER-2", Amount: 10, Currency: "USD", IdempotencyKey: "pay-2",
+	})
+	require.ErrorContains(t, err, "would exceed context deadline")
+	require.Less(t, time.Since(start), time.Second)
+}
+
+// A charge still waiting on the gateway at the activity's deadline is abandoned
+// without charging, and isn't cached: the retry with the same key charges
+func TestProcessPaymentStopsAtDeadline(t *testing.T) {
+	payments := NewPaymentActivities(0, nil, FailureConfig{})
+	req := types.PaymentRequest{OrderID: "ORDER-1", Amount: 10, Currency: "USD", IdempotencyKey: "pay-1"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	env := newActivityEnv(Set{Payment: payments})
+	env.SetWorkerOptions(worker.Options{BackgroundActivityContext: ctx})
+	start := time.Now()
+	_, err := env.ExecuteActivity(ActivityProcessPayment, req)
+	require.ErrorContains(t, err, context.DeadlineExceeded.Error())
+	require.Less(t, time.Since(start), 300*time.Millisecond)
+
+	payments.Latency = Latency{Sleep: NoSleep}
+	value, err := newActivityEnv(Set{Payment: payments}).ExecuteActivity(ActivityProcessPayment, req)
+	require.NoError(t, err)
+	var receipt types.PaymentReceipt
+	require.NoError(t, value.Get(&receipt))
+	require.NotEmpty(t, receipt.TransactionID)
+}
+
+// When an item can't be reserved the items before it are returned to stock,
+// so a failed ReserveStock leaves nothing held
+func TestReserveStockRollsBackOnMidListFailure(t *testing.T) {
+	warehouses := DemoWarehouses()
+	env := newActivityEnv(Set{Inventory: NewInventoryActivities(warehouses, FailureConfig{})})
+	items := []types.LineItem{
+		{SKU: "BOOK-001", Quantity: 2},
+		{SKU: "PEN-042", Quantity: 5},
+		{SKU: "LAST-001", Quantity: 2}, // only one in stock
+		{SKU: "ITEM-999", Quantity: 1},
+	}
+
+	_, err := env.ExecuteActivity(ActivityReserveStock, "ORDER-1", items, PrimaryWarehouse)
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr), "%T: %v", err, err)
+	require.Equal(t, "InsufficientInventoryError", appErr.Type())
+	require.Equal(t, map[string]int{"BOOK-001": 100, "PEN-042": 500, "LAST-001": 1, "ITEM-999": 50}, warehouses[PrimaryWarehouse].Available(items))
+}
@@ -0,0 +1,86 @@
+package activities
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// newStatusDB opens an in-memory sqlite database with the order_status table
+// from schema.sql. The ALTER TABLE migration is skipped: sqlite has no ADD
+// COLUMN IF NOT EXISTS, and the CREATE TABLE already has the column.
+func newStatusDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	// Each connection to :memory: is its own database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	schema, err := os.ReadFile("../schema.sql")
+	require.NoError(t, err)
+	for _, stmt := range strings.Split(string(schema), ";") {
+		if strings.Contains(stmt, "ALTER TABLE") || strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		_, err := db.Exec(stmt)
+		require.NoError(t, err, stmt)
+	}
+	return db
+}
+
+func TestPersistAndLoadStatus(t *testing.T) {
+	orders := &OrderActivities{DB: newStatusDB(t)}
+	env := newActivityEnv(Set{Order: orders})
+
+	status := types.OrderWorkflowStatus{
+		OrderID:  "ORDER-1",
+		Stage:    "reserve",
+		Items:    []types.LineItem{{SKU: "BOOK-001", Quantity: 2, UnitPrice: 24.99, Currency: "USD"}},
+		Reserved: true,
+	}
+	_, err := env.ExecuteActivity(ActivityPersistStatus, status)
+	require.NoError(t, err)
+
+	// A later stage overwrites the snapshot rather than adding a row
+	status.Stage = "payment"
+	status.Charged = true
+	status.PaymentReceipt = &types.PaymentReceipt{TransactionID: "TXN-1", Amount: 49.98}
+	_, err = env.ExecuteActivity(ActivityPersistStatus, status)
+	require.NoError(t, err)
+
+	value, err := env.ExecuteActivity(ActivityLoadStatus, "ORDER-1")
+	require.NoError(t, err)
+	var loaded types.OrderWorkflowStatus
+	require.NoError(t, value.Get(&loaded))
+	require.Equal(t, "payment", loaded.Stage)
+	require.Equal(t, status.Items, loaded.Items)
+	require.True(t, loaded.Reserved)
+	require.Equal(t, "TXN-1", loaded.TransactionID())
+	require.Empty(t, loaded.HandedOffTo)
+
+	var rows int
+	require.NoError(t, orders.DB.QueryRow(`SELECT COUNT(*) FROM order_status`).Scan(&rows))
+	require.Equal(t, 1, rows)
+}
+
+func TestLoadStatusUnknownOrder(t *testing.T) {
+	env := newActivityEnv(Set{Order: &OrderActivities{DB: newStatusDB(t)}})
+
+	_, err := env.ExecuteActivity(ActivityLoadStatus, "ORDER-404")
+	require.ErrorContains(t, err, "no persisted status for order ORDER-404")
+}
+
+// Without a database PersistStatus is a no-op
+func TestPersistStatusWithoutDB(t *testing.T) {
+	env := newActivityEnv(Set{Order: &OrderActivities{}})
+
+	_, err := env.ExecuteActivity(ActivityPersistStatus, types.OrderWorkflowStatus{OrderID: "ORDER-1", Stage: "reserve"})
+	require.NoError(t, err)
+}
@@ -0,0 +1,26 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"go.temporal.io/sdk/activity"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// recoverActivity turns a panic in an activity into an InternalActivityError
+// carrying the stack, and logs it. Without it the SDK still catches the panic,
+// but reports it as an opaque PanicError. Every activity defers it first, with
+// a pointer to its named error result.
+func recoverActivity(ctx context.Context, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	name := activity.GetInfo(ctx).ActivityType.Name
+	stack := string(debug.Stack())
+	activity.GetLogger(ctx).Error("Activity panicked", "activity", name, "panic", r, "stack", stack)
+	*err = &types.InternalActivityError{Activity: name, Panic: fmt.Sprint(r), Stack: stack}
+}
@@ -0,0 +1,32 @@
+package activities
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// A panic inside ProcessPayment reaches the caller as a retryable
+// InternalActivityError naming the activity, not as the SDK's PanicError
+func TestPanickingActivityReturnsInternalActivityError(t *testing.T) {
+	payments := newTestPayments()
+	payments.Latency = Latency{Sleep: func(time.Duration) { panic("gateway client is nil") }}
+	env := newActivityEnv(Set{Payment: payments})
+
+	_, err := env.ExecuteActivity(ActivityProcessPayment, types.PaymentRequest{OrderID: "ORDER-1", Amount: 10, Currency: "USD", IdempotencyKey: "pay-1"})
+	require.Error(t, err)
+
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr), "%T: %v", err, err)
+	require.Equal(t, "InternalActivityError", appErr.Type())
+	require.False(t, appErr.NonRetryable())
+	require.Contains(t, appErr.Error(), "activity ProcessPayment panicked: gateway client is nil")
+
+	var panicErr *temporal.PanicError
+	require.False(t, errors.As(err, &panicErr))
+}
@@ -0,0 +1,88 @@
+package activities
+
+import (
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/worker"
+)
+
+// Set holds the configured activity structs a worker serves. The order worker
+// and the test harness build their own Set and register it with Register, so
+// both always register the same activities under the same names. A nil field
+// registers nothing, e.g. Payment when payments run on their own worker.
+type Set struct {
+	Inventory      *InventoryActivities
+	Lock           *LockActivities
+	Payment        *PaymentActivities
+	Customer       *CustomerActivities
+	Recommendation *RecommendationActivities
+	Shipping       *ShippingActivities
+	Tax            *TaxActivities
+	Promo          *PromoActivities
+	Currency       *CurrencyActivities
+	Order          *OrderActivities
+	Notification   *NotificationActivities
+	Webhook        *WebhookActivities
+	Event          *EventActivities
+}
+
+// Register registers every activity in s under the name the workflows invoke it by
+func (s Set) Register(w worker.ActivityRegistry) {
+	if a := s.Inventory; a != nil {
+		register(w, ActivityReserveStock, a.ReserveStock)
+		register(w, ActivityReleaseStock, a.ReleaseStock)
+		register(w, ActivityReleaseStockItems, a.ReleaseStockItems)
+		register(w, ActivityFetchInventorySnapshot, a.FetchInventorySnapshot)
+	}
+	if a := s.Lock; a != nil {
+		register(w, ActivityRequestInventoryLock, a.RequestInventoryLock)
+	}
+	if a := s.Payment; a != nil {
+		register(w, ActivityProcessPayment, a.ProcessPayment)
+		register(w, ActivityRefundPayment, a.RefundPayment)
+	}
+	if a := s.Customer; a != nil {
+		register(w, ActivityFetchCustomerProfile, a.FetchCustomerProfile)
+		register(w, ActivityFetchCustomerEmail, a.FetchCustomerEmail)
+		register(w, ActivityFetchNotificationPrefs, a.FetchNotificationPrefs)
+	}
+	if a := s.Recommendation; a != nil {
+		register(w, ActivityFetchRecommendations, a.FetchRecommendations)
+	}
+	if a := s.Shipping; a != nil {
+		register(w, ActivitySelectCarrier, a.SelectCarrier)
+		register(w, ActivityCreateShippingLabel, a.CreateShippingLabel)
+		register(w, ActivityCreateShipment, a.CreateShipment)
+		register(w, ActivityCancelShipment, a.CancelShipment)
+		register(w, ActivityCalculateShippingCost, a.CalculateShippingCost)
+	}
+	if a := s.Tax; a != nil {
+		register(w, ActivityCalculateTax, a.CalculateTax)
+	}
+	if a := s.Promo; a != nil {
+		register(w, ActivityValidatePromo, a.ValidatePromo)
+	}
+	if a := s.Currency; a != nil {
+		register(w, ActivityConvert, a.Convert)
+	}
+	if a := s.Order; a != nil {
+		register(w, ActivityUpdateOrderStatus, a.UpdateOrderStatus)
+		register(w, ActivityPersistStatus, a.PersistStatus)
+		register(w, ActivityLoadStatus, a.LoadStatus)
+		register(w, ActivityMarkHandedOff, a.MarkHandedOff)
+	}
+	if a := s.Notification; a != nil {
+		register(w, ActivitySendOrderConfirmation, a.SendOrderConfirmation)
+		register(w, ActivitySendCancellationEmail, a.SendCancellationEmail)
+	}
+	if a := s.Webhook; a != nil {
+		register(w, ActivityNotifyCompletion, a.NotifyCompletion)
+	}
+	if a := s.Event; a != nil {
+		register(w, ActivityPublishEvent, a.Publish)
+	}
+}
+
+// register registers fn under name
+func register(w worker.ActivityRegistry, name string, fn interface{}) {
+	w.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+}
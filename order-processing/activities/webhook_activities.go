@@ -0,0 +1,64 @@
+package activities
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// WebhookActivities notifies external systems about finished orders
+type WebhookActivities struct {
+	// Client sends the callbacks; nil uses a client with a 10s timeout
+	Client *http.Client
+}
+
+// NotifyCompletion POSTs the final order status as JSON to url. Non-2xx
+// responses and network errors are retried; a malformed url is a
+// ValidationError since retrying cannot fix it.
+func (a *WebhookActivities) NotifyCompletion(ctx context.Context, callbackURL string, payload types.OrderWorkflowStatus) (err error) {
+	defer recoverActivity(ctx, &err)
+	logger := activity.GetLogger(ctx)
+	logger.Info("Sending completion webhook", "orderID", payload.OrderID, "url", callbackURL)
+
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &types.ValidationError{Msg: fmt.Sprintf("invalid webhook URL %q", callbackURL)}
+	}
+
+	body, err := json.Marshal(payload.DTO())
+	if err != nil {
+		return &types.ValidationError{Msg: fmt.Sprintf("encode webhook payload: %v", err)}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return &types.ValidationError{Msg: fmt.Sprintf("build webhook request: %v", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Receivers can deduplicate retried deliveries by this key
+	req.Header.Set("Idempotency-Key", payload.OrderID+"-completed")
+
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &types.WebhookDeliveryError{URL: callbackURL, Msg: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &types.WebhookDeliveryError{URL: callbackURL, StatusCode: resp.StatusCode, Msg: resp.Status}
+	}
+
+	logger.Info("Completion webhook delivered", "orderID", payload.OrderID, "status", resp.StatusCode)
+	return nil
+}
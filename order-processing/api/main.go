@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+
+	"go-temporal-fast-course/order-processing/types"
+	"go-temporal-fast-course/order-processing/workflows"
+	"go-temporal-fast-course/shared/encryption"
+	"go-temporal-fast-course/shared/retry"
+)
+
+// server exposes OrderWorkflow over HTTP using the same client calls as the starter
+type server struct {
+	client           client.Client
+	taskQueue        string
+	executionTimeout time.Duration
+	runTimeout       time.Duration
+}
+
+func main() {
+	// Payloads are encrypted when PAYLOAD_ENCRYPTION_KEY is set
+	dataConverter, err := encryption.DataConverterFromEnv()
+	if err != nil {
+		log.Fatalln("Unable to configure payload encryption", err)
+	}
+
+	// Create Temporal client
+	c, err := retry.DialWithRetry(client.Options{
+		HostPort:      getEnv("TEMPORAL_HOST", "localhost:7233"),
+		DataConverter: dataConverter,
+	}, retry.DialAttempts, retry.DialBackoff)
+	if err != nil {
+		log.Fatalln("Unable to create Temporal client", err)
+	}
+	defer c.Close()
+
+	s := &server{
+		client:           c,
+		taskQueue:        getEnv("ORDER_TASK_QUEUE", "order-task-queue"),
+		executionTimeout: getEnvDuration("ORDER_EXECUTION_TIMEOUT", 2*time.Hour),
+		runTimeout:       getEnvDuration("ORDER_RUN_TIMEOUT", 0),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orders", s.handleOrders)
+	mux.HandleFunc("/orders/", s.handleOrder)
+
+	addr := ":" + getEnv("API_PORT", "8081")
+	log.Println("Order API listening on", addr)
+	log.Fatalln(http.ListenAndServe(addr, mux))
+}
+
+// handleOrders serves POST /orders (body = line items) to start an OrderWorkflow
+func (s *server) handleOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST /orders")
+		return
+	}
+
+	var items []types.LineItem
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return
+	}
+	if err := types.ValidateOrder(items); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	orderID := fmt.Sprintf("ORDER-%d", time.Now().UnixNano())
+	// Same timeouts as the starter so API orders can't linger forever either
+	workflowOptions := client.StartWorkflowOptions{
+		ID:                       workflowID(orderID),
+		TaskQueue:                s.taskQueue,
+		WorkflowExecutionTimeout: s.executionTimeout,
+		WorkflowRunTimeout:       s.runTimeout,
+	}
+	we, err := s.client.ExecuteWorkflow(r.Context(), workflowOptions, workflows.OrderWorkflow, types.OrderInput{OrderID: orderID, Items: items})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to start workflow: %v", err))
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"orderId":    orderID,
+		"workflowId": we.GetID(),
+		"runId":      we.GetRunID(),
+	})
+}
+
+// handleOrder serves GET /orders/{id}, POST /orders/{id}/approve, POST /orders/{id}/cancel
+// and POST /orders/{id}/address
+func (s *server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/orders/"), "/"), "/")
+	orderID := parts[0]
+	if orderID == "" || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.getStatus(w, r, orderID)
+	case len(parts) == 2 && parts[1] == "approve" && r.Method == http.MethodPost:
+		approval := types.PaymentApproval{ApprovedBy: "api", Timestamp: time.Now()}
+		if !decodeOptionalBody(w, r, &approval) {
+			return
+		}
+		s.signal(w, r, orderID, "approve-payment", approval)
+	case len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+		cancel := types.CancelRequest{Reason: types.ReasonCustomerRequested, Note: "cancelled via API"}
+		if !decodeOptionalBody(w, r, &cancel) {
+			return
+		}
+		s.signal(w, r, orderID, "cancel-order", cancel)
+	case len(parts) == 2 && parts[1] == "address" && r.Method == http.MethodPost:
+		s.updateAddress(w, r, orderID)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// updateAddress runs the update-shipping-address update; tax (and so payment) waits on it
+func (s *server) updateAddress(w http.ResponseWriter, r *http.Request, orderID string) {
+	var address types.ShippingAddress
+	if err := json.NewDecoder(r.Body).Decode(&address); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return
+	}
+
+	handle, err := s.client.UpdateWorkflow(r.Context(), client.UpdateWorkflowOptions{
+		WorkflowID:   workflowID(orderID),
+		UpdateName:   "update-shipping-address",
+		Args:         []interface{}{address},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		s.writeUpdateError(w, r, orderID, err)
+		return
+	}
+
+	var previous types.ShippingAddress
+	if err := handle.Get(r.Context(), &previous); err != nil {
+		// Rejected by the workflow's validator; anything else is the service's
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.writeUpdateError(w, r, orderID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"orderId": orderID, "previousAddress": previous})
+}
+
+// writeUpdateError maps a failed update like writeTemporalError, except that
+// a workflow that exists but has closed is a 409, as for signals
+func (s *server) writeUpdateError(w http.ResponseWriter, r *http.Request, orderID string, err error) {
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) && !s.checkRunning(w, r, orderID) {
+		return
+	}
+	writeTemporalError(w, err)
+}
+
+// getStatus queries get-status-dto so the response follows the stable wire
+// contract; closed workflows still answer queries
+func (s *server) getStatus(w http.ResponseWriter, r *http.Request, orderID string) {
+	resp, err := s.client.QueryWorkflow(r.Context(), workflowID(orderID), "", "get-status-dto")
+	if err != nil {
+		writeTemporalError(w, err)
+		return
+	}
+
+	var status types.StatusDTO
+	if err := resp.Get(&status); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to decode status: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// signal sends a signal after checking the workflow exists and is still running
+func (s *server) signal(w http.ResponseWriter, r *http.Request, orderID, signalName string, payload interface{}) {
+	if !s.checkRunning(w, r, orderID) {
+		return
+	}
+
+	if err := s.client.SignalWorkflow(r.Context(), workflowID(orderID), "", signalName, payload); err != nil {
+		writeTemporalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"orderId": orderID, "signal": signalName})
+}
+
+// checkRunning reports whether the order's workflow is running, writing 404
+// when it doesn't exist and 409 when it has closed
+func (s *server) checkRunning(w http.ResponseWriter, r *http.Request, orderID string) bool {
+	desc, err := s.client.DescribeWorkflowExecution(r.Context(), workflowID(orderID), "")
+	if err != nil {
+		writeTemporalError(w, err)
+		return false
+	}
+	if st := desc.GetWorkflowExecutionInfo().GetStatus(); st != enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+		writeError(w, http.StatusConflict, fmt.Sprintf("order %s is no longer running (%s)", orderID, st))
+		return false
+	}
+	return true
+}
+
+func workflowID(orderID string) string {
+	return fmt.Sprintf("order-workflow-%s", orderID)
+}
+
+// decodeOptionalBody decodes a JSON body into v when one is present
+func decodeOptionalBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeTemporalError maps Temporal service errors to HTTP status codes
+func writeTemporalError(w http.ResponseWriter, err error) {
+	var notFound *serviceerror.NotFound
+	var deadline *serviceerror.DeadlineExceeded
+	switch {
+	case errors.As(err, &notFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &deadline):
+		writeError(w, http.StatusGatewayTimeout, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Failed to write response:", err)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", key, value, err)
+	}
+	return d
+}
@@ -0,0 +1,15 @@
+-- Status snapshots written by OrderActivities.PersistStatus at each stage transition.
+-- snapshot holds the JSON-encoded types.OrderWorkflowStatus.
+CREATE TABLE IF NOT EXISTS order_status (
+    order_id   TEXT PRIMARY KEY,
+    stage      TEXT NOT NULL,
+    snapshot   TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    -- ReprocessOrderWorkflow ID that took over the failed order, set by MarkHandedOff
+    handed_off_to TEXT
+);
+
+-- For tables created before handed_off_to existed
+ALTER TABLE order_status ADD COLUMN IF NOT EXISTS handed_off_to TEXT;
+
+CREATE INDEX IF NOT EXISTS order_status_stage_idx ON order_status (stage);
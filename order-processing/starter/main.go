@@ -2,64 +2,371 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/operatorservice/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
 
+	greetworkflows "go-temporal-fast-course/greeting/workflows"
 	"go-temporal-fast-course/order-processing/types"
 	"go-temporal-fast-course/order-processing/workflows"
+	"go-temporal-fast-course/shared/encryption"
+	"go-temporal-fast-course/shared/retry"
 )
 
 func main() {
-	// Create Temporal client
-	c, err := client.Dial(client.Options{
-		HostPort: getEnv("TEMPORAL_HOST", "localhost:7233"),
-	})
-	if err != nil {
-		log.Fatalln("Unable to create Temporal client", err)
+	root := newRootCmd()
+
+	// Without a subcommand, fall back to WORKFLOW_TYPE so existing scripts keep working
+	if len(os.Args) == 1 {
+		root.SetArgs([]string{getEnv("WORKFLOW_TYPE", "order")})
 	}
-	defer c.Close()
 
-	// Get task queue name
-	taskQueue := getEnv("ORDER_TASK_QUEUE", "order-task-queue")
+	if err := root.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
 
-	// Determine which workflow to run
-	workflowType := getEnv("WORKFLOW_TYPE", "order")
+// globalOptions are the connection flags shared by every subcommand
+type globalOptions struct {
+	temporalHost string
+	taskQueue    string
+}
 
-	switch workflowType {
-	case "order":
-		runOrderWorkflow(c, taskQueue)
-	default:
-		log.Fatalf("Unknown workflow type: %s (use 'greet' or 'order')", workflowType)
+// orderOptions are the flags of the order subcommand
+type orderOptions struct {
+	orderID     string
+	async       bool
+	autoApprove bool
+	shipCountry string
+	giftEmail   string
+	giftMessage string
+	paymentType string
+	paymentTok  string
+	watch       bool
+	watchFor    time.Duration
+}
+
+// memoOptions are the memo flags of the subcommands that start orders
+type memoOptions struct {
+	source        string
+	segment       string
+	correlationID string
+}
+
+// addMemoFlags registers the memo flags on cmd. An empty correlation ID gets a
+// new UUID before the command runs, shared by every order it starts.
+func addMemoFlags(cmd *cobra.Command, memo *memoOptions) {
+	cmd.Flags().StringVar(&memo.source, "source", getEnv("ORDER_SOURCE", "starter"), "Memo: where the order came from")
+	cmd.Flags().StringVar(&memo.segment, "segment", getEnv("CUSTOMER_SEGMENT", "demo"), "Memo: customer segment")
+	cmd.Flags().StringVar(&memo.correlationID, "correlation-id", getEnv("CORRELATION_ID", ""), "Memo: ID correlating the orders with the caller's logs (default: a new UUID)")
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		if memo.correlationID == "" {
+			memo.correlationID = uuid.NewString()
+		}
+	}
+}
+
+// newRootCmd builds the CLI. Flag defaults come from the environment variables
+// the starter used before it had subcommands.
+func newRootCmd() *cobra.Command {
+	global := &globalOptions{}
+	root := &cobra.Command{
+		Use:          "starter",
+		Short:        "Start and manage order workflows",
+		SilenceUsage: true,
+	}
+	root.PersistentFlags().StringVar(&global.temporalHost, "temporal-host", getEnv("TEMPORAL_HOST", "localhost:7233"), "Temporal frontend address")
+	root.PersistentFlags().StringVar(&global.taskQueue, "task-queue", getEnv("ORDER_TASK_QUEUE", "order-task-queue"), "Task queue the workers poll")
+
+	order := &orderOptions{}
+	memo := &memoOptions{}
+	orderCmd := &cobra.Command{
+		Use:   "order",
+		Short: "Start an OrderWorkflow with demo items and wait for the result",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			runOrderWorkflow(c, global.taskQueue, *order, *memo)
+		},
+	}
+	orderCmd.Flags().StringVar(&order.orderID, "order-id", getEnv("ORDER_ID", fmt.Sprintf("ORDER-%d", time.Now().Unix())), "Order identifier")
+	orderCmd.Flags().BoolVar(&order.async, "async", getEnv("ASYNC", "false") == "true", "Start the workflow without waiting for it")
+	orderCmd.Flags().BoolVar(&order.autoApprove, "auto-approve", getEnv("AUTO_APPROVE", "false") == "true", "Set a demo shipping address and approve payment after 2s")
+	orderCmd.Flags().StringVar(&order.shipCountry, "ship-country", getEnv("SHIP_COUNTRY", "US"), "Country of the demo shipping address")
+	orderCmd.Flags().StringVar(&order.giftEmail, "gift-email", "", "Send the order as a gift to this email address")
+	orderCmd.Flags().StringVar(&order.giftMessage, "gift-message", "", "Personal note for the gift recipient")
+	orderCmd.Flags().StringVar(&order.paymentType, "payment-method", "", "Payment method type: card, wallet or bank (default: the card on file)")
+	orderCmd.Flags().StringVar(&order.paymentTok, "payment-token", "", "Gateway token for --payment-method")
+	orderCmd.Flags().BoolVar(&order.watch, "watch", false, "Print stage transitions while waiting for the result")
+	orderCmd.Flags().DurationVar(&order.watchFor, "watch-timeout", 10*time.Minute, "Stop printing stage transitions after this long (0 = no limit)")
+	addMemoFlags(orderCmd, memo)
+
+	var batchSize, batchConcurrency int
+	batchCmd := &cobra.Command{
+		Use:   "order-batch",
+		Short: "Start a batch of OrderWorkflows for load testing",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			runOrderBatch(c, global.taskQueue, batchSize, batchConcurrency, *memo)
+		},
+	}
+	batchCmd.Flags().IntVar(&batchSize, "size", getEnvInt("BATCH_SIZE", 10), "Number of orders to start")
+	batchCmd.Flags().IntVar(&batchConcurrency, "concurrency", getEnvInt("BATCH_CONCURRENCY", 5), "Max concurrent start requests")
+	addMemoFlags(batchCmd, memo)
+
+	var namespace string
+	searchAttributesCmd := &cobra.Command{
+		Use:   "register-search-attributes",
+		Short: "Register the CustomerTier and OrderStage search attributes",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			registerSearchAttributes(c, namespace)
+		},
+	}
+	searchAttributesCmd.Flags().StringVar(&namespace, "namespace", getEnv("TEMPORAL_NAMESPACE", "default"), "Namespace to register the attributes in")
+
+	var approvedBy string
+	approveCmd := &cobra.Command{
+		Use:   "approve <order-id>",
+		Short: "Send the approve-payment signal to an order",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			signalOrder(c, args[0], "approve-payment", types.PaymentApproval{ApprovedBy: approvedBy, Timestamp: time.Now()})
+		},
+	}
+	approveCmd.Flags().StringVar(&approvedBy, "by", "cli", "Approver recorded on the order")
+
+	var cancel types.CancelRequest
+	cancelCmd := &cobra.Command{
+		Use:   "cancel <order-id>",
+		Short: "Send the cancel-order signal to an order",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			signalOrder(c, args[0], "cancel-order", cancel)
+		},
+	}
+	cancelCmd.Flags().StringVar((*string)(&cancel.Reason), "reason", string(types.ReasonCustomerRequested),
+		"Cancellation reason: customer-requested, fraud-detected, timeout, out-of-stock or payment-failed")
+	cancelCmd.Flags().StringVar(&cancel.Note, "note", "cancelled via CLI", "Free-text note sent with the reason")
+	cancelCmd.Flags().BoolVar(&cancel.Force, "force", false, "Cancel even if payment was already charged (refunds it)")
+
+	var pause types.PauseRequest
+	pauseCmd := &cobra.Command{
+		Use:   "pause <order-id>",
+		Short: "Send the pause-order signal, holding the order at its next stage boundary",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			signalOrder(c, args[0], "pause-order", pause)
+		},
+	}
+	pauseCmd.Flags().StringVar(&pause.By, "by", "cli", "Operator recorded on the pause")
+	pauseCmd.Flags().StringVar(&pause.Reason, "reason", "paused via CLI", "Why the order is held")
+
+	resumeCmd := &cobra.Command{
+		Use:   "resume <order-id>",
+		Short: "Send the resume-order signal to a paused order",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			signalOrder(c, args[0], "resume-order", nil)
+		},
 	}
+
+	var statusWatch bool
+	var statusWatchFor time.Duration
+	statusCmd := &cobra.Command{
+		Use:   "status <order-id>",
+		Short: "Print an order's status (get-status-dto query)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			if statusWatch {
+				watchOrder(context.Background(), c, orderWorkflowID(args[0]), statusWatchFor)
+			}
+			printOrderStatus(c, args[0])
+		},
+	}
+	statusCmd.Flags().BoolVar(&statusWatch, "watch", false, "Print stage transitions until the order completes, then its status")
+	statusCmd.Flags().DurationVar(&statusWatchFor, "watch-timeout", 10*time.Minute, "Stop watching after this long (0 = no limit)")
+
+	var reprocessPaymentType, reprocessPaymentToken string
+	reprocessCmd := &cobra.Command{
+		Use:   "reprocess <order-id>",
+		Short: "Re-run fulfilment of a failed order from its persisted status",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			runReprocessWorkflow(c, global.taskQueue, args[0], reprocessPaymentType, reprocessPaymentToken)
+		},
+	}
+	reprocessCmd.Flags().StringVar(&reprocessPaymentType, "payment-method", "", "Payment method type the order was placed with: card, wallet or bank (default: the card on file)")
+	reprocessCmd.Flags().StringVar(&reprocessPaymentToken, "payment-token", "", "Gateway token for --payment-method")
+
+	var burstCount int
+	var burstInterval time.Duration
+	burstCmd := &cobra.Command{
+		Use:   "signal-burst <order-id>",
+		Short: "Send a burst of add-line-item signals and time until get-items reflects them",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			runSignalBurst(c, args[0], burstCount, burstInterval)
+		},
+	}
+	burstCmd.Flags().IntVar(&burstCount, "count", 100, "Number of add-line-item signals to send")
+	burstCmd.Flags().DurationVar(&burstInterval, "interval", 0, "Pause between signals (0 sends them back to back)")
+
+	var bulkQuery string
+	var bulkConcurrency int
+	var bulkDryRun bool
+	var bulkApprovedBy string
+	var bulkCancel types.CancelRequest
+	bulkCmd := &cobra.Command{
+		Use:       "bulk-signal <approve|cancel>",
+		Short:     "Send approve-payment or cancel-order to every workflow matching a visibility query",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"approve", "cancel"},
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			if args[0] == "approve" {
+				runBulkSignal(c, bulkQuery, "approve-payment", types.PaymentApproval{ApprovedBy: bulkApprovedBy, Timestamp: time.Now()}, bulkConcurrency, bulkDryRun)
+			} else {
+				runBulkSignal(c, bulkQuery, "cancel-order", bulkCancel, bulkConcurrency, bulkDryRun)
+			}
+		},
+	}
+	bulkCmd.Flags().StringVar(&bulkQuery, "query",
+		fmt.Sprintf("WorkflowType = 'OrderWorkflow' AND ExecutionStatus = 'Running' AND %s = 'awaiting-approval'", workflows.OrderStageSearchAttribute.GetName()),
+		"Visibility query selecting the workflows to signal")
+	bulkCmd.Flags().IntVar(&bulkConcurrency, "concurrency", 5, "Max concurrent signal requests")
+	bulkCmd.Flags().BoolVar(&bulkDryRun, "dry-run", false, "List the matching workflows without signalling them")
+	bulkCmd.Flags().StringVar(&bulkApprovedBy, "by", "bulk-cli", "approve: approver recorded on each order")
+	bulkCmd.Flags().StringVar((*string)(&bulkCancel.Reason), "reason", string(types.ReasonCustomerRequested), "cancel: cancellation reason")
+	bulkCmd.Flags().StringVar(&bulkCancel.Note, "note", "cancelled in bulk via CLI", "cancel: free-text note sent with the reason")
+
+	contentionCmd := &cobra.Command{
+		Use:   "contend-last-unit",
+		Short: "Start two orders for the single LAST-001 unit and check only one reserves it",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			runLastUnitContention(c, global.taskQueue, *memo)
+		},
+	}
+	addMemoFlags(contentionCmd, memo)
+
+	var userID string
+	var replyTimeout time.Duration
+	greetCmd := &cobra.Command{
+		Use:   "greet",
+		Short: "Start the GreetUser workflow and wait for the result",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			c := dial(global)
+			defer c.Close()
+			runGreetWorkflow(c, global.taskQueue, userID, replyTimeout)
+		},
+	}
+	greetCmd.Flags().StringVar(&userID, "user-id", getEnv("USER_ID", "user-123"), "User to greet")
+	greetCmd.Flags().DurationVar(&replyTimeout, "reply-timeout", getEnvDuration("REPLY_TIMEOUT", 0), "Wait this long for a user-reply signal (0 skips the wait)")
+
+	root.AddCommand(orderCmd, batchCmd, searchAttributesCmd, approveCmd, cancelCmd, pauseCmd, resumeCmd, statusCmd, reprocessCmd, burstCmd, bulkCmd, contentionCmd, greetCmd)
+	return root
 }
 
-func runOrderWorkflow(c client.Client, taskQueue string) {
+func dial(global *globalOptions) client.Client {
+	// Payloads are encrypted when PAYLOAD_ENCRYPTION_KEY is set
+	dataConverter, err := encryption.DataConverterFromEnv()
+	if err != nil {
+		log.Fatalln("Unable to configure payload encryption", err)
+	}
+
+	c, err := retry.DialWithRetry(client.Options{
+		HostPort:      global.temporalHost,
+		DataConverter: dataConverter,
+	}, retry.DialAttempts, retry.DialBackoff)
+	if err != nil {
+		log.Fatalln("Unable to create Temporal client", err)
+	}
+	return c
+}
+
+func runOrderWorkflow(c client.Client, taskQueue string, opts orderOptions, memo memoOptions) {
 	// Generate workflow and order IDs
-	orderID := getEnv("ORDER_ID", fmt.Sprintf("ORDER-%d", time.Now().Unix()))
-	workflowID := fmt.Sprintf("order-workflow-%s", orderID)
+	orderID := opts.orderID
+	workflowID := orderWorkflowID(orderID)
 
 	// Prepare initial items
-	initialItems := []types.LineItem{
-		{SKU: "BOOK-001", Quantity: 2},
-		{SKU: "PEN-042", Quantity: 5},
+	initialItems := demoItems()
+	if err := types.ValidateOrder(initialItems); err != nil {
+		log.Fatalln("Invalid order", err)
 	}
 
-	// Configure workflow options
-	workflowOptions := client.StartWorkflowOptions{
-		ID:        workflowID,
-		TaskQueue: taskQueue,
+	var gift *types.GiftInfo
+	if opts.giftEmail != "" {
+		gift = &types.GiftInfo{RecipientEmail: opts.giftEmail, Message: opts.giftMessage}
+		if err := gift.Validate(); err != nil {
+			log.Fatalln("Invalid gift", err)
+		}
+	}
+	var paymentMethod *types.PaymentMethod
+	if opts.paymentType != "" {
+		paymentMethod = &types.PaymentMethod{Type: types.PaymentMethodType(opts.paymentType), Token: opts.paymentTok}
+		if err := paymentMethod.Validate(); err != nil {
+			log.Fatalln("Invalid payment method", err)
+		}
 	}
 
+	// Configure workflow options
+	workflowOptions := orderWorkflowOptions(workflowID, taskQueue, memo)
+
 	log.Printf("Starting OrderWorkflow: %s\n", workflowID)
 	log.Printf("Order ID: %s\n", orderID)
+	log.Printf("Correlation ID: %s\n", memo.correlationID)
 
-	// Start workflow
-	we, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.OrderWorkflow, orderID, initialItems)
-	if err != nil {
+	// Start workflow; a duplicate order ID attaches to the existing run instead of starting another
+	we, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.OrderWorkflow, types.OrderInput{
+		OrderID:       orderID,
+		Items:         initialItems,
+		Gift:          gift,
+		PaymentMethod: paymentMethod,
+	})
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	if errors.As(err, &alreadyStarted) {
+		log.Printf("Order %s already has a workflow (run %s), attaching to it\n", orderID, alreadyStarted.RunId)
+		we = c.GetWorkflow(context.Background(), workflowID, alreadyStarted.RunId)
+	} else if err != nil {
 		log.Fatalln("Unable to start workflow", err)
 	}
 
@@ -71,12 +378,14 @@ func runOrderWorkflow(c client.Client, taskQueue string) {
 	log.Printf("\n  Approve payment:\n")
 	log.Printf("    tctl workflow signal -w %s -n approve-payment -i '{\"ApprovedBy\":\"admin\"}'\n", workflowID)
 	log.Printf("\n  Cancel order:\n")
-	log.Printf("    tctl workflow signal -w %s -n cancel-order -i '{\"Reason\":\"customer requested\"}'\n", workflowID)
+	log.Printf("    tctl workflow signal -w %s -n cancel-order -i '{\"Reason\":\"customer-requested\",\"Note\":\"changed my mind\"}'\n", workflowID)
+	log.Printf("\n  Set shipping address (required before payment):\n")
+	log.Printf("    temporal workflow update execute --workflow-id %s --name update-shipping-address --input '{\"Street\":\"1 Main St\",\"City\":\"Springfield\",\"PostalCode\":\"12345\",\"Country\":\"US\"}'\n", workflowID)
 	log.Printf("\n  Add item:\n")
-	log.Printf("    tctl workflow signal -w %s -n add-line-item -i '{\"SKU\":\"ITEM-999\",\"Quantity\":3}'\n", workflowID)
+	log.Printf("    tctl workflow signal -w %s -n add-line-item -i '{\"SKU\":\"ITEM-999\",\"Quantity\":3,\"UnitPrice\":9.99,\"Currency\":\"USD\"}'\n", workflowID)
 
 	// Check if we should wait for completion or run async
-	if getEnv("ASYNC", "false") == "true" {
+	if opts.async {
 		log.Printf("\n🚀 Workflow started asynchronously. Use the commands above to interact.\n")
 		return
 	}
@@ -84,11 +393,27 @@ func runOrderWorkflow(c client.Client, taskQueue string) {
 	log.Printf("\n⏳ Waiting for workflow to complete (send approval signal to proceed)...\n")
 
 	// Optional: Send approval automatically after a delay for testing
-	if getEnv("AUTO_APPROVE", "false") == "true" {
+	if opts.autoApprove {
 		go func() {
 			time.Sleep(2 * time.Second)
+
+			// Tax is deferred until a shipping address is known, so supply one first
+			log.Printf("\n🤖 Setting shipping address...\n")
+			handle, err := c.UpdateWorkflow(context.Background(), client.UpdateWorkflowOptions{
+				WorkflowID:   workflowID,
+				UpdateName:   "update-shipping-address",
+				Args:         []interface{}{demoShippingAddress(opts.shipCountry)},
+				WaitForStage: client.WorkflowUpdateStageCompleted,
+			})
+			if err == nil {
+				err = handle.Get(context.Background(), nil)
+			}
+			if err != nil {
+				log.Printf("Failed to update shipping address: %v\n", err)
+			}
+
 			log.Printf("\n🤖 Auto-approving payment...\n")
-			err := c.SignalWorkflow(
+			err = c.SignalWorkflow(
 				context.Background(),
 				workflowID,
 				"",
@@ -101,10 +426,34 @@ func runOrderWorkflow(c client.Client, taskQueue string) {
 		}()
 	}
 
+	// Follow the stages until the result is in
+	watchDone := make(chan struct{})
+	stopWatch := func() {}
+	if opts.watch {
+		var watchCtx context.Context
+		watchCtx, stopWatch = context.WithCancel(context.Background())
+		go func() {
+			defer close(watchDone)
+			watchOrder(watchCtx, c, workflowID, opts.watchFor)
+		}()
+	} else {
+		close(watchDone)
+	}
+
 	// Wait for workflow result
 	var result string
 	err = we.Get(context.Background(), &result)
+	stopWatch()
+	<-watchDone
 	if err != nil {
+		// Distinguish business rejections from infrastructure failures
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) && appErr.Type() == "InsufficientInventoryError" {
+			log.Fatalf("🚫 Order rejected - insufficient inventory: %v\n", appErr.Message())
+		}
+		if errors.As(err, &appErr) && appErr.Type() == "PaymentDeclinedError" {
+			log.Fatalf("💳 Payment declined: %v\n", appErr.Message())
+		}
 		log.Fatalf("❌ Workflow execution failed: %v\n", err)
 	}
 
@@ -112,7 +461,7 @@ func runOrderWorkflow(c client.Client, taskQueue string) {
 	log.Printf("Result: %s\n", result)
 
 	// Query final status
-	queryResp, err := c.QueryWorkflow(context.Background(), workflowID, "", "get-status")
+	queryResp, err := c.QueryWorkflow(context.Background(), workflowID, "", "get-status", types.RoleAdmin)
 	if err != nil {
 		log.Printf("Failed to query status: %v\n", err)
 	} else {
@@ -121,13 +470,497 @@ func runOrderWorkflow(c client.Client, taskQueue string) {
 			log.Printf("\n📊 Final Status:\n")
 			log.Printf("  Stage: %s\n", status.Stage)
 			log.Printf("  Items: %d\n", len(status.Items))
+			log.Printf("  Subtotal: %.2f\n", status.Total())
+			log.Printf("  Tax: %.2f\n", status.TaxAmount)
+			log.Printf("  Shipping: %.2f\n", status.ShippingCost)
+			log.Printf("  Total: %.2f %s\n", status.OriginalAmount, status.OriginalCurrency)
+			log.Printf("  Settled: %.2f %s\n", status.SettlementAmount, status.SettlementCurrency)
 			log.Printf("  Reserved: %v\n", status.Reserved)
 			log.Printf("  Charged: %v\n", status.Charged)
+			log.Printf("  Tracking: %s\n", status.TrackingNumber)
 			log.Printf("  Version: %s\n", status.Version)
 		}
 	}
 }
 
+func runOrderBatch(c client.Client, taskQueue string, batchSize, concurrency int, memo memoOptions) {
+	batchID := time.Now().Unix()
+
+	log.Printf("Starting %d OrderWorkflows (concurrency %d, correlation ID %s)\n", batchSize, concurrency, memo.correlationID)
+
+	// Bounded worker pool so the frontend isn't flooded with start requests
+	orderIDs := make(chan string)
+	failures := make(chan error, batchSize)
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for orderID := range orderIDs {
+				workflowOptions := orderWorkflowOptions(orderWorkflowID(orderID), taskQueue, memo)
+				_, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.OrderWorkflow, types.OrderInput{OrderID: orderID, Items: demoItems()})
+				var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+				if errors.As(err, &alreadyStarted) {
+					log.Printf("Skipping %s: already started (run %s)\n", orderID, alreadyStarted.RunId)
+					continue
+				}
+				if err != nil {
+					failures <- fmt.Errorf("%s: %w", orderID, err)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < batchSize; i++ {
+		orderIDs <- fmt.Sprintf("ORDER-%d-%04d", batchID, i)
+	}
+	close(orderIDs)
+	wg.Wait()
+	close(failures)
+
+	failed := 0
+	for err := range failures {
+		failed++
+		log.Printf("❌ Failed to start %v\n", err)
+	}
+
+	log.Printf("\n📊 Batch Summary:\n")
+	log.Printf("  Started: %d\n", batchSize-failed)
+	log.Printf("  Failed: %d\n", failed)
+	log.Printf("  Elapsed: %s\n", time.Since(start).Round(time.Millisecond))
+	log.Printf("\nOrders await approval; list them with: temporal workflow list --query 'WorkflowId STARTS_WITH \"order-workflow-ORDER-%d\"'\n", batchID)
+}
+
+// registerSearchAttributes adds the custom search attributes OrderWorkflow upserts
+func registerSearchAttributes(c client.Client, namespace string) {
+	_, err := c.OperatorService().AddSearchAttributes(context.Background(), &operatorservice.AddSearchAttributesRequest{
+		Namespace: namespace,
+		SearchAttributes: map[string]enums.IndexedValueType{
+			workflows.CustomerTierSearchAttribute.GetName(): enums.INDEXED_VALUE_TYPE_KEYWORD,
+			workflows.OrderStageSearchAttribute.GetName():   enums.INDEXED_VALUE_TYPE_KEYWORD,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Unable to register search attributes (they may already exist): %v\n", err)
+	}
+
+	log.Printf("✅ Registered search attributes CustomerTier and OrderStage in namespace %s\n", namespace)
+	log.Printf("Filter orders with: temporal workflow list --query 'CustomerTier=\"Gold\" AND OrderStage=\"awaiting-approval\"'\n")
+}
+
+// orderWorkflowOptions sets the timeouts that stop stuck orders lingering forever.
+// The execution timeout spans continue-as-new runs and must outlast the longest
+// approval window (1h for Platinum customers).
+//
+// Starting an order ID that is already running fails with
+// WorkflowExecutionAlreadyStarted instead of silently returning the running
+// workflow, so callers notice the double submission; the reuse policy decides
+// whether a closed order's ID may be started again.
+func orderWorkflowOptions(workflowID, taskQueue string, memo memoOptions) client.StartWorkflowOptions {
+	return client.StartWorkflowOptions{
+		ID:                                       workflowID,
+		TaskQueue:                                taskQueue,
+		Memo:                                     orderMemo(memo.source, memo.segment, memo.correlationID),
+		WorkflowExecutionTimeout:                 getEnvDuration("ORDER_EXECUTION_TIMEOUT", 2*time.Hour),
+		WorkflowRunTimeout:                       getEnvDuration("ORDER_RUN_TIMEOUT", 0),
+		WorkflowIDReusePolicy:                    workflowIDReusePolicy(getEnv("ORDER_ID_REUSE_POLICY", "reject-duplicate")),
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}
+}
+
+// orderMemo builds the memo of an order workflow. Memos are shown in the UI and
+// returned by list calls without registering search attributes, but they can't
+// be filtered on.
+func orderMemo(source, segment, correlationID string) map[string]interface{} {
+	return map[string]interface{}{
+		"source":          source,
+		"customerSegment": segment,
+		"correlationId":   correlationID,
+	}
+}
+
+// workflowIDReusePolicy maps ORDER_ID_REUSE_POLICY to the server enum
+func workflowIDReusePolicy(name string) enums.WorkflowIdReusePolicy {
+	switch name {
+	case "reject-duplicate":
+		return enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE
+	case "allow-duplicate-failed-only":
+		return enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE_FAILED_ONLY
+	case "allow-duplicate":
+		return enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE
+	default:
+		log.Fatalf("Unknown ORDER_ID_REUSE_POLICY: %s (use 'reject-duplicate', 'allow-duplicate-failed-only' or 'allow-duplicate')", name)
+		return enums.WORKFLOW_ID_REUSE_POLICY_UNSPECIFIED
+	}
+}
+
+func demoItems() []types.LineItem {
+	return []types.LineItem{
+		{SKU: "BOOK-001", Quantity: 2, UnitPrice: 24.99, Currency: "USD"},
+		{SKU: "PEN-042", Quantity: 5, UnitPrice: 1.49, Currency: "USD"},
+	}
+}
+
+// signalOrder sends a signal to the latest run of an order's workflow
+func signalOrder(c client.Client, orderID, signalName string, payload interface{}) {
+	err := c.SignalWorkflow(context.Background(), orderWorkflowID(orderID), "", signalName, payload)
+	if err != nil {
+		log.Fatalf("Unable to send %s to order %s: %v\n", signalName, orderID, err)
+	}
+	log.Printf("✅ Sent %s to order %s\n", signalName, orderID)
+}
+
+// runBulkSignal sends signalName with payload to every workflow matching query
+// and reports which signals failed. A workflow that closes between listing and
+// signalling shows up as a failure.
+func runBulkSignal(c client.Client, query, signalName string, payload interface{}, concurrency int, dryRun bool) {
+	workflowIDs, err := listWorkflowIDs(c, query)
+	if err != nil {
+		log.Fatalln("Unable to list workflows", err)
+	}
+	log.Printf("%d workflows match %s\n", len(workflowIDs), query)
+	if dryRun {
+		for _, workflowID := range workflowIDs {
+			log.Printf("  %s\n", workflowID)
+		}
+		return
+	}
+
+	// Bounded worker pool, as in runOrderBatch
+	ids := make(chan string)
+	failures := make(chan error, len(workflowIDs))
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for i := 0; i < max(concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for workflowID := range ids {
+				// An empty run ID targets the latest run, which survives continue-as-new
+				if err := c.SignalWorkflow(context.Background(), workflowID, "", signalName, payload); err != nil {
+					failures <- fmt.Errorf("%s: %w", workflowID, err)
+				}
+			}
+		}()
+	}
+
+	for _, workflowID := range workflowIDs {
+		ids <- workflowID
+	}
+	close(ids)
+	wg.Wait()
+	close(failures)
+
+	failed := 0
+	for err := range failures {
+		failed++
+		log.Printf("❌ Failed to send %s to %v\n", signalName, err)
+	}
+
+	log.Printf("\n📊 Bulk %s Summary:\n", signalName)
+	log.Printf("  Matched: %d\n", len(workflowIDs))
+	log.Printf("  Signalled: %d\n", len(workflowIDs)-failed)
+	log.Printf("  Failed: %d\n", failed)
+	log.Printf("  Elapsed: %s\n", time.Since(start).Round(time.Millisecond))
+}
+
+// listWorkflowIDs returns the IDs of every workflow matching query, following
+// the pagination of ListWorkflow. All pages are read before anything is
+// signalled, since the signals move orders out of the stage a query usually
+// filters on and would shift the later pages.
+func listWorkflowIDs(c client.Client, query string) ([]string, error) {
+	var workflowIDs []string
+	var pageToken []byte
+	for {
+		resp, err := c.ListWorkflow(context.Background(), &workflowservice.ListWorkflowExecutionsRequest{
+			Query:         query,
+			PageSize:      100,
+			NextPageToken: pageToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, execution := range resp.GetExecutions() {
+			workflowIDs = append(workflowIDs, execution.GetExecution().GetWorkflowId())
+		}
+		pageToken = resp.GetNextPageToken()
+		if len(pageToken) == 0 {
+			return workflowIDs, nil
+		}
+	}
+}
+
+// runSignalBurst sends count add-line-item signals with unique SKUs to an order
+// awaiting approval, then polls get-items until every burst SKU shows up. A
+// signal the selector loop drops shows up as a timeout with missing SKUs.
+func runSignalBurst(c client.Client, orderID string, count int, interval time.Duration) {
+	workflowID := orderWorkflowID(orderID)
+	burstID := time.Now().Unix()
+	sent := make(map[string]bool, count)
+
+	log.Printf("Sending %d add-line-item signals to %s (interval %s)\n", count, workflowID, interval)
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		item := types.LineItem{SKU: fmt.Sprintf("BURST-%d-%04d", burstID, i), Quantity: 1, UnitPrice: 0.99, Currency: "USD"}
+		if err := c.SignalWorkflow(context.Background(), workflowID, "", "add-line-item", item); err != nil {
+			log.Fatalf("Unable to send signal %d: %v\n", i, err)
+		}
+		sent[item.SKU] = true
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+	sendElapsed := time.Since(start)
+	log.Printf("Sent %d signals in %s\n", count, sendElapsed.Round(time.Millisecond))
+
+	deadline := time.Now().Add(2 * time.Minute)
+	missing := count
+	for {
+		resp, err := c.QueryWorkflow(context.Background(), workflowID, "", "get-items")
+		if err != nil {
+			log.Fatalf("Unable to query order %s: %v\n", orderID, err)
+		}
+		var items []types.LineItem
+		if err := resp.Get(&items); err != nil {
+			log.Fatalf("Unable to decode items: %v\n", err)
+		}
+		missing = count
+		for _, item := range items {
+			if sent[item.SKU] {
+				missing--
+			}
+		}
+		if missing == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	log.Printf("\n📊 Signal Burst Summary:\n")
+	log.Printf("  Sent: %d in %s\n", count, sendElapsed.Round(time.Millisecond))
+	if missing > 0 {
+		log.Fatalf("  ❌ %d of %d items still missing from get-items after 2m\n", missing, count)
+	}
+	log.Printf("  Reflected in get-items after: %s\n", time.Since(start).Round(time.Millisecond))
+}
+
+// runLastUnitContention starts two orders for the one LAST-001 unit at the same
+// time and waits until each has either reserved it (awaiting approval) or
+// failed. Exactly one must win; the winner is then cancelled so the unit is
+// back in stock for the next run. Run the worker with
+// SERIALIZE_RESERVATIONS=true to see the InventoryLockWorkflow hand the lock
+// from one order to the other.
+func runLastUnitContention(c client.Client, taskQueue string, memo memoOptions) {
+	contentionID := time.Now().Unix()
+	orderIDs := []string{fmt.Sprintf("ORDER-%d-A", contentionID), fmt.Sprintf("ORDER-%d-B", contentionID)}
+	items := []types.LineItem{{SKU: "LAST-001", Quantity: 1, UnitPrice: 99.00, Currency: "USD"}}
+
+	log.Printf("Starting %s and %s for the last unit of LAST-001\n", orderIDs[0], orderIDs[1])
+	var wg sync.WaitGroup
+	for _, orderID := range orderIDs {
+		wg.Add(1)
+		go func(orderID string) {
+			defer wg.Done()
+			workflowOptions := orderWorkflowOptions(orderWorkflowID(orderID), taskQueue, memo)
+			_, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.OrderWorkflow, types.OrderInput{OrderID: orderID, Items: items})
+			if err != nil {
+				log.Fatalf("Unable to start %s: %v\n", orderID, err)
+			}
+		}(orderID)
+	}
+	wg.Wait()
+
+	var winners []string
+	deadline := time.Now().Add(2 * time.Minute)
+	for _, orderID := range orderIDs {
+		outcome := ""
+		for outcome == "" {
+			if time.Now().After(deadline) {
+				log.Fatalf("❌ %s neither reserved the unit nor failed within 2m\n", orderID)
+			}
+			time.Sleep(200 * time.Millisecond)
+			desc, err := c.DescribeWorkflowExecution(context.Background(), orderWorkflowID(orderID), "")
+			if err != nil {
+				log.Fatalf("Unable to describe %s: %v\n", orderID, err)
+			}
+			if desc.WorkflowExecutionInfo.Status != enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+				err := c.GetWorkflow(context.Background(), orderWorkflowID(orderID), "").Get(context.Background(), nil)
+				outcome = fmt.Sprintf("lost: %v", err)
+				continue
+			}
+			resp, err := c.QueryWorkflow(context.Background(), orderWorkflowID(orderID), "", "get-status-dto")
+			if err != nil {
+				continue
+			}
+			var status types.StatusDTO
+			if err := resp.Get(&status); err == nil && status.Stage == "awaiting-approval" {
+				outcome = "won: reserved the unit"
+				winners = append(winners, orderID)
+			}
+		}
+		log.Printf("  %s %s\n", orderID, outcome)
+	}
+
+	for _, orderID := range winners {
+		signalOrder(c, orderID, "cancel-order", types.CancelRequest{Reason: types.ReasonCustomerRequested, Note: "contention demo, returning the unit"})
+	}
+	if len(winners) != 1 {
+		log.Fatalf("❌ %d orders reserved the last unit, want exactly 1\n", len(winners))
+	}
+	log.Printf("✅ Only %s got the last unit\n", winners[0])
+}
+
+// printOrderStatus queries get-status-dto and prints it as JSON
+func printOrderStatus(c client.Client, orderID string) {
+	resp, err := c.QueryWorkflow(context.Background(), orderWorkflowID(orderID), "", "get-status-dto")
+	if err != nil {
+		log.Fatalf("Unable to query order %s: %v\n", orderID, err)
+	}
+	var status types.StatusDTO
+	if err := resp.Get(&status); err != nil {
+		log.Fatalf("Unable to decode status: %v\n", err)
+	}
+	out, _ := json.MarshalIndent(status, "", "  ")
+	fmt.Println(string(out))
+}
+
+// Poll intervals of watchOrder: the first poll after a stage change, and the
+// cap it backs off to while the stage stays the same
+const (
+	watchMinInterval = 1 * time.Second
+	watchMaxInterval = 8 * time.Second
+)
+
+// watchOrder polls the get-status-dto query of the order workflow and prints
+// every stage it enters until the order is terminal, its workflow has closed,
+// ctx is done or maxWait (0 = no limit) has passed. The interval doubles up to
+// watchMaxInterval while nothing changes. Failed queries, e.g. before a worker
+// picked up the first workflow task, are retried on the same schedule.
+func watchOrder(ctx context.Context, c client.Client, workflowID string, maxWait time.Duration) {
+	if maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxWait)
+		defer cancel()
+	}
+
+	interval := watchMinInterval
+	lastStage := ""
+	for {
+		resp, err := c.QueryWorkflow(ctx, workflowID, "", "get-status-dto")
+		var status types.StatusDTO
+		if err == nil {
+			err = resp.Get(&status)
+		}
+		switch {
+		case ctx.Err() != nil:
+			// Stopped mid-query; the select below returns
+		case err != nil:
+			log.Printf("⏳ Status not available yet, retrying in %s: %v\n", interval, err)
+		case status.Stage != lastStage:
+			paused := ""
+			if status.Paused {
+				paused = " (paused)"
+			}
+			log.Printf("➡️  Stage: %s%s\n", status.Stage, paused)
+			lastStage = status.Stage
+			interval = watchMinInterval
+			if status.IsTerminal {
+				return
+			}
+		default:
+			// A failed order stays in the stage it failed in
+			desc, err := c.DescribeWorkflowExecution(ctx, workflowID, "")
+			if err == nil && desc.WorkflowExecutionInfo.Status != enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+				log.Printf("🏁 Workflow closed in stage %s: %s\n", status.Stage, desc.WorkflowExecutionInfo.Status)
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				log.Printf("⌛ Stopped watching after %s\n", maxWait)
+			}
+			return
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, watchMaxInterval)
+	}
+}
+
+// runReprocessWorkflow starts ReprocessOrderWorkflow and waits for it. Its ID
+// is derived from the order, and a completed reprocess can't be started again;
+// a failed one can be retried. An order placed with a payment method needs
+// it again, since its status only keeps the masked form.
+func runReprocessWorkflow(c client.Client, taskQueue, orderID, paymentType, paymentToken string) {
+	var paymentMethod *types.PaymentMethod
+	if paymentType != "" {
+		paymentMethod = &types.PaymentMethod{Type: types.PaymentMethodType(paymentType), Token: paymentToken}
+		if err := paymentMethod.Validate(); err != nil {
+			log.Fatalln("Invalid payment method", err)
+		}
+	}
+	workflowOptions := client.StartWorkflowOptions{
+		ID:                    "reprocess-" + orderID,
+		TaskQueue:             taskQueue,
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE_FAILED_ONLY,
+	}
+
+	log.Printf("Starting ReprocessOrderWorkflow: %s\n", workflowOptions.ID)
+	we, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.ReprocessOrderWorkflow, orderID, paymentMethod)
+	if err != nil {
+		log.Fatalln("Unable to start workflow", err)
+	}
+
+	var result string
+	if err := we.Get(context.Background(), &result); err != nil {
+		log.Fatalln("Reprocess failed", err)
+	}
+	log.Printf("✅ %s\n", result)
+}
+
+func runGreetWorkflow(c client.Client, taskQueue, userID string, replyTimeout time.Duration) {
+	workflowID := fmt.Sprintf("greet-workflow-%d", time.Now().Unix())
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        workflowID,
+		TaskQueue: taskQueue,
+	}
+
+	log.Printf("Starting GreetUser workflow: %s\n", workflowID)
+	we, err := c.ExecuteWorkflow(context.Background(), workflowOptions, greetworkflows.GreetUser, greetworkflows.GreetUserInput{UserID: userID, ReplyTimeout: replyTimeout})
+	if err != nil {
+		log.Fatalln("Unable to start workflow", err)
+	}
+	if replyTimeout > 0 {
+		log.Printf("Reply within %s with: temporal workflow signal --workflow-id %s --name %s --input '{\"Message\":\"...\"}'\n", replyTimeout, workflowID, greetworkflows.SignalUserReply)
+	}
+
+	var result greetworkflows.GreetUserOutput
+	if err := we.Get(context.Background(), &result); err != nil {
+		log.Fatalln("Workflow execution failed", err)
+	}
+	log.Printf("✅ %s\n", result.Message)
+	if replyTimeout > 0 {
+		log.Printf("Reply: %q\n", result.Reply)
+	}
+}
+
+func orderWorkflowID(orderID string) string {
+	return fmt.Sprintf("order-workflow-%s", orderID)
+}
+
+func demoShippingAddress(country string) types.ShippingAddress {
+	return types.ShippingAddress{
+		Street:     "1 Main St",
+		City:       "Springfield",
+		PostalCode: "12345",
+		Country:    country,
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -135,3 +968,28 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvInt reads a positive integer from the environment, exiting on invalid values
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid %s=%q: must be a positive integer", key, value)
+	}
+	return n
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", key, value, err)
+	}
+	return d
+}
@@ -0,0 +1,108 @@
+// Package testutil runs the order workflows in the SDK's in-memory test
+// environment with NewOrderTestEnv, or against a real Temporal dev server
+// with StartTestServer for end-to-end tests (build tag "integration").
+package testutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/types"
+	"go-temporal-fast-course/order-processing/workflows"
+)
+
+// NewOrderTestEnv returns an in-memory test workflow environment with every
+// order workflow and activity registered as in RegisterOrderWorker, so no dev
+// server is needed. Inventory and payment are mocked: every item is in stock,
+// reservations and charges succeed, and the customer has opted in to email.
+// The other activities run unmocked without failures or latency.
+//
+// Mocks are matched in the order they were set, so a test that needs another
+// outcome from a mocked activity sets it in overrides, which run before the
+// defaults. Expectations set on the environment are asserted when the test
+// finishes.
+func NewOrderTestEnv(t testing.TB, overrides ...func(env *testsuite.TestWorkflowEnvironment)) *testsuite.TestWorkflowEnvironment {
+	t.Helper()
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	RegisterOrderWorker(env, nil, activities.FailureConfig{})
+	for _, override := range overrides {
+		override(env)
+	}
+	mockOrderDefaults(env)
+	t.Cleanup(func() { env.AssertExpectations(t) })
+	return env
+}
+
+// mockOrderDefaults mocks the activities whose outcome order tests depend on
+func mockOrderDefaults(env *testsuite.TestWorkflowEnvironment) {
+	env.OnActivity(activities.ActivityFetchInventorySnapshot, mock.Anything, mock.Anything).
+		Return(func(_ context.Context, items []types.LineItem) (map[string]int, error) {
+			availability := make(map[string]int, len(items))
+			for _, item := range items {
+				availability[item.SKU] += item.Quantity
+			}
+			return availability, nil
+		}).Maybe()
+	env.OnActivity(activities.ActivityReserveStock, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(func(_ context.Context, orderID string, items []types.LineItem, _ string) (types.ReservationResult, error) {
+			result := types.ReservationResult{OrderID: orderID}
+			for _, item := range items {
+				result.ReservedSKUs = append(result.ReservedSKUs, item.SKU)
+			}
+			return result, nil
+		}).Maybe()
+	env.OnActivity(activities.ActivityProcessPayment, mock.Anything, mock.Anything).
+		Return(func(_ context.Context, req types.PaymentRequest) (types.PaymentReceipt, error) {
+			return types.PaymentReceipt{TransactionID: "TXN-" + req.OrderID, Amount: req.Amount}, nil
+		}).Maybe()
+	env.OnActivity(activities.ActivityFetchNotificationPrefs, mock.Anything, mock.Anything).
+		Return(types.NotificationPrefs{EmailOptIn: true}, nil).Maybe()
+}
+
+// RegisterOrderWorker registers the order workflows and activities with
+// workflows.Register, as worker/main.go does. c is used by the lock activities
+// to signal InventoryLockWorkflow.
+func RegisterOrderWorker(w worker.Registry, c client.Client, failures activities.FailureConfig) {
+	workflows.Register(w, TestActivities(c, failures))
+}
+
+// TestActivities returns the activities the worker runs, configured with the
+// given failure rates. The simulated latencies are skipped so tests don't wait
+// for them, and there is no database: PersistStatus is a no-op.
+func TestActivities(c client.Client, failures activities.FailureConfig) activities.Set {
+	noDelay := activities.Latency{Sleep: activities.NoSleep}
+
+	inventoryActivities := activities.NewInventoryActivities(activities.DemoWarehouses(), failures)
+	inventoryActivities.Latency = noDelay
+	paymentActivities := activities.NewPaymentActivities(0, nil, failures)
+	paymentActivities.Latency = noDelay
+	notificationActivities := activities.NewNotificationActivities(failures)
+	notificationActivities.Channels = map[string]activities.NotificationChannel{
+		"email": &activities.EmailChannel{Latency: noDelay, FailureRate: failures.EmailErrorRate},
+		"sms":   &activities.SMSChannel{Latency: noDelay, FailureRate: failures.SMSErrorRate},
+	}
+
+	return activities.Set{
+		Inventory:      inventoryActivities,
+		Lock:           &activities.LockActivities{Client: c},
+		Payment:        paymentActivities,
+		Customer:       &activities.CustomerActivities{Latency: noDelay},
+		Recommendation: &activities.RecommendationActivities{Latency: noDelay},
+		Shipping:       &activities.ShippingActivities{Latency: noDelay, Failures: failures},
+		Tax:            &activities.TaxActivities{},
+		Promo:          &activities.PromoActivities{Latency: noDelay},
+		Currency:       &activities.CurrencyActivities{},
+		Order:          &activities.OrderActivities{Latency: noDelay, Failures: failures},
+		Notification:   notificationActivities,
+		Webhook:        &activities.WebhookActivities{},
+		Event:          &activities.EventActivities{Broker: activities.NoopBroker{}},
+	}
+}
@@ -0,0 +1,32 @@
+package testutil_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/testutil"
+	"go-temporal-fast-course/order-processing/types"
+	"go-temporal-fast-course/order-processing/workflows"
+)
+
+func TestOrderCancelReleasesStock(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t)
+	env.OnActivity(activities.ActivityReleaseStock, mock.Anything, mock.Anything).Return(nil).Once()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("cancel-order", types.CancelRequest{Reason: types.ReasonCustomerRequested, Note: "test"})
+	}, time.Minute)
+
+	env.ExecuteWorkflow(workflows.OrderWorkflow, types.OrderInput{
+		OrderID: "ORDER-1",
+		Items:   []types.LineItem{{SKU: "BOOK-001", Quantity: 1, UnitPrice: 10}},
+	})
+
+	require.True(t, env.IsWorkflowCompleted())
+	var result string
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Contains(t, result, "cancelled")
+}
@@ -0,0 +1,52 @@
+//go:build integration
+
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-temporal-fast-course/order-processing/activities"
+
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+)
+
+// StartTestServer starts a dev server and an order worker on a fresh task queue.
+// Both are stopped when the test finishes. Simulated failures are disabled so
+// runs are deterministic. It is behind the "integration" build tag since the
+// first run downloads the Temporal CLI and so needs network access:
+//
+//	go test -tags integration ./...
+func StartTestServer(t testing.TB) (client.Client, string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	server, err := testsuite.StartDevServer(ctx, testsuite.DevServerOptions{
+		LogLevel: "warn",
+	})
+	if err != nil {
+		t.Fatalf("Unable to start Temporal dev server: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := server.Stop(); err != nil {
+			t.Logf("Unable to stop Temporal dev server: %v", err)
+		}
+	})
+
+	c := server.Client()
+	taskQueue := "order-test-" + uuid.NewString()
+
+	w := worker.New(c, taskQueue, worker.Options{})
+	RegisterOrderWorker(w, c, activities.FailureConfig{})
+	if err := w.Start(); err != nil {
+		t.Fatalf("Unable to start worker: %v", err)
+	}
+	t.Cleanup(w.Stop)
+
+	return c, taskQueue
+}
@@ -0,0 +1,50 @@
+//go:build integration
+
+package testutil_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/client"
+
+	"go-temporal-fast-course/order-processing/testutil"
+	"go-temporal-fast-course/order-processing/types"
+)
+
+func TestOrderCompletesOnDevServer(t *testing.T) {
+	c, taskQueue := testutil.StartTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	run, err := c.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:        "order-dev-server-test",
+		TaskQueue: taskQueue,
+	}, "OrderWorkflow", types.OrderInput{
+		OrderID: "ORD-DEV-1",
+		Items:   []types.LineItem{{SKU: "BOOK-001", Quantity: 1, UnitPrice: 24.99, Currency: "USD"}},
+	})
+	require.NoError(t, err)
+
+	handle, err := c.UpdateWorkflow(ctx, client.UpdateWorkflowOptions{
+		WorkflowID:   run.GetID(),
+		RunID:        run.GetRunID(),
+		UpdateName:   "update-shipping-address",
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+		Args: []interface{}{types.ShippingAddress{
+			Street: "1 Main", City: "Springfield", PostalCode: "12345", Country: "US",
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, handle.Get(ctx, nil))
+
+	err = c.SignalWorkflow(ctx, run.GetID(), run.GetRunID(), "approve-payment", types.PaymentApproval{ApprovedBy: "manager"})
+	require.NoError(t, err)
+
+	var result string
+	require.NoError(t, run.Get(ctx, &result))
+	require.Contains(t, result, "completed")
+}
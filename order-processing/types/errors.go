@@ -1,5 +1,11 @@
 package types
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 // PermanentError represents an error that should not be retried
 type PermanentError struct {
 	Msg string
@@ -9,6 +15,18 @@ func (e *PermanentError) Error() string {
 	return e.Msg
 }
 
+// InternalActivityError is returned by an activity that panicked. It is retried
+// like any other unexpected error; Stack is the goroutine stack at the panic.
+type InternalActivityError struct {
+	Activity string
+	Panic    string
+	Stack    string
+}
+
+func (e *InternalActivityError) Error() string {
+	return fmt.Sprintf("activity %s panicked: %s", e.Activity, e.Panic)
+}
+
 // PaymentTransientError represents a temporary payment error that can be retried
 type PaymentTransientError struct {
 	Msg string
@@ -26,3 +44,48 @@ type ValidationError struct {
 func (e *ValidationError) Error() string {
 	return e.Msg
 }
+
+// InsufficientInventoryError represents a business rejection when no stock is available for an order.
+// It should not be retried.
+type InsufficientInventoryError struct {
+	OrderID string
+	SKUs    []string
+}
+
+func (e *InsufficientInventoryError) Error() string {
+	return fmt.Sprintf("insufficient inventory for order %s: %s", e.OrderID, strings.Join(e.SKUs, ", "))
+}
+
+// PaymentDeclinedError represents a permanent payment decline (e.g. card declined).
+// It is returned by OrderWorkflow so callers can tell declines apart from outages.
+type PaymentDeclinedError struct {
+	OrderID string
+	Reason  string
+}
+
+func (e *PaymentDeclinedError) Error() string {
+	return fmt.Sprintf("payment declined for order %s: %s", e.OrderID, e.Reason)
+}
+
+// StageTimeoutError is returned by OrderWorkflow when a stage runs past its
+// wall-clock budget. The stage's activity is cancelled, or timed out for payment.
+type StageTimeoutError struct {
+	Stage  string
+	Budget time.Duration
+}
+
+func (e *StageTimeoutError) Error() string {
+	return fmt.Sprintf("stage %s exceeded its %s budget", e.Stage, e.Budget)
+}
+
+// WebhookDeliveryError represents a failed webhook callback (network error or
+// non-2xx response). It is retried.
+type WebhookDeliveryError struct {
+	URL        string
+	StatusCode int // 0 when no response was received
+	Msg        string
+}
+
+func (e *WebhookDeliveryError) Error() string {
+	return fmt.Sprintf("webhook %s failed: %s", e.URL, e.Msg)
+}
@@ -1,18 +1,134 @@
 package types
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
 
 // LineItem represents a product in an order
 type LineItem struct {
-	SKU      string
-	Quantity int
+	SKU       string
+	Quantity  int
+	UnitPrice float64
+	Currency  string
+}
+
+// MaxOrderQuantity caps the total number of units in a single order
+const MaxOrderQuantity = 1000
+
+// Validate checks that the line item has a SKU and a positive quantity
+func (i LineItem) Validate() error {
+	if strings.TrimSpace(i.SKU) == "" {
+		return &ValidationError{Msg: "line item SKU is required"}
+	}
+	if i.Quantity <= 0 {
+		return &ValidationError{Msg: fmt.Sprintf("line item %s: quantity must be positive, got %d", i.SKU, i.Quantity)}
+	}
+	return nil
+}
+
+// ValidateOrder rejects empty orders, checks every line item, rejects duplicate
+// SKUs and enforces MaxOrderQuantity
+func ValidateOrder(items []LineItem) error {
+	if len(items) == 0 {
+		return &ValidationError{Msg: "order has no line items"}
+	}
+	seen := make(map[string]bool, len(items))
+	total := 0
+	for _, item := range items {
+		if err := item.Validate(); err != nil {
+			return err
+		}
+		if seen[item.SKU] {
+			return &ValidationError{Msg: fmt.Sprintf("duplicate line item SKU %s", item.SKU)}
+		}
+		seen[item.SKU] = true
+		total += item.Quantity
+	}
+	if total > MaxOrderQuantity {
+		return &ValidationError{Msg: fmt.Sprintf("order quantity %d exceeds maximum of %d", total, MaxOrderQuantity)}
+	}
+	return nil
+}
+
+// OrderInput is the argument of OrderWorkflow. New order options are added as
+// fields here, so the workflow signature does not change.
+type OrderInput struct {
+	OrderID       string
+	Items         []LineItem
+	Gift          *GiftInfo      // nil for a regular order
+	PaymentMethod *PaymentMethod // nil charges the customer's default card
+	// Resume is the status carried over by continue-as-new; nil for a fresh order
+	Resume *OrderWorkflowStatus
+}
+
+// UnmarshalJSON also accepts a bare order ID string, the first of the
+// positional arguments OrderWorkflow took before OrderInput
+func (in *OrderInput) UnmarshalJSON(data []byte) error {
+	var orderID string
+	if err := json.Unmarshal(data, &orderID); err == nil {
+		*in = OrderInput{OrderID: orderID}
+		return nil
+	}
+	type plain OrderInput
+	return json.Unmarshal(data, (*plain)(in))
+}
+
+// GiftInfo marks an order as a gift. The recipient gets their own confirmation
+// with Message as a personal note.
+type GiftInfo struct {
+	RecipientEmail string
+	Message        string // optional
+}
+
+// Validate checks that the gift has a recipient email address
+func (g GiftInfo) Validate() error {
+	if err := ValidateEmail(g.RecipientEmail); err != nil {
+		return &ValidationError{Msg: "invalid gift recipient email: " + g.RecipientEmail}
+	}
+	return nil
+}
+
+// ValidateEmail checks that addr is a bare email address such as
+// "jane@example.com" (no display name)
+func ValidateEmail(addr string) error {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil || parsed.Name != "" || parsed.Address != addr {
+		return &ValidationError{Msg: "invalid email address: " + addr}
+	}
+	return nil
 }
 
 // OrderEnrichment holds enriched order data
 type OrderEnrichment struct {
 	CustomerTier    string
 	InventoryOk     bool
-	Recommendations []string
+	Recommendations []Recommendation
+	// Unavailable lists the best-effort lookups (activity names) that failed
+	Unavailable []string
+}
+
+// Recommendation is a product suggested alongside the order; a higher Score is
+// more relevant
+type Recommendation struct {
+	SKU   string
+	Score float64
+}
+
+// UnmarshalJSON also accepts a bare SKU string, the unscored format older
+// workflow histories and persisted statuses hold
+func (r *Recommendation) UnmarshalJSON(data []byte) error {
+	var sku string
+	if err := json.Unmarshal(data, &sku); err == nil {
+		*r = Recommendation{SKU: sku}
+		return nil
+	}
+	type plain Recommendation
+	return json.Unmarshal(data, (*plain)(r))
 }
 
 // OrderWorkflowStatus represents the current state of an order workflow
@@ -20,14 +136,370 @@ type OrderWorkflowStatus struct {
 	OrderID          string
 	Stage            string
 	Items            []LineItem
+	BackorderedItems []LineItem
 	Reserved         bool
+	ReservedItems    []LineItem // quantities held by ReserveStock, reduced by remove-line-item
+	Warehouse        string     // warehouse holding the reservation
 	PaymentApproved  bool
 	Charged          bool
-	Cancelled        bool
-	LastError        string
-	Enrichment       OrderEnrichment
-	ApprovalDeadline time.Time
-	Version          string
+	PaymentReceipt   *PaymentReceipt // set once ProcessPayment succeeds
+	TrackingNumber   string
+	ShippingAddress  ShippingAddress
+	TaxAmount        float64
+	ShippingCost     float64
+	PromoCode        string
+	DiscountAmount   float64
+	Gift             *GiftInfo // nil unless the order is a gift
+	PaymentMethod    string    // masked, e.g. "card ****4242"; empty for the default card on file
+	CustomerEmail    string    // resolved by FetchCustomerEmail before the confirmation
+	// NotificationSkipped is set when the customer opted out of email and no
+	// confirmation was sent
+	NotificationSkipped bool
+	// Amount charged before and after conversion into the settlement currency
+	OriginalAmount     float64
+	OriginalCurrency   string
+	SettlementAmount   float64
+	SettlementCurrency string
+	History            []StageTransition
+	Signals            map[string]SignalSummary // keyed by signal name
+	Cancelled          bool
+	LastError          string
+	LastFailure        *FailureDetail
+	CompensationsRun   []string           // activity names of the compensations that completed, in order
+	CancellationReason CancellationReason // set with Cancelled; LastError has the text
+	HandedOffTo        string             // ReprocessOrderWorkflow ID that took over this failed order
+	Enrichment         OrderEnrichment
+	ApprovalDeadline   time.Time
+	ApprovalPolicy     ApprovalPolicy
+	Approvers          []string // distinct ApprovedBy values received so far
+	PauseReason        string
+	Paused             bool      // held by pause-order at the next stage boundary until resume-order
+	PausedAt           time.Time // start of the current pause, zero while not paused
+	Version            string
+	ActivitiesExecuted int // activities scheduled so far, carried across continue-as-new
+}
+
+// UserPreferences holds how a customer wants to be contacted. Channel is
+// "email" or "sms" and selects which contact detail is used.
+type UserPreferences struct {
+	Channel string
+	Email   string
+	Phone   string
+}
+
+// NotificationPrefs is what the customer agreed to receive, as returned by
+// FetchNotificationPrefs
+type NotificationPrefs struct {
+	EmailOptIn bool // order confirmations; cancellation notices are always sent
+}
+
+// SignalSummary counts deliveries of one signal, for the get-signals-summary query
+type SignalSummary struct {
+	Count        int
+	LastReceived time.Time
+}
+
+// ReservationResult is returned by ReserveStock. A failed reservation is rolled
+// back entirely, so a result is only returned when every SKU was reserved.
+type ReservationResult struct {
+	OrderID      string
+	ReservedSKUs []string
+}
+
+// FailureDetail describes the error an order workflow failed with, returned by the get-failure query
+type FailureDetail struct {
+	Type      string // error type, e.g. "PaymentDeclinedError" or "InsufficientInventoryError"
+	Message   string
+	Stage     string // stage the workflow was in when it failed
+	Timestamp time.Time
+}
+
+// StageTransition records when an order workflow entered a stage
+type StageTransition struct {
+	Stage      string
+	EnteredAt  time.Time
+	Activities []ActivityRun // activities that finished while in this stage
+}
+
+// ActivityRun records one activity execution in the stage history
+type ActivityRun struct {
+	Name      string
+	StartedAt time.Time
+	EndedAt   time.Time
+	Error     string `json:",omitempty"`
+}
+
+// PaymentRequest is the input for charging an order. IdempotencyKey is generated
+// once per workflow so activity retries never charge the same order twice.
+// Amount is the grand total in Currency (the settlement currency).
+type PaymentRequest struct {
+	OrderID        string
+	IdempotencyKey string
+	Amount         float64
+	Currency       string
+	Method         *PaymentMethod // nil charges the customer's default card on file
+}
+
+// PaymentMethodType is the kind of payment instrument
+type PaymentMethodType string
+
+const (
+	PaymentMethodCard   PaymentMethodType = "card"
+	PaymentMethodWallet PaymentMethodType = "wallet"
+	PaymentMethodBank   PaymentMethodType = "bank"
+)
+
+// PaymentMethod is the payment instrument chosen at checkout. Token is the
+// gateway's token for it; keep it out of logs and the status, use Masked.
+type PaymentMethod struct {
+	Type  PaymentMethodType
+	Token string
+}
+
+// Validate checks that the method type is supported and a token is present
+func (m PaymentMethod) Validate() error {
+	switch m.Type {
+	case PaymentMethodCard, PaymentMethodWallet, PaymentMethodBank:
+	default:
+		return &ValidationError{Msg: fmt.Sprintf("unsupported payment method type %q", m.Type)}
+	}
+	if strings.TrimSpace(m.Token) == "" {
+		return &ValidationError{Msg: fmt.Sprintf("%s payment method has no token", m.Type)}
+	}
+	return nil
+}
+
+// Masked returns the method type with all but the last 4 token characters
+// hidden, e.g. "card ****4242"
+func (m PaymentMethod) Masked() string {
+	last := m.Token
+	if len(last) > 4 {
+		last = last[len(last)-4:]
+	}
+	return fmt.Sprintf("%s ****%s", m.Type, last)
+}
+
+// PaymentReceipt is returned by ProcessPayment. TransactionID identifies the
+// charge at the gateway and is what RefundPayment refunds.
+type PaymentReceipt struct {
+	TransactionID string
+	Amount        float64
+	ChargedAt     time.Time
+}
+
+// Total returns the order value (quantity × unit price) of the items being fulfilled.
+// Backordered items are not included since they are not charged yet.
+func (s OrderWorkflowStatus) Total() float64 {
+	total := 0.0
+	for _, item := range s.Items {
+		total += float64(item.Quantity) * item.UnitPrice
+	}
+	return total
+}
+
+// GrandTotal returns the amount to charge: the item total minus discount, plus
+// tax and shipping
+func (s OrderWorkflowStatus) GrandTotal() float64 {
+	return s.Total() - s.DiscountAmount + s.TaxAmount + s.ShippingCost
+}
+
+// TransactionID returns the payment transaction ID, or "" if not charged yet
+func (s OrderWorkflowStatus) TransactionID() string {
+	if s.PaymentReceipt == nil {
+		return ""
+	}
+	return s.PaymentReceipt.TransactionID
+}
+
+// RoleAdmin is the get-status caller role that sees the full status
+const RoleAdmin = "admin"
+
+// RedactedFor returns the status as a get-status caller with the given role
+// may see it. Queries can't read the caller's gRPC metadata, so the role comes
+// in as a query argument. Any role but RoleAdmin, including none, gets the
+// status without the customer and gift recipient emails or the payment receipt.
+func (s OrderWorkflowStatus) RedactedFor(role string) OrderWorkflowStatus {
+	if role == RoleAdmin {
+		return s
+	}
+	s.CustomerEmail = ""
+	s.PaymentReceipt = nil
+	if s.Gift != nil {
+		gift := *s.Gift
+		gift.RecipientEmail = ""
+		s.Gift = &gift
+	}
+	return s
+}
+
+// IsResumable reports whether the order failed in a state a retry can recover
+// from: stock was reserved but not charged, or payment was charged but the
+// order was not confirmed. Running, completed and cancelled orders, declines
+// and failures before the reservation are not resumable.
+func (s OrderWorkflowStatus) IsResumable() bool {
+	if s.LastFailure == nil || s.Cancelled || s.LastFailure.Type == "PaymentDeclinedError" {
+		return false
+	}
+	switch s.Stage {
+	case "notify", "completed", "cancelled":
+		return false
+	}
+	return s.Reserved || s.Charged
+}
+
+// StatusDTO is the stable external view of an order returned by the
+// "get-status-dto" query. Its field names are a wire contract: add fields
+// rather than renaming them, so OrderWorkflowStatus can change freely.
+//
+// Derived fields (not stored on OrderWorkflowStatus):
+//   - Subtotal: Total()
+//   - TotalAmount: GrandTotal()
+//   - Currency: currency the line items are priced in (default USD)
+//   - IsTerminal: Stage is "completed" or "cancelled"
+type StatusDTO struct {
+	OrderID            string             `json:"orderId"`
+	Stage              string             `json:"stage"`
+	Items              []LineItem         `json:"items"`
+	BackorderedItems   []LineItem         `json:"backorderedItems"`
+	PaymentApproved    bool               `json:"paymentApproved"`
+	Charged            bool               `json:"charged"`
+	TransactionID      string             `json:"transactionId,omitempty"`
+	Cancelled          bool               `json:"cancelled"`
+	CancellationReason CancellationReason `json:"cancellationReason,omitempty"`
+	TrackingNumber     string             `json:"trackingNumber,omitempty"`
+	ShippingAddress    ShippingAddress    `json:"shippingAddress"`
+	PromoCode          string             `json:"promoCode,omitempty"`
+	Subtotal           float64            `json:"subtotal"`
+	DiscountAmount     float64            `json:"discountAmount"`
+	TaxAmount          float64            `json:"taxAmount"`
+	ShippingCost       float64            `json:"shippingCost"`
+	TotalAmount        float64            `json:"totalAmount"`
+	Currency           string             `json:"currency"`
+	ApprovalDeadline   time.Time          `json:"approvalDeadline"`
+	LastError          string             `json:"lastError,omitempty"`
+	IsTerminal         bool               `json:"isTerminal"`
+	Paused             bool               `json:"paused"`
+}
+
+// itemsCurrency is the currency the line items are priced in (default USD)
+func itemsCurrency(items []LineItem) string {
+	for _, item := range items {
+		if item.Currency != "" {
+			return item.Currency
+		}
+	}
+	return "USD"
+}
+
+// DTO maps the status into its external representation
+func (s OrderWorkflowStatus) DTO() StatusDTO {
+	currency := itemsCurrency(s.Items)
+	return StatusDTO{
+		OrderID:            s.OrderID,
+		Stage:              s.Stage,
+		Items:              s.Items,
+		BackorderedItems:   s.BackorderedItems,
+		PaymentApproved:    s.PaymentApproved,
+		Charged:            s.Charged,
+		TransactionID:      s.TransactionID(),
+		Cancelled:          s.Cancelled,
+		CancellationReason: s.CancellationReason,
+		TrackingNumber:     s.TrackingNumber,
+		ShippingAddress:    s.ShippingAddress,
+		PromoCode:          s.PromoCode,
+		Subtotal:           s.Total(),
+		DiscountAmount:     s.DiscountAmount,
+		TaxAmount:          s.TaxAmount,
+		ShippingCost:       s.ShippingCost,
+		TotalAmount:        s.GrandTotal(),
+		Currency:           currency,
+		ApprovalDeadline:   s.ApprovalDeadline,
+		LastError:          s.LastError,
+		IsTerminal:         s.Stage == "completed" || s.Stage == "cancelled",
+		Paused:             s.Paused,
+	}
+}
+
+// OrderTotals is the itemized cart returned by the "get-totals" query, in the
+// currency the items are priced in. Components not computed yet are zero, e.g.
+// Tax and Shipping until a shipping address is set.
+type OrderTotals struct {
+	Subtotal   float64
+	Discount   float64
+	Tax        float64
+	Shipping   float64
+	GrandTotal float64
+	Currency   string
+}
+
+// Totals breaks the grand total down from the current status fields
+func (s OrderWorkflowStatus) Totals() OrderTotals {
+	return OrderTotals{
+		Subtotal:   s.Total(),
+		Discount:   s.DiscountAmount,
+		Tax:        s.TaxAmount,
+		Shipping:   s.ShippingCost,
+		GrandTotal: s.GrandTotal(),
+		Currency:   itemsCurrency(s.Items),
+	}
+}
+
+// ApprovalPolicy decides how many distinct approvers an order needs. Orders
+// whose grand total reaches HighValueThreshold need HighValueApprovals; all
+// others need one. A zero threshold disables the rule.
+type ApprovalPolicy struct {
+	HighValueThreshold float64
+	HighValueApprovals int
+}
+
+// RequiredApprovals returns how many distinct approvers the order needs
+func (s OrderWorkflowStatus) RequiredApprovals() int {
+	p := s.ApprovalPolicy
+	if p.HighValueThreshold > 0 && p.HighValueApprovals > 1 && s.GrandTotal() >= p.HighValueThreshold {
+		return p.HighValueApprovals
+	}
+	return 1
+}
+
+// ApprovalStatus is returned by the get-approvals query
+type ApprovalStatus struct {
+	Approvers []string
+	Required  int
+	Remaining int // approvals still needed, 0 once payment is approved
+}
+
+// WorkflowMeta is returned by the get-meta query, a quick health snapshot of
+// the workflow
+type WorkflowMeta struct {
+	Version            string
+	StartTime          time.Time // start of the current run; continue-as-new starts a new one
+	ActivitiesExecuted int       // activities and local activities scheduled, across all runs
+}
+
+// OrderEventType names an order lifecycle event published to the event broker
+type OrderEventType string
+
+const (
+	EventOrderStarted   OrderEventType = "OrderStarted"
+	EventStockReserved  OrderEventType = "StockReserved"
+	EventPaymentCharged OrderEventType = "PaymentCharged"
+	EventOrderCompleted OrderEventType = "OrderCompleted"
+	EventOrderCancelled OrderEventType = "OrderCancelled"
+)
+
+// OrderEvent is published by EventActivities.Publish at each major transition
+// of an order. Like StatusDTO its JSON field names are a wire contract for
+// downstream consumers; fields that do not apply to an event type are omitted.
+type OrderEvent struct {
+	Type               OrderEventType     `json:"type"`
+	OrderID            string             `json:"orderId"`
+	OccurredAt         time.Time          `json:"occurredAt"` // workflow.Now at the transition
+	Items              []LineItem         `json:"items,omitempty"`
+	Amount             float64            `json:"amount,omitempty"`
+	Currency           string             `json:"currency,omitempty"`
+	TransactionID      string             `json:"transactionId,omitempty"`
+	TrackingNumber     string             `json:"trackingNumber,omitempty"`
+	CancellationReason CancellationReason `json:"cancellationReason,omitempty"`
 }
 
 // PaymentApproval is the signal payload for approving payment
@@ -36,7 +508,138 @@ type PaymentApproval struct {
 	Timestamp  time.Time
 }
 
+// PauseRequest is the signal payload for holding an order for investigation
+type PauseRequest struct {
+	By     string
+	Reason string
+}
+
+// PromoCode is the signal payload for applying a discount code
+type PromoCode struct {
+	Code string
+}
+
+// CancellationReason classifies why an order was cancelled, for reporting
+type CancellationReason string
+
+const (
+	ReasonCustomerRequested CancellationReason = "customer-requested"
+	ReasonFraudDetected     CancellationReason = "fraud-detected"
+	ReasonTimeout           CancellationReason = "timeout"
+	ReasonOutOfStock        CancellationReason = "out-of-stock"
+	ReasonPaymentFailed     CancellationReason = "payment-failed"
+	// ReasonAmountExceedsLimit is only set by the workflow, when the payment
+	// was refused for exceeding the max-charge limit; it is not a valid
+	// cancel-order reason
+	ReasonAmountExceedsLimit CancellationReason = "amount-exceeds-limit"
+)
+
+// Valid reports whether r is one of the reasons a cancel-order signal may carry
+func (r CancellationReason) Valid() bool {
+	switch r {
+	case ReasonCustomerRequested, ReasonFraudDetected, ReasonTimeout, ReasonOutOfStock, ReasonPaymentFailed:
+		return true
+	}
+	return false
+}
+
+// String returns the human-readable reason shown in the UI and emails
+func (r CancellationReason) String() string {
+	switch r {
+	case ReasonCustomerRequested:
+		return "customer requested"
+	case ReasonFraudDetected:
+		return "fraud detected"
+	case ReasonTimeout:
+		return "approval timeout"
+	case ReasonOutOfStock:
+		return "out of stock"
+	case ReasonPaymentFailed:
+		return "payment failed"
+	case ReasonAmountExceedsLimit:
+		return "amount exceeds limit"
+	}
+	return string(r)
+}
+
 // CancelRequest is the signal payload for cancelling an order
 type CancelRequest struct {
-	Reason string
+	Reason CancellationReason
+	// Note is optional free text, e.g. what the customer said
+	Note string
+	// Force allows cancelling after payment was charged; the charge is refunded
+	Force bool
+}
+
+// Normalize maps a free-text Reason from older clients to
+// ReasonCustomerRequested, keeping the text as the Note
+func (r CancelRequest) Normalize() CancelRequest {
+	if !r.Reason.Valid() {
+		if r.Note == "" {
+			r.Note = string(r.Reason)
+		}
+		r.Reason = ReasonCustomerRequested
+	}
+	return r
+}
+
+// Describe returns the reason and note for emails and the workflow result
+func (r CancelRequest) Describe() string {
+	if r.Note == "" {
+		return r.Reason.String()
+	}
+	return r.Reason.String() + ": " + r.Note
+}
+
+// LockRequest is the signal payload asking an InventoryLockWorkflow for its
+// SKU's lock on behalf of an order workflow
+type LockRequest struct {
+	SKU        string
+	WorkflowID string // the requesting workflow, signalled once the lock is granted
+	RunID      string
+	// Lease is how long the holder may keep the lock before it is taken back,
+	// so a crashed or terminated order cannot block the SKU forever
+	Lease time.Duration
+}
+
+// LockGrant is the signal payload telling an order it holds the SKU's lock
+type LockGrant struct {
+	SKU string
+}
+
+// LockRelease is the signal payload returning a SKU's lock. It only releases
+// the lock while the sender still holds it.
+type LockRelease struct {
+	SKU        string
+	WorkflowID string
+	RunID      string
+}
+
+// LockState is returned by the InventoryLockWorkflow "get-lock-state" query
+type LockState struct {
+	SKU     string
+	Holder  *LockRequest // nil while the lock is free
+	Waiting []LockRequest
+}
+
+// ShippingAddress is the destination an order is shipped to
+type ShippingAddress struct {
+	Street     string
+	City       string
+	PostalCode string
+	Country    string
+}
+
+// postalCodePattern accepts 3-10 alphanumeric characters with optional inner spaces or dashes
+var postalCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]{1,8}[A-Za-z0-9]$`)
+
+// Validate checks that the address has a street and a well-formed postal code
+func (a ShippingAddress) Validate() error {
+	if strings.TrimSpace(a.Street) == "" {
+		return &ValidationError{Msg: "street is required"}
+	}
+	if !postalCodePattern.MatchString(strings.TrimSpace(a.PostalCode)) {
+		return &ValidationError{Msg: "invalid postal code: " + a.PostalCode}
+	}
+	return nil
 }
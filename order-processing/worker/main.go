@@ -1,21 +1,69 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	_ "github.com/lib/pq"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/worker"
 
 	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/types"
 	"go-temporal-fast-course/order-processing/workflows"
+	"go-temporal-fast-course/shared/encryption"
+	"go-temporal-fast-course/shared/health"
+	"go-temporal-fast-course/shared/interceptors"
+	"go-temporal-fast-course/shared/logging"
+	"go-temporal-fast-course/shared/metrics"
+	"go-temporal-fast-course/shared/retry"
 )
 
 func main() {
+	// Prometheus metrics for SDK and custom counters
+	metricsHandler, metricsHTTPHandler, metricsCloser := metrics.NewPrometheusHandler()
+	defer metricsCloser.Close()
+
+	// The metrics server also answers /healthz and /readyz for orchestrators
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHTTPHandler)
+	health.Register(mux)
+	metricsServer := &http.Server{
+		Addr:    ":" + getEnv("METRICS_PORT", "9090"),
+		Handler: mux,
+	}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalln("Unable to start metrics server", err)
+		}
+	}()
+
+	// Payloads are encrypted when PAYLOAD_ENCRYPTION_KEY is set
+	dataConverter, err := encryption.DataConverterFromEnv()
+	if err != nil {
+		log.Fatalln("Unable to configure payload encryption", err)
+	}
+
+	// LOG_FORMAT=json writes one JSON object per line instead of key=value text
+	logger, err := logging.NewCorrelatedLoggerFromEnv()
+	if err != nil {
+		log.Fatalln("Unable to configure logging", err)
+	}
+
 	// Create Temporal client
-	c, err := client.Dial(client.Options{
-		HostPort: getEnv("TEMPORAL_HOST", "localhost:7233"),
-	})
+	c, err := retry.DialWithRetry(client.Options{
+		HostPort:       getEnv("TEMPORAL_HOST", "localhost:7233"),
+		DataConverter:  dataConverter,
+		MetricsHandler: metricsHandler,
+		// Prefixes every workflow/activity log line with orderID and workflowID
+		Logger: logger,
+	}, retry.DialAttempts, retry.DialBackoff)
 	if err != nil {
 		log.Fatalln("Unable to create Temporal client", err)
 	}
@@ -24,50 +72,192 @@ func main() {
 	// Get task queue name from environment
 	taskQueue := getEnv("ORDER_TASK_QUEUE", "order-task-queue")
 
+	// Payment activities can run on their own queue; PAYMENT_WORKER=false leaves
+	// that queue to a dedicated payment deployment
+	paymentTaskQueue := getEnv("PAYMENT_TASK_QUEUE", taskQueue)
+	runPaymentWorker := getEnv("PAYMENT_WORKER", "true") != "false"
+	if paymentTaskQueue != taskQueue {
+		workflows.PaymentTaskQueue = paymentTaskQueue
+	}
+
+	// Currency orders are charged in
+	workflows.SettlementCurrency = getEnv("SETTLEMENT_CURRENCY", workflows.SettlementCurrency)
+
+	// Orders at or above the threshold need several distinct approvers (0 = disabled)
+	workflows.HighValueApprovalPolicy = types.ApprovalPolicy{
+		HighValueThreshold: getEnvFloat("HIGH_VALUE_THRESHOLD", 0),
+		HighValueApprovals: getEnvInt("HIGH_VALUE_APPROVALS", 2),
+	}
+
+	// Wall-clock budgets for the reserve, payment and status-update stages (0 = unbounded)
+	workflows.OrderStageBudgets = workflows.StageBudgets{
+		Reserve:      getEnvDuration("RESERVE_STAGE_BUDGET", workflows.OrderStageBudgets.Reserve),
+		Payment:      getEnvDuration("PAYMENT_STAGE_BUDGET", workflows.OrderStageBudgets.Payment),
+		StatusUpdate: getEnvDuration("STATUS_UPDATE_STAGE_BUDGET", workflows.OrderStageBudgets.StatusUpdate),
+	}
+
+	// Serialize reservations per SKU through InventoryLockWorkflow
+	workflows.SerializeReservations = getEnv("SERIALIZE_RESERVATIONS", "false") == "true"
+
+	// Callback for external systems when an order completes
+	workflows.CompletionWebhookURL = os.Getenv("COMPLETION_WEBHOOK_URL")
+
+	// Topic order lifecycle events are published to
+	workflows.EventTopic = getEnv("EVENT_TOPIC", workflows.EventTopic)
+
+	// Activity retry attempts are tunable per deployment
+	retry.MaxAttempts = int32(getEnvInt("RETRY_MAX_ATTEMPTS", int(retry.MaxAttempts)))
+
+	// Concurrency limits are tunable per deployment
+	maxConcurrentActivities := getEnvInt("MAX_CONCURRENT_ACTIVITIES", 100)
+	maxConcurrentWorkflowTasks := getEnvInt("MAX_CONCURRENT_WORKFLOW_TASKS", 50)
+
+	// Rate limits: whole task queue (0 = unlimited) and payment gateway calls per worker
+	taskQueueActivitiesPerSecond := getEnvFloat("TASK_QUEUE_ACTIVITIES_PER_SECOND", 0)
+	paymentRatePerSec := getEnvFloat("PAYMENT_RATE_PER_SEC", 10)
+	paymentMaxCharge := getEnvFloat("PAYMENT_MAX_CHARGE", 0)
+
+	// Shared by all ProcessPayment executions on this worker; a zero cooldown disables it
+	paymentBreakerThreshold := getEnvInt("PAYMENT_BREAKER_THRESHOLD", 5)
+	paymentBreakerCooldown := getEnvDuration("PAYMENT_BREAKER_COOLDOWN", 30*time.Second)
+	var paymentBreaker *activities.CircuitBreaker
+	if paymentBreakerCooldown > 0 {
+		paymentBreaker = activities.NewCircuitBreaker(paymentBreakerThreshold, paymentBreakerCooldown)
+	}
+
+	// Simulated failure rates, e.g. PAYMENT_DECLINE_RATE=1 to demo the decline path
+	failures := activities.DefaultFailureConfig()
+	failures.InventoryErrorRate = getEnvRate("INVENTORY_ERROR_RATE", failures.InventoryErrorRate)
+	failures.PaymentTimeoutRate = getEnvRate("PAYMENT_TIMEOUT_RATE", failures.PaymentTimeoutRate)
+	failures.PaymentDeclineRate = getEnvRate("PAYMENT_DECLINE_RATE", failures.PaymentDeclineRate)
+	failures.StatusUpdateErrorRate = getEnvRate("STATUS_UPDATE_ERROR_RATE", failures.StatusUpdateErrorRate)
+	failures.ShipmentErrorRate = getEnvRate("SHIPMENT_ERROR_RATE", failures.ShipmentErrorRate)
+	failures.EmailErrorRate = getEnvRate("EMAIL_ERROR_RATE", failures.EmailErrorRate)
+	failures.SMSErrorRate = getEnvRate("SMS_ERROR_RATE", failures.SMSErrorRate)
+
 	// Create worker with options
 	w := worker.New(c, taskQueue, worker.Options{
 		Identity:                               "order-worker-" + hostname(),
-		MaxConcurrentActivityExecutionSize:     100,
-		MaxConcurrentWorkflowTaskExecutionSize: 50,
+		MaxConcurrentActivityExecutionSize:     maxConcurrentActivities,
+		MaxConcurrentWorkflowTaskExecutionSize: maxConcurrentWorkflowTasks,
+		TaskQueueActivitiesPerSecond:           taskQueueActivitiesPerSecond,
+		Interceptors: []interceptor.WorkerInterceptor{
+			interceptors.NewActivityTimingInterceptor(),
+		},
 	})
 
-	// Register workflows
-	w.RegisterWorkflow(workflows.OrderWorkflow)
-
-	// Register activities
-	// Inventory activities
-	inventoryActivities := &activities.InventoryActivities{}
-	w.RegisterActivity(inventoryActivities.ReserveStock)
-	w.RegisterActivity(inventoryActivities.ReleaseStock)
-	w.RegisterActivity(inventoryActivities.FetchInventorySnapshot)
-
-	// Payment activities
-	paymentActivities := &activities.PaymentActivities{}
-	w.RegisterActivity(paymentActivities.ProcessPayment)
-	w.RegisterActivity(paymentActivities.RefundPayment)
+	// Activities, configured for this deployment. Stock is kept in memory per
+	// worker process, seeded with the demo SKUs in both warehouses.
+	acts := activities.Set{
+		Inventory: activities.NewInventoryActivities(activities.DemoWarehouses(), failures),
+		// Lock activities, for SERIALIZE_RESERVATIONS
+		Lock:           &activities.LockActivities{Client: c},
+		Customer:       &activities.CustomerActivities{},
+		Recommendation: &activities.RecommendationActivities{},
+		Shipping:       &activities.ShippingActivities{Failures: failures},
+		Tax:            &activities.TaxActivities{},
+		Promo:          &activities.PromoActivities{},
+		Currency:       &activities.CurrencyActivities{},
+		Order:          &activities.OrderActivities{Failures: failures},
+		Notification:   activities.NewNotificationActivities(failures),
+		Webhook:        &activities.WebhookActivities{},
+		Event:          &activities.EventActivities{Broker: activities.NoopBroker{}},
+	}
 
-	// Customer activities
-	customerActivities := &activities.CustomerActivities{}
-	w.RegisterActivity(customerActivities.FetchCustomerProfile)
+	// Payment activities, on the main worker or a second one polling the payment queue
+	paymentActivities := activities.NewPaymentActivities(paymentRatePerSec, paymentBreaker, failures)
+	paymentActivities.MaxCharge = paymentMaxCharge
+	if workflows.PaymentTaskQueue == "" {
+		acts.Payment = paymentActivities
+	} else if runPaymentWorker {
+		pw := worker.New(c, paymentTaskQueue, worker.Options{
+			Identity:                           "payment-worker-" + hostname(),
+			MaxConcurrentActivityExecutionSize: maxConcurrentActivities,
+			Interceptors: []interceptor.WorkerInterceptor{
+				interceptors.NewActivityTimingInterceptor(),
+			},
+		})
+		activities.Set{Payment: paymentActivities}.Register(pw)
+		if err := pw.Start(); err != nil {
+			log.Fatalln("Unable to start payment worker", err)
+		}
+		defer pw.Stop()
+	}
 
-	// Recommendation activities
-	recommendationActivities := &activities.RecommendationActivities{}
-	w.RegisterActivity(recommendationActivities.FetchRecommendations)
+	// Status snapshots are written to DB_DSN when set; otherwise PersistStatus is a no-op
+	dbDriver := getEnv("DB_DRIVER", "postgres")
+	if dsn := os.Getenv("DB_DSN"); dsn != "" {
+		db, err := sql.Open(dbDriver, dsn)
+		if err != nil {
+			log.Fatalln("Unable to open status database", err)
+		}
+		defer db.Close()
+		acts.Order.DB = db
+	}
 
-	// Order activities
-	orderActivities := &activities.OrderActivities{}
-	w.RegisterActivity(orderActivities.UpdateOrderStatus)
+	// Lifecycle events go to NATS with EVENT_BROKER=nats; by default they are discarded
+	eventBroker := getEnv("EVENT_BROKER", "none")
+	switch eventBroker {
+	case "nats":
+		acts.Event.Broker = &activities.NATSBroker{Addr: getEnv("NATS_ADDR", "localhost:4222")}
+	case "none":
+	default:
+		log.Fatalf("Invalid EVENT_BROKER=%q: must be nats or none", eventBroker)
+	}
 
-	// Notification activities
-	notificationActivities := &activities.NotificationActivities{}
-	w.RegisterActivity(notificationActivities.SendOrderConfirmation)
-	w.RegisterActivity(notificationActivities.SendCancellationEmail)
+	// Register workflows and activities
+	workflows.Register(w, acts)
 
 	log.Println("Worker starting on task queue:", taskQueue)
 	log.Println("Worker identity:", "order-worker-"+hostname())
+	log.Println("Metrics endpoint:", "http://localhost"+metricsServer.Addr+"/metrics")
+	log.Println("Health endpoints:", "http://localhost"+metricsServer.Addr+"/healthz", "/readyz")
+	log.Println("Settlement currency:", workflows.SettlementCurrency)
+	if p := workflows.HighValueApprovalPolicy; p.HighValueThreshold > 0 {
+		log.Printf("Orders of %.2f or more need %d approvers\n", p.HighValueThreshold, p.HighValueApprovals)
+	}
+	if workflows.CompletionWebhookURL != "" {
+		log.Println("Completion webhook:", workflows.CompletionWebhookURL)
+	}
+	if workflows.SerializeReservations {
+		log.Println("Serializing reservations per SKU with InventoryLockWorkflow")
+	}
+	if eventBroker != "none" {
+		log.Printf("Publishing order events to %s topic %s\n", eventBroker, workflows.EventTopic)
+	}
+	log.Printf("Stage budgets: reserve %s, payment %s, status-update %s\n",
+		workflows.OrderStageBudgets.Reserve, workflows.OrderStageBudgets.Payment, workflows.OrderStageBudgets.StatusUpdate)
+	if acts.Order.DB != nil {
+		log.Println("Persisting order status via driver:", dbDriver)
+	}
+	log.Println("Retry max attempts:", retry.MaxAttempts)
+	log.Println("Max concurrent activities:", maxConcurrentActivities)
+	log.Println("Max concurrent workflow tasks:", maxConcurrentWorkflowTasks)
+	log.Println("Task queue activities per second:", taskQueueActivitiesPerSecond)
+	log.Println("Payment gateway rate per second:", paymentRatePerSec)
+	if paymentMaxCharge > 0 {
+		log.Println("Payment max charge:", paymentMaxCharge)
+	}
+	if paymentBreaker != nil {
+		log.Printf("Payment circuit breaker: opens after %d gateway timeouts for %s\n", paymentBreakerThreshold, paymentBreakerCooldown)
+	}
+	if workflows.PaymentTaskQueue != "" {
+		log.Println("Payment task queue:", paymentTaskQueue)
+		if !runPaymentWorker {
+			log.Println("Payment activities are served by a separate payment worker")
+		}
+	}
+
+	// Start worker; SIGTERM flips /readyz to 503 before the worker stops
+	err = w.Run(health.InterruptCh())
+
+	// Stop serving metrics once the worker has shut down
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if shutdownErr := metricsServer.Shutdown(shutdownCtx); shutdownErr != nil {
+		log.Println("Metrics server shutdown failed:", shutdownErr)
+	}
 
-	// Start worker
-	err = w.Run(worker.InterruptCh())
 	if err != nil {
 		log.Fatalln("Unable to start worker", err)
 	}
@@ -88,3 +278,51 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvInt reads a positive integer from the environment, exiting on invalid values
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid %s=%q: must be a positive integer", key, value)
+	}
+	return n
+}
+
+// getEnvFloat reads a non-negative number from the environment, exiting on invalid values
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		log.Fatalf("Invalid %s=%q: must be a non-negative number", key, value)
+	}
+	return f
+}
+
+// getEnvRate reads a probability between 0 and 1 from the environment
+func getEnvRate(key string, defaultValue float64) float64 {
+	rate := getEnvFloat(key, defaultValue)
+	if rate > 1 {
+		log.Fatalf("Invalid %s=%v: must be between 0 and 1", key, rate)
+	}
+	return rate
+}
+
+// getEnvDuration reads a non-negative duration such as "90s" from the environment
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Fatalf("Invalid %s=%q: must be a non-negative duration", key, value)
+	}
+	return d
+}
@@ -0,0 +1,67 @@
+package workflows
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// ActivityProfile names the activity options for a category of activity, so
+// each call site states what kind of work it schedules instead of inheriting
+// one set of timeouts for everything
+type ActivityProfile string
+
+const (
+	// ProfileFastReadOnly is for cheap lookups without side effects (inventory
+	// snapshot, recommendations, tax, promo, currency). A slow lookup is
+	// better failed fast than waited on: 5s per attempt, at most 3 attempts.
+	ProfileFastReadOnly ActivityProfile = "fast-readonly"
+
+	// ProfileExternalIO is for best-effort calls to other systems
+	// (notifications, webhooks, events, status snapshots): 30s per attempt and
+	// the shared retry policy.
+	ProfileExternalIO ActivityProfile = "external-io"
+
+	// ProfileCriticalWrite is for state changes the order depends on (stock,
+	// payment, order status, compensations): 1m per attempt and at least 10
+	// attempts with backoff up to 1m, since giving up leaves the order
+	// inconsistent.
+	ProfileCriticalWrite ActivityProfile = "critical-write"
+)
+
+// activityProfile returns the options of profile. Unknown profiles get
+// ProfileExternalIO. Timeouts and retry policies are not part of the replay
+// determinism check, so tuning them only affects activities scheduled after
+// the deployment.
+func activityProfile(profile ActivityProfile) workflow.ActivityOptions {
+	policy := defaultRetryPolicy()
+	switch profile {
+	case ProfileFastReadOnly:
+		policy.MaximumAttempts = min(policy.MaximumAttempts, 3)
+		policy.MaximumInterval = 5 * time.Second
+		return workflow.ActivityOptions{StartToCloseTimeout: 5 * time.Second, RetryPolicy: policy}
+	case ProfileCriticalWrite:
+		policy.MaximumAttempts = max(policy.MaximumAttempts, 10)
+		policy.MaximumInterval = 1 * time.Minute
+		return workflow.ActivityOptions{StartToCloseTimeout: 1 * time.Minute, RetryPolicy: policy}
+	default:
+		return workflow.ActivityOptions{StartToCloseTimeout: 30 * time.Second, RetryPolicy: policy}
+	}
+}
+
+// withProfile returns ctx with the activity options of profile. The task
+// queue already set on ctx is kept (see withPaymentTaskQueue). Activities that
+// heartbeat add their heartbeat timeout on top with
+// workflow.WithHeartbeatTimeout.
+func withProfile(ctx workflow.Context, profile ActivityProfile) workflow.Context {
+	return workflow.WithActivityOptions(ctx, activityProfile(profile))
+}
+
+// withPaymentTaskQueue routes activities to PaymentTaskQueue. Unlike
+// workflow.WithTaskQueue, an empty PaymentTaskQueue keeps the workflow's own
+// task queue instead of failing with "missing task queue name".
+func withPaymentTaskQueue(ctx workflow.Context) workflow.Context {
+	options := workflow.GetActivityOptions(ctx)
+	options.TaskQueue = PaymentTaskQueue
+	return workflow.WithActivityOptions(ctx, options)
+}
@@ -0,0 +1,56 @@
+package workflows
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// needsEnrichment reports whether the order has yet to go through the
+// enrichment stage. A status carried over by continue-as-new has been through
+// it: its Enrichment already holds the customer tier and recommendations, so
+// FetchCustomerProfile and FetchRecommendations must not run again.
+func needsEnrichment(status types.OrderWorkflowStatus) bool {
+	for _, transition := range status.History {
+		if transition.Stage == "enrichment" {
+			return false
+		}
+	}
+	return true
+}
+
+// enrichment is one lookup of the parallel enrichment stage, already scheduled
+// with scheduleActivity or scheduleLocalActivity
+type enrichment struct {
+	name     string // activity name, for the stage history
+	future   workflow.Future
+	valuePtr interface{}
+	// bestEffort lookups that fail leave valuePtr at its zero value instead of
+	// failing the order
+	bestEffort bool
+}
+
+// awaitEnrichments waits for every enrichment and decodes its result. The
+// lookups run concurrently; waiting on them in scheduling order keeps the
+// commands of in-flight orders where their histories have them. The first
+// critical failure is returned. Best-effort failures are logged and listed in
+// status.Enrichment.Unavailable; the version allowing them is only taken on
+// failure, so orders that failed on one before still replay.
+func awaitEnrichments(ctx workflow.Context, status *types.OrderWorkflowStatus, startedAt time.Time, enrichments []enrichment) error {
+	logger := workflow.GetLogger(ctx)
+	for _, e := range enrichments {
+		err := e.future.Get(ctx, e.valuePtr)
+		recordActivityRun(ctx, status, e.name, startedAt, err)
+		if err == nil {
+			continue
+		}
+		if !e.bestEffort || workflow.GetVersion(ctx, "best-effort-enrichment", workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+			return err
+		}
+		logger.Warn("Enrichment unavailable, continuing without it", "orderID", status.OrderID, "activity", e.name, "error", err)
+		status.Enrichment.Unavailable = append(status.Enrichment.Unavailable, e.name)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package workflows_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/testutil"
+	"go-temporal-fast-course/order-processing/types"
+	"go-temporal-fast-course/order-processing/workflows"
+)
+
+// continuedAsNew returns the input the run handed to its successor
+func continuedAsNew(t *testing.T, env *testsuite.TestWorkflowEnvironment) types.OrderInput {
+	t.Helper()
+	var canErr *workflow.ContinueAsNewError
+	require.True(t, errors.As(env.GetWorkflowError(), &canErr), "expected continue-as-new, got %v", env.GetWorkflowError())
+	var input types.OrderInput
+	require.NoError(t, converter.GetDefaultDataConverter().FromPayloads(canErr.Input, &input))
+	return input
+}
+
+func TestEnrichmentIsNotRepeatedAfterContinueAsNew(t *testing.T) {
+	// The first run looks the customer up once, then continues as new after
+	// 1000 add-item signals
+	first := testutil.NewOrderTestEnv(t)
+	first.OnActivity(activities.ActivityFetchCustomerProfile, mock.Anything, "ORDER-1").Return("Gold", nil).Once()
+	first.RegisterDelayedCallback(func() {
+		for i := 0; i < 1000; i++ {
+			first.SignalWorkflow("add-line-item", types.LineItem{SKU: "PEN-042", Quantity: 1, UnitPrice: 1})
+		}
+	}, time.Minute)
+	first.ExecuteWorkflow(workflows.OrderWorkflow, types.OrderInput{OrderID: "ORDER-1", Items: []types.LineItem{book}})
+	input := continuedAsNew(t, first)
+	require.NotNil(t, input.Resume)
+	require.Equal(t, "Gold", input.Resume.Enrichment.CustomerTier)
+
+	// The next run reuses the carried tier and reservation and goes on to completion
+	next := testutil.NewOrderTestEnv(t)
+	shipAndApprove(t, next)
+	next.ExecuteWorkflow(workflows.OrderWorkflow, input)
+
+	var result string
+	require.NoError(t, next.GetWorkflowResult(&result))
+	require.Contains(t, result, "completed")
+	require.Equal(t, "Gold", queryStatus(t, next).Enrichment.CustomerTier)
+	next.AssertNotCalled(t, activities.ActivityFetchCustomerProfile, mock.Anything, mock.Anything)
+	next.AssertNotCalled(t, activities.ActivityFetchRecommendations, mock.Anything, mock.Anything)
+	next.AssertNotCalled(t, activities.ActivityFetchInventorySnapshot, mock.Anything, mock.Anything)
+	next.AssertNotCalled(t, activities.ActivityReserveStock, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Failed best-effort lookups are listed as unavailable while the ones that
+// succeeded are kept, and the order completes
+func TestEnrichmentKeepsSuccessfulLookupsWhenOthersFail(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityFetchCustomerProfile, mock.Anything, "ORDER-1").
+			Return("", &types.PermanentError{Msg: "profile service unavailable"}).Once()
+	})
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+
+	status := queryStatus(t, env)
+	require.True(t, status.Enrichment.InventoryOk)
+	require.NotEmpty(t, status.Enrichment.Recommendations)
+	require.Empty(t, status.Enrichment.CustomerTier)
+	require.Equal(t, []string{activities.ActivityFetchCustomerProfile}, status.Enrichment.Unavailable)
+}
+
+// A critical lookup failing still fails the order, even when best-effort
+// lookups failed alongside it
+func TestEnrichmentFailsOnInventoryFailure(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityFetchInventorySnapshot, mock.Anything, mock.Anything).
+			Return(nil, &types.PermanentError{Msg: "inventory service unavailable"}).Once()
+		env.OnActivity(activities.ActivityFetchRecommendations, mock.Anything, "ORDER-1").
+			Return(nil, &types.PermanentError{Msg: "recommendation service unavailable"}).Once()
+	})
+
+	_, err := runOrder(t, env, book)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "inventory service unavailable")
+	env.AssertNotCalled(t, activities.ActivityReserveStock, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,21 @@
+package workflows
+
+import (
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/workflow"
+)
+
+// newID returns a random identifier such as "pay-<uuid>" that stays the same
+// on replay. Calling uuid.New() directly in workflow code is a determinism
+// bug: a replay, on this worker after a cache eviction or on another one,
+// generates a different ID than the original run used. An idempotency key
+// would then no longer match the charge it was sent with, and child workflow
+// or activity arguments would differ from the history. SideEffect records the
+// generated ID in the history and replays return the recorded one.
+func newID(ctx workflow.Context, prefix string) (string, error) {
+	var id string
+	err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		return prefix + "-" + uuid.NewString()
+	}).Get(&id)
+	return id, err
+}
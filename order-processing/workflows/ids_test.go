@@ -0,0 +1,95 @@
+package workflows
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	taskqueuepb "go.temporal.io/api/taskqueue/v1"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+func TestNewIDHasPrefix(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(func(ctx workflow.Context) (string, error) {
+		return newID(ctx, "pay")
+	})
+
+	var id string
+	require.NoError(t, env.GetWorkflowResult(&id))
+	require.True(t, strings.HasPrefix(id, "pay-"), id)
+	require.Greater(t, len(id), len("pay-"))
+}
+
+// A replay must return the ID recorded in the history, not generate a new one
+func TestNewIDReplaysRecordedID(t *testing.T) {
+	const recorded = "pay-recorded-in-history"
+
+	var replayed string
+	replayer := worker.NewWorkflowReplayer()
+	replayer.RegisterWorkflowWithOptions(func(ctx workflow.Context) (string, error) {
+		id, err := newID(ctx, "pay")
+		replayed = id
+		return id, err
+	}, workflow.RegisterOptions{Name: "NewIDWorkflow"})
+
+	require.NoError(t, replayer.ReplayWorkflowHistory(nil, sideEffectHistory(t, "NewIDWorkflow", recorded)))
+	require.Equal(t, recorded, replayed)
+}
+
+// sideEffectHistory returns the history of a workflow whose only command was a
+// SideEffect that recorded value, and which then completed returning it
+func sideEffectHistory(t *testing.T, workflowType string, value interface{}) *historypb.History {
+	t.Helper()
+	dc := converter.GetDefaultDataConverter()
+	sideEffectID, err := dc.ToPayloads(int64(1))
+	require.NoError(t, err)
+	data, err := dc.ToPayloads(value)
+	require.NoError(t, err)
+
+	return &historypb.History{Events: []*historypb.HistoryEvent{
+		{
+			EventId:   1,
+			EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED,
+			Attributes: &historypb.HistoryEvent_WorkflowExecutionStartedEventAttributes{WorkflowExecutionStartedEventAttributes: &historypb.WorkflowExecutionStartedEventAttributes{
+				WorkflowType: &commonpb.WorkflowType{Name: workflowType},
+				TaskQueue:    &taskqueuepb.TaskQueue{Name: "replay-test"},
+			}},
+		},
+		{
+			EventId:    2,
+			EventType:  enumspb.EVENT_TYPE_WORKFLOW_TASK_SCHEDULED,
+			Attributes: &historypb.HistoryEvent_WorkflowTaskScheduledEventAttributes{WorkflowTaskScheduledEventAttributes: &historypb.WorkflowTaskScheduledEventAttributes{}},
+		},
+		{EventId: 3, EventType: enumspb.EVENT_TYPE_WORKFLOW_TASK_STARTED},
+		{
+			EventId:    4,
+			EventType:  enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED,
+			Attributes: &historypb.HistoryEvent_WorkflowTaskCompletedEventAttributes{WorkflowTaskCompletedEventAttributes: &historypb.WorkflowTaskCompletedEventAttributes{}},
+		},
+		{
+			EventId:   5,
+			EventType: enumspb.EVENT_TYPE_MARKER_RECORDED,
+			Attributes: &historypb.HistoryEvent_MarkerRecordedEventAttributes{MarkerRecordedEventAttributes: &historypb.MarkerRecordedEventAttributes{
+				MarkerName:                   "SideEffect",
+				Details:                      map[string]*commonpb.Payloads{"side-effect-id": sideEffectID, "data": data},
+				WorkflowTaskCompletedEventId: 4,
+			}},
+		},
+		{
+			EventId:   6,
+			EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_COMPLETED,
+			Attributes: &historypb.HistoryEvent_WorkflowExecutionCompletedEventAttributes{WorkflowExecutionCompletedEventAttributes: &historypb.WorkflowExecutionCompletedEventAttributes{
+				Result:                       data,
+				WorkflowTaskCompletedEventId: 4,
+			}},
+		},
+	}}
+}
@@ -0,0 +1,205 @@
+package workflows
+
+import (
+	"slices"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// SerializeReservations makes OrderWorkflow take the InventoryLockWorkflow lock
+// of every SKU around ReserveStock. Set by the worker at startup; each order
+// records the setting it started with.
+var SerializeReservations = false
+
+// defaultLockLease applies to lock requests without a lease
+const defaultLockLease = 1 * time.Minute
+
+// lockIdleTimeout is how long a lock workflow waits for a request before it
+// completes; the next request starts a new run
+const lockIdleTimeout = 5 * time.Minute
+
+// maxLockGrantsPerRun bounds how many grants one run hands out before
+// continuing as new
+const maxLockGrantsPerRun = 500
+
+// InventoryLockWorkflow is a mutex for one SKU, run under the workflow ID
+// InventoryLockWorkflowID(sku). Order workflows queue a LockRequest with the
+// RequestInventoryLock activity (signal-with-start) and wait for a
+// "lock-granted" signal. Requests are granted one at a time in arrival order;
+// the holder returns the lock with a "release-lock" signal, or loses it when
+// its lease expires.
+//
+// queue carries the waiting requests across continue-as-new, which only
+// happens while the lock is free.
+func InventoryLockWorkflow(ctx workflow.Context, sku string, queue []types.LockRequest) error {
+	logger := workflow.GetLogger(ctx)
+
+	requests := workflow.GetSignalChannel(ctx, activities.SignalRequestLock)
+	releases := workflow.GetSignalChannel(ctx, activities.SignalReleaseLock)
+
+	var holder *types.LockRequest
+	err := workflow.SetQueryHandler(ctx, "get-lock-state", func() (types.LockState, error) {
+		return types.LockState{SKU: sku, Holder: holder, Waiting: queue}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// A retried RequestInventoryLock delivers the same request twice
+	enqueue := func(req types.LockRequest) {
+		if (holder != nil && sameRequester(*holder, req)) || slices.ContainsFunc(queue, func(r types.LockRequest) bool { return sameRequester(r, req) }) {
+			logger.Info("Ignoring duplicate lock request", "sku", sku, "workflowID", req.WorkflowID)
+			return
+		}
+		queue = append(queue, req)
+	}
+	receiveRequest := func(ch workflow.ReceiveChannel, more bool) {
+		var req types.LockRequest
+		ch.Receive(ctx, &req)
+		enqueue(req)
+	}
+
+	grants := 0
+	for {
+		if len(queue) == 0 {
+			// Idle: complete once no request arrived for lockIdleTimeout
+			timerCtx, cancelTimer := workflow.WithCancel(ctx)
+			idle := false
+			selector := workflow.NewSelector(ctx)
+			selector.AddReceive(requests, receiveRequest)
+			selector.AddFuture(workflow.NewTimer(timerCtx, lockIdleTimeout), func(f workflow.Future) {
+				idle = true
+			})
+			selector.Select(ctx)
+			cancelTimer()
+			if idle && requests.Len() == 0 {
+				logger.Info("Inventory lock idle, completing", "sku", sku, "grants", grants)
+				return nil
+			}
+			continue
+		}
+
+		// Keep event history bounded; pending releases are stale since nobody holds the lock
+		if grants >= maxLockGrantsPerRun || workflow.GetInfo(ctx).GetContinueAsNewSuggested() {
+			var req types.LockRequest
+			for requests.ReceiveAsync(&req) {
+				enqueue(req)
+			}
+			logger.Info("Continuing as new", "sku", sku, "grants", grants, "waiting", len(queue))
+			return workflow.NewContinueAsNewError(ctx, InventoryLockWorkflow, sku, queue)
+		}
+
+		next := queue[0]
+		queue = queue[1:]
+		err := workflow.SignalExternalWorkflow(ctx, next.WorkflowID, next.RunID, activities.SignalLockGranted, types.LockGrant{SKU: sku}).Get(ctx, nil)
+		if err != nil {
+			// The requester finished before its turn came
+			logger.Warn("Skipping lock request, requester is gone", "sku", sku, "workflowID", next.WorkflowID, "error", err)
+			continue
+		}
+		holder = &next
+		grants++
+		logger.Info("Inventory lock granted", "sku", sku, "workflowID", next.WorkflowID, "waiting", len(queue))
+
+		// Held: queue new requests until the holder releases or its lease expires
+		lease := holder.Lease
+		if lease <= 0 {
+			lease = defaultLockLease
+		}
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		leaseTimer := workflow.NewTimer(timerCtx, lease)
+		for holder != nil {
+			selector := workflow.NewSelector(ctx)
+			selector.AddReceive(requests, receiveRequest)
+			selector.AddReceive(releases, func(ch workflow.ReceiveChannel, more bool) {
+				var release types.LockRelease
+				ch.Receive(ctx, &release)
+				if release.WorkflowID != holder.WorkflowID || release.RunID != holder.RunID {
+					logger.Info("Ignoring release from a workflow not holding the lock", "sku", sku, "workflowID", release.WorkflowID)
+					return
+				}
+				logger.Info("Inventory lock released", "sku", sku, "workflowID", release.WorkflowID)
+				holder = nil
+			})
+			selector.AddFuture(leaseTimer, func(f workflow.Future) {
+				logger.Warn("Inventory lock lease expired, taking the lock back", "sku", sku, "workflowID", holder.WorkflowID, "lease", lease)
+				holder = nil
+			})
+			selector.Select(ctx)
+		}
+		cancelTimer()
+	}
+}
+
+// sameRequester reports whether two lock requests come from the same workflow run
+func sameRequester(a, b types.LockRequest) bool {
+	return a.WorkflowID == b.WorkflowID && a.RunID == b.RunID
+}
+
+// acquireInventoryLocks takes the lock of every SKU in items, one after the
+// other in sorted SKU order so two orders sharing SKUs never wait on each other.
+// The returned release function gives them all back; it uses a disconnected
+// context so it still runs after ctx was cancelled. On error the locks taken so
+// far are already released.
+func acquireInventoryLocks(ctx workflow.Context, status *types.OrderWorkflowStatus, items []types.LineItem, lease time.Duration) (func(), error) {
+	logger := workflow.GetLogger(ctx)
+	execution := workflow.GetInfo(ctx).WorkflowExecution
+	granted := workflow.GetSignalChannel(ctx, activities.SignalLockGranted)
+
+	var held []string
+	release := func() {
+		releaseCtx, _ := workflow.NewDisconnectedContext(ctx)
+		for _, sku := range held {
+			err := workflow.SignalExternalWorkflow(releaseCtx, activities.InventoryLockWorkflowID(sku), "", activities.SignalReleaseLock,
+				types.LockRelease{SKU: sku, WorkflowID: execution.ID, RunID: execution.RunID}).Get(releaseCtx, nil)
+			if err != nil {
+				// The lease returns the lock eventually
+				logger.Warn("Failed to release inventory lock", "orderID", status.OrderID, "sku", sku, "error", err)
+			}
+		}
+		held = nil
+	}
+
+	lockSKUs := skus(items)
+	slices.Sort(lockSKUs)
+	for _, sku := range lockSKUs {
+		err := runActivity(withProfile(ctx, ProfileExternalIO), status, activities.ActivityRequestInventoryLock, nil, types.LockRequest{
+			SKU:        sku,
+			WorkflowID: execution.ID,
+			RunID:      execution.RunID,
+			Lease:      lease,
+		})
+		if err != nil {
+			release()
+			return nil, err
+		}
+
+		// Only one SKU is requested at a time, so a grant for another is unexpected
+		for {
+			var grant types.LockGrant
+			selector := workflow.NewSelector(ctx)
+			selector.AddReceive(granted, func(ch workflow.ReceiveChannel, more bool) {
+				ch.Receive(ctx, &grant)
+			})
+			selector.AddReceive(ctx.Done(), func(ch workflow.ReceiveChannel, more bool) {})
+			selector.Select(ctx)
+			if ctx.Err() != nil {
+				// The grant may already be on its way
+				held = append(held, sku)
+				release()
+				return nil, ctx.Err()
+			}
+			if grant.SKU == sku {
+				break
+			}
+			logger.Warn("Ignoring inventory lock grant for another SKU", "orderID", status.OrderID, "sku", grant.SKU, "waitingFor", sku)
+		}
+		held = append(held, sku)
+		logger.Info("Inventory lock acquired", "orderID", status.OrderID, "sku", sku)
+	}
+	return release, nil
+}
@@ -1,54 +1,152 @@
 package workflows
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
 
+	"go.temporal.io/api/enums/v1"
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 
+	"go-temporal-fast-course/order-processing/activities"
 	"go-temporal-fast-course/order-processing/types"
+	"go-temporal-fast-course/shared/retry"
 )
 
+// SettlementCurrency is the currency orders are charged in. The worker may
+// override it at startup; each workflow records the value it used.
+var SettlementCurrency = "USD"
+
+// PaymentTaskQueue routes ProcessPayment and RefundPayment to a dedicated task
+// queue so payment workers can be scaled and secured separately. Empty means
+// the workflow's own task queue. Set by the worker at startup.
+var PaymentTaskQueue = ""
+
+// CompletionWebhookURL receives the final status of each completed order as a
+// JSON POST. Empty disables the callback. Set by the worker at startup.
+var CompletionWebhookURL = ""
+
+// EventTopic is the broker topic order lifecycle events are published to. Set by
+// the worker at startup; each order records the topic it started with.
+var EventTopic = "order-events"
+
+// HighValueApprovalPolicy requires several distinct approvers for high-value
+// orders. The zero value needs one approver for every order. Set by the
+// worker at startup; each order records the policy it started with.
+var HighValueApprovalPolicy types.ApprovalPolicy
+
+// Custom search attributes for filtering orders in the Temporal UI.
+// Register them once per namespace (see the starter's register-search-attributes mode).
+var (
+	CustomerTierSearchAttribute = temporal.NewSearchAttributeKeyKeyword("CustomerTier")
+	OrderStageSearchAttribute   = temporal.NewSearchAttributeKeyKeyword("OrderStage")
+)
+
+// maxRecommendations is how many products are picked from the recommendation candidates
+const maxRecommendations = 3
+
+// maxAddItemSignalsPerRun bounds how many add-item signals one run processes
+// before continuing as new
+const maxAddItemSignalsPerRun = 1000
+
 // OrderWorkflow implements a complete order processing workflow with:
-// - Parallel enrichment activities
-// - Signal handlers (approve, cancel, add item)
-// - Query handlers (status, items)
+// - Parallel enrichment activities (profile/recommendations as local activities)
+// - Signal handlers (approve, cancel, add/remove item, apply promo, pause/resume)
+// - Query handlers (status, status DTO, items, history, time remaining, signals summary, failure, approvals, compensations, meta, totals, recommendations, resumable, paused)
+// - Update handler (shipping address)
+// - Child workflow for shipping
 // - Saga pattern compensation
 // - Workflow versioning
+// - Continue-as-new while awaiting approval
 // This integrates concepts from Lessons 2-7
-func OrderWorkflow(ctx workflow.Context, orderID string, initialItems []types.LineItem) (string, error) {
+//
+// input.Resume is nil for a fresh order. When the approval loop continues as
+// new, the current status is passed as Resume and the new run picks up in
+// "awaiting-approval" with the same approval deadline, skipping enrichment and
+// reservation. Query handlers are re-registered from the carried status, so
+// queries against the workflow ID keep answering across the transition.
+//
+// input.Gift is nil for a regular order; a resumed run takes it from the
+// status. input.PaymentMethod is nil to charge the customer's default card on
+// file. Only its masked form is kept in the status, so continue-as-new passes
+// it on as is.
+//
+// Workers register OrderWorkflowShim under this workflow's name rather than
+// OrderWorkflow itself; clients start it with OrderWorkflow and an OrderInput.
+func OrderWorkflow(ctx workflow.Context, input types.OrderInput) (string, error) {
 	logger := workflow.GetLogger(ctx)
+	orderID, resume, paymentMethod := input.OrderID, input.Resume, input.PaymentMethod
 
 	// Workflow versioning (Lesson 7)
 	version := workflow.GetVersion(ctx, "order-workflow-v2", workflow.DefaultVersion, 2)
 
-	status := types.OrderWorkflowStatus{
-		OrderID: orderID,
-		Stage:   "start",
-		Items:   initialItems,
-		Version: fmt.Sprintf("v%d", version),
+	var status types.OrderWorkflowStatus
+	if resume != nil {
+		status = *resume
+		logger.Info("Resuming order after continue-as-new", "orderID", orderID, "stage", status.Stage)
+	} else {
+		status = types.OrderWorkflowStatus{
+			OrderID: orderID,
+			Stage:   "start",
+			Items:   input.Items,
+			Gift:    input.Gift,
+			Version: fmt.Sprintf("v%d", version),
+		}
+		if paymentMethod != nil {
+			status.PaymentMethod = paymentMethod.Masked()
+		}
+		status.History = []types.StageTransition{{Stage: status.Stage, EnteredAt: workflow.Now(ctx)}}
 	}
 
-	// Configure activity options with retry policy (Lesson 5)
-	retryPolicy := &temporal.RetryPolicy{
-		InitialInterval:        1 * time.Second,
-		BackoffCoefficient:     2.0,
-		MaximumInterval:        30 * time.Second,
-		MaximumAttempts:        5,
-		NonRetryableErrorTypes: []string{"PermanentError", "ValidationError"},
+	// Stage transitions are persisted to the order_status table; gated so
+	// histories recorded before this activity existed still replay
+	persistVersion := workflow.GetVersion(ctx, "persist-status", workflow.DefaultVersion, 1)
+
+	// Stage timeline for the get-history query, timestamps from workflow.Now for determinism.
+	// The OrderStage search attribute is kept in sync so the UI can filter by stage.
+	upsertStage := func() {
+		if err := workflow.UpsertTypedSearchAttributes(ctx, OrderStageSearchAttribute.ValueSet(status.Stage)); err != nil {
+			logger.Warn("Failed to upsert OrderStage search attribute", "error", err)
+		}
 	}
+	setStage := func(stage string) {
+		// A paused order is held here, before it moves on; cancellation is never held
+		if stage != "cancelled" && status.Paused {
+			logger.Info("Order paused, holding before next stage", "orderID", orderID, "stage", status.Stage, "next", stage)
+			_ = workflow.Await(ctx, func() bool { return !status.Paused })
+		}
+		status.Stage = stage
+		status.History = append(status.History, types.StageTransition{Stage: stage, EnteredAt: workflow.Now(ctx)})
+		upsertStage()
 
-	activityOptions := workflow.ActivityOptions{
-		StartToCloseTimeout: 30 * time.Second,
-		RetryPolicy:         retryPolicy,
-		HeartbeatTimeout:    15 * time.Second,
+		// Best-effort: a persistence outage must not fail the order
+		if persistVersion >= 1 {
+			// Bookkeeping, so scheduled without recording it in the history
+			if err := scheduleActivity(withProfile(ctx, ProfileExternalIO), &status, activities.ActivityPersistStatus, status).Get(ctx, nil); err != nil {
+				logger.Warn("Failed to persist order status", "orderID", orderID, "stage", stage, "error", err)
+			}
+		}
 	}
-	ctx = workflow.WithActivityOptions(ctx, activityOptions)
+	upsertStage()
 
 	// Register query handlers (Lesson 6)
-	err := workflow.SetQueryHandler(ctx, "get-status", func() (types.OrderWorkflowStatus, error) {
-		return status, nil
+	// get-status takes the caller's role and redacts what it may not see
+	err := workflow.SetQueryHandler(ctx, "get-status", func(role string) (types.OrderWorkflowStatus, error) {
+		return status.RedactedFor(role), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Stable external view; prefer this over get-status for new clients
+	err = workflow.SetQueryHandler(ctx, "get-status-dto", func() (types.StatusDTO, error) {
+		return status.DTO(), nil
 	})
 	if err != nil {
 		return "", err
@@ -61,147 +159,1161 @@ func OrderWorkflow(ctx workflow.Context, orderID string, initialItems []types.Li
 		return "", err
 	}
 
+	err = workflow.SetQueryHandler(ctx, "get-history", func() ([]types.StageTransition, error) {
+		return status.History, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = workflow.SetQueryHandler(ctx, "get-time-remaining", func() (time.Duration, error) {
+		return approvalTimeRemaining(status, workflow.Now(ctx)), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Delivery counts per signal so operators can confirm a signal arrived
+	// without reading event history; carried across continue-as-new in status
+	recordSignal := func(name string) {
+		if status.Signals == nil {
+			status.Signals = make(map[string]types.SignalSummary)
+		}
+		summary := status.Signals[name]
+		summary.Count++
+		summary.LastReceived = workflow.Now(ctx)
+		status.Signals[name] = summary
+	}
+
+	err = workflow.SetQueryHandler(ctx, "get-signals-summary", func() (map[string]types.SignalSummary, error) {
+		return status.Signals, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Structured record of the error the workflow last failed with, for alerting
+	// pipelines; set on every error return path via fail
+	fail := func(err error) (string, error) {
+		status.LastFailure = &types.FailureDetail{
+			Type:      failureType(err),
+			Message:   err.Error(),
+			Stage:     status.Stage,
+			Timestamp: workflow.Now(ctx),
+		}
+		// The failed snapshot is what ReprocessOrderWorkflow resumes from
+		if persistVersion >= 1 && workflow.GetVersion(ctx, "persist-failure", workflow.DefaultVersion, 1) >= 1 {
+			if perr := scheduleActivity(withProfile(ctx, ProfileExternalIO), &status, activities.ActivityPersistStatus, status).Get(ctx, nil); perr != nil {
+				logger.Warn("Failed to persist order failure", "orderID", orderID, "error", perr)
+			}
+		}
+		return "", err
+	}
+
+	err = workflow.SetQueryHandler(ctx, "get-failure", func() (*types.FailureDetail, error) {
+		return status.LastFailure, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = workflow.SetQueryHandler(ctx, "get-approvals", func() (types.ApprovalStatus, error) {
+		required := status.RequiredApprovals()
+		return types.ApprovalStatus{
+			Approvers: status.Approvers,
+			Required:  required,
+			Remaining: max(required-len(status.Approvers), 0),
+		}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = workflow.SetQueryHandler(ctx, "get-compensations", func() ([]string, error) {
+		return status.CompensationsRun, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = workflow.SetQueryHandler(ctx, "get-meta", func() (types.WorkflowMeta, error) {
+		return types.WorkflowMeta{
+			Version:            status.Version,
+			StartTime:          workflow.GetInfo(ctx).WorkflowStartTime,
+			ActivitiesExecuted: status.ActivitiesExecuted,
+		}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Recomputed on every query, so the cart stays current while awaiting approval
+	err = workflow.SetQueryHandler(ctx, "get-totals", func() (types.OrderTotals, error) {
+		return status.Totals(), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = workflow.SetQueryHandler(ctx, "get-recommendations", func() ([]types.Recommendation, error) {
+		return status.Enrichment.Recommendations, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = workflow.SetQueryHandler(ctx, "is-resumable", func() (bool, error) {
+		return status.IsResumable(), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Address updates are forwarded to the main loop so tax can be (re)calculated there
+	addressUpdated := workflow.NewBufferedChannel(ctx, 1)
+
+	// Register update handler with validator - validator rejects before state is mutated
+	err = workflow.SetUpdateHandlerWithOptions(ctx, "update-shipping-address",
+		func(ctx workflow.Context, address types.ShippingAddress) (types.ShippingAddress, error) {
+			previous := status.ShippingAddress
+			status.ShippingAddress = address
+			addressUpdated.SendAsync(true)
+			logger.Info("Shipping address updated", "orderID", orderID, "postalCode", address.PostalCode)
+			return previous, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, address types.ShippingAddress) error {
+				return validateShippingAddressUpdate(status.Stage, address)
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
 	// Setup signal channels (Lesson 6)
 	sigApprove := workflow.GetSignalChannel(ctx, "approve-payment")
 	sigCancel := workflow.GetSignalChannel(ctx, "cancel-order")
 	sigAddItem := workflow.GetSignalChannel(ctx, "add-line-item")
+	sigPromo := workflow.GetSignalChannel(ctx, "apply-promo")
+	sigRemoveItem := workflow.GetSignalChannel(ctx, "remove-line-item")
 
-	// Step 1: Enrichment - parallel or sequential based on version (Lesson 7)
-	status.Stage = "enrichment"
-	if version == workflow.DefaultVersion {
-		// Sequential enrichment (backward compatibility)
-		var invOk bool
-		err := workflow.ExecuteActivity(ctx, "FetchInventorySnapshot", status.Items).Get(ctx, &invOk)
+	// Operators hold an order for investigation with pause-order and release it
+	// with resume-order. The hold takes effect at the next stage boundary (see
+	// setStage); the approval deadline is pushed back by the time spent paused.
+	// pauseChanged wakes the approval loop so it can stop or restart its timer.
+	sigPause := workflow.GetSignalChannel(ctx, "pause-order")
+	sigResume := workflow.GetSignalChannel(ctx, "resume-order")
+	pauseChanged := workflow.NewBufferedChannel(ctx, 1)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			selector := workflow.NewSelector(ctx)
+			selector.AddReceive(sigPause, func(ch workflow.ReceiveChannel, more bool) {
+				var req types.PauseRequest
+				ch.Receive(ctx, &req)
+				recordSignal("pause-order")
+				if status.Paused {
+					logger.Info("Ignoring pause, order already paused", "orderID", orderID, "by", req.By)
+					return
+				}
+				status.Paused = true
+				status.PausedAt = workflow.Now(ctx)
+				status.PauseReason = req.Reason
+				logger.Info("Order paused", "orderID", orderID, "stage", status.Stage, "by", req.By, "reason", req.Reason)
+			})
+			selector.AddReceive(sigResume, func(ch workflow.ReceiveChannel, more bool) {
+				ch.Receive(ctx, nil)
+				recordSignal("resume-order")
+				if !status.Paused {
+					logger.Info("Ignoring resume, order not paused", "orderID", orderID)
+					return
+				}
+				pausedFor := workflow.Now(ctx).Sub(status.PausedAt)
+				if status.Stage == "awaiting-approval" && !status.ApprovalDeadline.IsZero() {
+					status.ApprovalDeadline = status.ApprovalDeadline.Add(pausedFor)
+				}
+				status.Paused = false
+				status.PausedAt = time.Time{}
+				status.PauseReason = ""
+				logger.Info("Order resumed", "orderID", orderID, "stage", status.Stage, "pausedFor", pausedFor)
+			})
+			selector.Select(ctx)
+			if pauseChanged.Len() == 0 {
+				pauseChanged.SendAsync(true)
+			}
+		}
+	})
+
+	err = workflow.SetQueryHandler(ctx, "is-paused", func() (bool, error) {
+		return status.Paused, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Compensations for the steps completed so far, run in reverse on failure or cancellation
+	// Successful compensations are recorded for the get-compensations query
+	recordCompensation := func(name string) {
+		status.CompensationsRun = append(status.CompensationsRun, name)
+	}
+	saga := Saga{OnCompensated: recordCompensation}
+	releaseStock := func(ctx workflow.Context) error {
+		return runActivity(withProfile(ctx, ProfileCriticalWrite), &status, activities.ActivityReleaseStock, nil, orderID)
+	}
+	// Cancellation notices are legally required, so unlike the confirmation
+	// they go out whatever the customer's notification preferences
+	sendCancellationEmail := func() {
+		if err := runActivity(withProfile(ctx, ProfileExternalIO), &status, activities.ActivitySendCancellationEmail, nil, orderID, status.LastError); err == nil {
+			recordCompensation(activities.ActivitySendCancellationEmail)
+		}
+	}
+	if status.Reserved {
+		// Resumed after continue-as-new with the reservation still held
+		saga.AddCompensation(activities.ActivityReleaseStock, releaseStock)
+	}
+
+	// Per-stage wall-clock budgets, recorded with SideEffect so a worker
+	// restarted with different budgets still replays. Older histories run unbounded.
+	var budgets StageBudgets
+	if workflow.GetVersion(ctx, "stage-budgets", workflow.DefaultVersion, 1) >= 1 {
+		err = workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+			return OrderStageBudgets
+		}).Get(&budgets)
 		if err != nil {
-			return "", err
+			return fail(err)
 		}
-		status.Enrichment.InventoryOk = invOk
-	} else {
-		// Parallel enrichment (new version)
-		fInventory := workflow.ExecuteActivity(ctx, "FetchInventorySnapshot", status.Items)
-		fCustomer := workflow.ExecuteActivity(ctx, "FetchCustomerProfile", orderID)
-		fRecs := workflow.ExecuteActivity(ctx, "FetchRecommendations", orderID)
+	}
 
-		var invOk bool
-		var customerTier string
-		var recs []string
+	// Lifecycle events for downstream consumers, best-effort: a broker outage is
+	// logged and the order carries on. Older histories publish nothing.
+	var eventTopic string
+	if workflow.GetVersion(ctx, "domain-events", workflow.DefaultVersion, 1) >= 1 {
+		err = workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+			return EventTopic
+		}).Get(&eventTopic)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	eventCtx := withProfile(ctx, ProfileExternalIO)
+	publishEvent := func(event types.OrderEvent) {
+		if eventTopic == "" {
+			return
+		}
+		event.OrderID = orderID
+		event.OccurredAt = workflow.Now(ctx)
+		if err := runActivity(eventCtx, &status, activities.ActivityPublishEvent, nil, eventTopic, event); err != nil {
+			logger.Warn("Failed to publish order event", "orderID", orderID, "type", event.Type, "error", err)
+		}
+	}
 
-		if err := fInventory.Get(ctx, &invOk); err != nil {
-			return "", err
+	// Validation, enrichment and reservation run once per order. A run resumed
+	// by continue-as-new reuses the tier, recommendations and reservation
+	// carried in its status instead of fetching them again.
+	if needsEnrichment(status) {
+		// Reject junk orders before any activity runs. Empty orders used to go on
+		// to enrichment and fail there, which older histories still replay.
+		if len(status.Items) == 0 && workflow.GetVersion(ctx, "reject-empty-order", workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+			logger.Warn("Empty order started before empty orders were rejected", "orderID", orderID)
+		} else if err := types.ValidateOrder(status.Items); err != nil {
+			status.LastError = fmt.Sprintf("invalid order: %v", err)
+			logger.Warn("Order validation failed", "orderID", orderID, "error", err)
+			return fail(err)
 		}
-		if err := fCustomer.Get(ctx, &customerTier); err != nil {
-			return "", err
+		if status.Gift != nil {
+			if err := status.Gift.Validate(); err != nil {
+				status.LastError = fmt.Sprintf("invalid order: %v", err)
+				logger.Warn("Gift validation failed", "orderID", orderID, "error", err)
+				return fail(err)
+			}
 		}
-		if err := fRecs.Get(ctx, &recs); err != nil {
-			return "", err
+		if paymentMethod != nil {
+			if err := paymentMethod.Validate(); err != nil {
+				status.LastError = fmt.Sprintf("invalid order: %v", err)
+				logger.Warn("Payment method validation failed", "orderID", orderID, "error", err)
+				return fail(err)
+			}
+		}
+		publishEvent(types.OrderEvent{Type: types.EventOrderStarted, Items: status.Items})
+
+		// Step 1: Enrichment - parallel or sequential based on version (Lesson 7)
+		setStage("enrichment")
+		var availability map[string]int
+		if version == workflow.DefaultVersion {
+			// Sequential enrichment (backward compatibility)
+			err := runActivity(withProfile(ctx, ProfileFastReadOnly), &status, activities.ActivityFetchInventorySnapshot, &availability, status.Items)
+			if err != nil {
+				return fail(err)
+			}
+		} else {
+			// Parallel enrichment (new version); recorded in the history once each finishes
+			startedAt := workflow.Now(ctx)
+			readCtx := withProfile(ctx, ProfileFastReadOnly)
+			fInventory := scheduleActivity(readCtx, &status, activities.ActivityFetchInventorySnapshot, status.Items)
+
+			// Profile and recommendations are cheap in-memory lookups, so newer runs execute
+			// them as local activities in the worker and skip the task-queue round-trips
+			var fCustomer, fRecs workflow.Future
+			if workflow.GetVersion(ctx, "local-enrichment", workflow.DefaultVersion, 1) >= 1 {
+				localCtx := workflow.WithLocalActivityOptions(ctx, workflow.LocalActivityOptions{
+					StartToCloseTimeout: 5 * time.Second,
+					RetryPolicy:         defaultRetryPolicy(),
+				})
+				fCustomer = scheduleLocalActivity(localCtx, &status, activities.ActivityFetchCustomerProfile, orderID)
+				fRecs = scheduleLocalActivity(localCtx, &status, activities.ActivityFetchRecommendations, orderID)
+			} else {
+				fCustomer = scheduleActivity(readCtx, &status, activities.ActivityFetchCustomerProfile, orderID)
+				fRecs = scheduleActivity(readCtx, &status, activities.ActivityFetchRecommendations, orderID)
+			}
+
+			// Only inventory is critical; without a tier or recommendations the order
+			// carries on with them empty. Add lookups here to run them alongside.
+			var customerTier string
+			var recs []types.Recommendation
+			err := awaitEnrichments(ctx, &status, startedAt, []enrichment{
+				{name: activities.ActivityFetchInventorySnapshot, future: fInventory, valuePtr: &availability},
+				{name: activities.ActivityFetchCustomerProfile, future: fCustomer, valuePtr: &customerTier, bestEffort: true},
+				{name: activities.ActivityFetchRecommendations, future: fRecs, valuePtr: &recs, bestEffort: true},
+			})
+			if err != nil {
+				return fail(err)
+			}
+
+			status.Enrichment.CustomerTier = customerTier
+			// v2 keeps the highest-scored candidates; v1 picked at random
+			if v := workflow.GetVersion(ctx, "recommendation-picker", workflow.DefaultVersion, 2); v >= 2 {
+				recs = rankRecommendations(recs, maxRecommendations)
+			} else if v == 1 {
+				recs, err = pickRecommendations(ctx, recs, maxRecommendations)
+				if err != nil {
+					return fail(err)
+				}
+			}
+			status.Enrichment.Recommendations = recs
+
+			if customerTier != "" {
+				if err := workflow.UpsertTypedSearchAttributes(ctx, CustomerTierSearchAttribute.ValueSet(customerTier)); err != nil {
+					logger.Warn("Failed to upsert CustomerTier search attribute", "error", err)
+				}
+			}
 		}
 
-		status.Enrichment.InventoryOk = invOk
-		status.Enrichment.CustomerTier = customerTier
-		status.Enrichment.Recommendations = recs
+		// Split the order into what can ship now and what must be backordered
+		status.Items, status.BackorderedItems = splitByAvailability(status.Items, availability)
+		status.Enrichment.InventoryOk = len(status.Items) > 0
+		if len(status.BackorderedItems) > 0 {
+			logger.Info("Order partially backordered", "orderID", orderID, "backordered", status.BackorderedItems)
+		}
+
+		if !status.Enrichment.InventoryOk {
+			logger.Warn("Inventory check failed", "orderID", orderID)
+			status.LastError = "insufficient inventory"
+			return fail(&types.InsufficientInventoryError{OrderID: orderID, SKUs: skus(status.BackorderedItems)})
+		}
+
+		// Step 2: Reserve Stock (Lesson 5)
+		setStage("reserve")
+		// Optionally serialize reservations per SKU through InventoryLockWorkflow.
+		// The setting is recorded with SideEffect so a worker restarted with a
+		// different one still replays.
+		lockInventory := false
+		if workflow.GetVersion(ctx, "inventory-locks", workflow.DefaultVersion, 1) >= 1 {
+			err = workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+				return SerializeReservations
+			}).Get(&lockInventory)
+			if err != nil {
+				return fail(err)
+			}
+		}
+		// ReserveStock rolls back its own partial reservations, so a failure needs no compensation here
+		var reservation types.ReservationResult
+		err = withStageTimeout(ctx, "reserve", budgets.Reserve, func(ctx workflow.Context) error {
+			if lockInventory {
+				// The lease starts after the stage did, so the reserve budget runs out first
+				release, err := acquireInventoryLocks(ctx, &status, status.Items, budgets.Reserve)
+				if err != nil {
+					return err
+				}
+				defer release()
+			}
+			// ReserveStock heartbeats per SKU so a stage timeout stops it promptly
+			ctx = workflow.WithHeartbeatTimeout(withProfile(ctx, ProfileCriticalWrite), 15*time.Second)
+			return reserveStock(ctx, &status, budgets.Reserve, &reservation)
+		})
+		if err != nil {
+			status.LastError = fmt.Sprintf("reserve failed: %v", err)
+			return fail(err)
+		}
+		status.Reserved = true
+		status.ReservedItems = append([]types.LineItem(nil), status.Items...)
+		saga.AddCompensation(activities.ActivityReleaseStock, releaseStock)
+		logger.Info("Stock reserved", "orderID", orderID, "skus", reservation.ReservedSKUs, "warehouse", status.Warehouse)
+		publishEvent(types.OrderEvent{Type: types.EventStockReserved, Items: status.ReservedItems})
 	}
 
-	if !status.Enrichment.InventoryOk {
-		logger.Warn("Inventory check failed", "orderID", orderID)
-		status.LastError = "insufficient inventory"
-		return "", fmt.Errorf("insufficient inventory for order %s", orderID)
+	// Tax and shipping depend on the shipping address; defer them until an address update arrives
+	taxPending := true
+	calculateTax := func() error {
+		if status.ShippingAddress.Street == "" {
+			logger.Info("No shipping address yet, deferring tax calculation", "orderID", orderID)
+			return nil
+		}
+		var tax float64
+		err := runActivity(withProfile(ctx, ProfileFastReadOnly), &status, activities.ActivityCalculateTax, &tax, status.Total()-status.DiscountAmount, status.ShippingAddress)
+		if err != nil {
+			return err
+		}
+		status.TaxAmount = tax
+		// Orders started before shipping was charged keep shipping free
+		if workflow.GetVersion(ctx, "shipping-cost", workflow.DefaultVersion, 1) >= 1 {
+			var shipping float64
+			err := runActivity(withProfile(ctx, ProfileFastReadOnly), &status, activities.ActivityCalculateShippingCost, &shipping, status.Items, status.ShippingAddress)
+			if err != nil {
+				return err
+			}
+			status.ShippingCost = shipping
+		}
+		taxPending = false
+		logger.Info("Tax calculated", "orderID", orderID, "tax", tax, "shipping", status.ShippingCost)
+		return nil
+	}
+	if err := calculateTax(); err != nil {
+		status.LastError = fmt.Sprintf("tax or shipping calculation failed: %v", err)
+		saga.Compensate(ctx)
+		return fail(err)
 	}
 
-	// Step 2: Reserve Stock (Lesson 5)
-	status.Stage = "reserve"
-	err = workflow.ExecuteActivity(ctx, "ReserveStock", orderID, status.Items).Get(ctx, nil)
-	if err != nil {
-		status.LastError = fmt.Sprintf("reserve failed: %v", err)
-		return "", err
+	// Promo codes are validated against the current subtotal; invalid codes leave the discount at zero
+	applyPromo := func() {
+		var discount float64
+		err := runActivity(withProfile(ctx, ProfileFastReadOnly), &status, activities.ActivityValidatePromo, &discount, status.PromoCode, status.Total())
+		if err != nil {
+			logger.Warn("Promo code rejected", "orderID", orderID, "code", status.PromoCode, "error", err)
+			status.PromoCode = ""
+			discount = 0
+		}
+		status.DiscountAmount = discount
+		logger.Info("Discount applied", "orderID", orderID, "discount", discount)
 	}
-	status.Reserved = true
-	logger.Info("Stock reserved", "orderID", orderID)
 
 	// Step 3: Await Approval with timeout (Lesson 6)
-	status.Stage = "awaiting-approval"
-	approvalTimeout := workflow.Now(ctx).Add(15 * time.Minute)
-	status.ApprovalDeadline = approvalTimeout
+	if resume == nil {
+		setStage("awaiting-approval")
+		// Tier-based timeout is versioned so orders already waiting keep their 15-minute deadline
+		approvalWindow := 15 * time.Minute
+		if workflow.GetVersion(ctx, "tier-approval-timeout", workflow.DefaultVersion, 1) >= 1 {
+			approvalWindow = approvalTimeoutForTier(status.Enrichment.CustomerTier)
+		}
+		status.ApprovalDeadline = workflow.Now(ctx).Add(approvalWindow)
+
+		// The policy is recorded with SideEffect so a worker restarted with a
+		// different setting still replays; it is carried across continue-as-new
+		if workflow.GetVersion(ctx, "multi-approval", workflow.DefaultVersion, 1) >= 1 {
+			err = workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+				return HighValueApprovalPolicy
+			}).Get(&status.ApprovalPolicy)
+			if err != nil {
+				saga.Compensate(ctx)
+				return fail(err)
+			}
+		}
+	}
 
-	for !status.PaymentApproved && !status.Cancelled {
+	// Payment cannot proceed until it is approved and tax has been calculated.
+	// Select only parks this coroutine; queries are answered from status
+	// between workflow tasks, so they keep responding while the loop waits.
+	addItemSignals := 0
+	promoPending := false
+	var releasePending []types.LineItem
+	// Items added after the reservation are reserved by the loop, in the
+	// warehouse holding the rest. If that fails the items are taken off the
+	// order again so payment never covers goods that aren't held.
+	var reservePending []types.LineItem
+	reserveAdded := func() {
+		items := reservePending
+		reservePending = nil
+		ctx := workflow.WithHeartbeatTimeout(withProfile(ctx, ProfileCriticalWrite), 15*time.Second)
+		var reservation types.ReservationResult
+		if err := runActivity(ctx, &status, activities.ActivityReserveStock, &reservation, orderID, items, status.Warehouse); err != nil {
+			logger.Warn("Reserving added items failed, removing them from the order", "orderID", orderID, "items", items, "error", err)
+			for _, item := range items {
+				status.Items, _ = removeLineItem(status.Items, item)
+			}
+			return
+		}
+		status.ReservedItems = append(status.ReservedItems, items...)
+		logger.Info("Added items reserved", "orderID", orderID, "skus", reservation.ReservedSKUs, "warehouse", status.Warehouse)
+	}
+	for !(status.PaymentApproved && !taxPending) && !status.Cancelled {
 		selector := workflow.NewSelector(ctx)
-		timerFut := workflow.NewTimer(ctx, time.Until(approvalTimeout))
 
 		selector.AddReceive(sigApprove, func(ch workflow.ReceiveChannel, more bool) {
 			var payload types.PaymentApproval
 			ch.Receive(ctx, &payload)
-			status.PaymentApproved = true
-			logger.Info("Approval received", "by", payload.ApprovedBy)
+			recordSignal("approve-payment")
+			// Older histories recorded anonymous approvals; only new ones get the marker
+			if strings.TrimSpace(payload.ApprovedBy) == "" &&
+				workflow.GetVersion(ctx, "reject-anonymous-approval", workflow.DefaultVersion, 1) >= 1 {
+				logger.Warn("Ignoring approval without ApprovedBy", "orderID", orderID)
+				return
+			}
+			if slices.Contains(status.Approvers, payload.ApprovedBy) {
+				logger.Info("Ignoring duplicate approval", "by", payload.ApprovedBy)
+				return
+			}
+			status.Approvers = append(status.Approvers, payload.ApprovedBy)
+			logger.Info("Approval received", "by", payload.ApprovedBy, "approvals", len(status.Approvers), "required", status.RequiredApprovals())
 		})
 
 		selector.AddReceive(sigCancel, func(ch workflow.ReceiveChannel, more bool) {
 			var payload types.CancelRequest
 			ch.Receive(ctx, &payload)
+			recordSignal("cancel-order")
+			payload = payload.Normalize()
 			status.Cancelled = true
-			status.LastError = fmt.Sprintf("cancelled: %s", payload.Reason)
-			logger.Info("Cancellation received", "reason", payload.Reason)
+			status.CancellationReason = payload.Reason
+			status.LastError = fmt.Sprintf("cancelled: %s", payload.Describe())
+			logger.Info("Cancellation received", "reason", payload.Reason, "note", payload.Note)
 		})
 
 		selector.AddReceive(sigAddItem, func(ch workflow.ReceiveChannel, more bool) {
 			var item types.LineItem
 			ch.Receive(ctx, &item)
+			recordSignal("add-line-item")
+			if err := item.Validate(); err != nil {
+				logger.Warn("Ignoring invalid line item", "error", err)
+				return
+			}
 			status.Items = append(status.Items, item)
+			// Older histories charged added items without reserving them
+			if status.Reserved && workflow.GetVersion(ctx, "incremental-reserve", workflow.DefaultVersion, 1) >= 1 {
+				reservePending = append(reservePending, item)
+			}
+			taxPending = true
+			promoPending = status.PromoCode != ""
+			addItemSignals++
 			logger.Info("Item added", "sku", item.SKU, "qty", item.Quantity)
 		})
 
-		selector.AddFuture(timerFut, func(f workflow.Future) {
-			status.Cancelled = true
-			status.LastError = "approval timeout"
-			logger.Warn("Approval timed out")
+		// Quantity reduces the SKU by that many units; 0 removes it entirely
+		selector.AddReceive(sigRemoveItem, func(ch workflow.ReceiveChannel, more bool) {
+			var item types.LineItem
+			ch.Receive(ctx, &item)
+			recordSignal("remove-line-item")
+			remaining, removed := removeLineItem(status.Items, item)
+			if removed == 0 {
+				logger.Warn("Ignoring removal of SKU not in order", "sku", item.SKU)
+				return
+			}
+			if len(remaining) == 0 {
+				logger.Warn("Ignoring removal of the last item, cancel the order instead", "sku", item.SKU)
+				return
+			}
+			status.Items = remaining
+
+			// Items added after reservation by older histories were never reserved,
+			// so only release what the remaining quantity no longer covers
+			reserved := quantityOf(status.ReservedItems, item.SKU)
+			if release := reserved - quantityOf(status.Items, item.SKU); release > 0 {
+				releasePending = append(releasePending, types.LineItem{SKU: item.SKU, Quantity: release})
+				status.ReservedItems, _ = removeLineItem(status.ReservedItems, types.LineItem{SKU: item.SKU, Quantity: release})
+			}
+			taxPending = true
+			promoPending = status.PromoCode != ""
+			logger.Info("Item removed", "sku", item.SKU, "qty", removed)
 		})
 
+		selector.AddReceive(sigPromo, func(ch workflow.ReceiveChannel, more bool) {
+			var promo types.PromoCode
+			ch.Receive(ctx, &promo)
+			recordSignal("apply-promo")
+			status.PromoCode = promo.Code
+			promoPending = true
+			logger.Info("Promo code received", "code", promo.Code)
+		})
+
+		selector.AddReceive(addressUpdated, func(ch workflow.ReceiveChannel, more bool) {
+			ch.Receive(ctx, nil)
+			taxPending = true
+		})
+
+		selector.AddReceive(pauseChanged, func(ch workflow.ReceiveChannel, more bool) {
+			ch.Receive(ctx, nil)
+		})
+
+		// The deadline clock stops while paused; resume-order moves the deadline
+		// back, so a timer from before the pause may fire early and is ignored
+		if !status.Paused {
+			timerFut := workflow.NewTimer(ctx, status.ApprovalDeadline.Sub(workflow.Now(ctx)))
+			selector.AddFuture(timerFut, func(f workflow.Future) {
+				if workflow.Now(ctx).Before(status.ApprovalDeadline) {
+					return
+				}
+				status.Cancelled = true
+				status.CancellationReason = types.ReasonTimeout
+				status.LastError = types.ReasonTimeout.String()
+				logger.Warn("Approval timed out")
+			})
+		}
+
 		selector.Select(ctx)
+
+		// Re-evaluated every round: added items can push an order over the high-value threshold
+		status.PaymentApproved = len(status.Approvers) >= status.RequiredApprovals()
+
+		// Cancellation releases the whole reservation, so partial releases are only needed otherwise
+		if len(releasePending) > 0 && !status.Cancelled {
+			if err := runActivity(withProfile(ctx, ProfileCriticalWrite), &status, activities.ActivityReleaseStockItems, nil, orderID, releasePending); err != nil {
+				logger.Warn("Partial stock release failed", "orderID", orderID, "items", releasePending, "error", err)
+			}
+		}
+		releasePending = nil
+		if len(reservePending) > 0 && !status.Cancelled {
+			reserveAdded()
+		}
+
+		// Discount changes the taxable amount, so it is applied before tax
+		if promoPending && !status.Cancelled {
+			applyPromo()
+			promoPending = false
+			taxPending = true
+		}
+		if taxPending && !status.Cancelled {
+			if err := calculateTax(); err != nil {
+				status.LastError = fmt.Sprintf("tax or shipping calculation failed: %v", err)
+				logger.Error("Tax or shipping calculation failed", "error", err)
+				saga.Compensate(ctx)
+				return fail(err)
+			}
+		}
+		if status.PaymentApproved && taxPending {
+			logger.Info("Payment approved, waiting for shipping address", "orderID", orderID)
+		}
+
+		// Keep event history bounded: hand the current status to a fresh run.
+		// Only continue when no approve/cancel signal is waiting so none is lost,
+		// and drain pending add-item signals into the carried status first.
+		if !status.PaymentApproved && !status.Cancelled &&
+			(addItemSignals >= maxAddItemSignalsPerRun || workflow.GetInfo(ctx).GetContinueAsNewSuggested()) &&
+			sigApprove.Len() == 0 && sigCancel.Len() == 0 && sigPromo.Len() == 0 && sigRemoveItem.Len() == 0 &&
+			sigPause.Len() == 0 && sigResume.Len() == 0 {
+			// Added items are reserved by the loop, so newer runs handle waiting
+			// add-item signals first instead of draining them unreserved
+			if sigAddItem.Len() > 0 && workflow.GetVersion(ctx, "incremental-reserve", workflow.DefaultVersion, 1) >= 1 {
+				continue
+			}
+			var item types.LineItem
+			for sigAddItem.ReceiveAsync(&item) {
+				recordSignal("add-line-item")
+				status.Items = append(status.Items, item)
+			}
+			logger.Info("Continuing as new", "orderID", orderID, "addItemSignals", addItemSignals)
+			return "", workflow.NewContinueAsNewError(ctx, OrderWorkflow, types.OrderInput{
+				OrderID:       orderID,
+				Items:         status.Items,
+				Gift:          status.Gift,
+				PaymentMethod: paymentMethod,
+				Resume:        &status,
+			})
+		}
 	}
 
 	if status.Cancelled {
 		// Compensation - release stock (Lesson 5: Saga pattern)
-		_ = workflow.ExecuteActivity(ctx, "ReleaseStock", orderID).Get(ctx, nil)
-		_ = workflow.ExecuteActivity(ctx, "SendCancellationEmail", orderID, status.LastError).Get(ctx, nil)
-		status.Stage = "cancelled"
+		saga.Compensate(ctx)
+		sendCancellationEmail()
+		setStage("cancelled")
+		publishEvent(types.OrderEvent{Type: types.EventOrderCancelled, CancellationReason: status.CancellationReason})
+		workflow.GetMetricsHandler(ctx).Counter("order_cancelled").Inc(1)
 		return fmt.Sprintf("Order %s cancelled (%s)", orderID, status.LastError), nil
 	}
 
+	// Late cancels: a background selector keeps listening for cancel-order until the
+	// order completes. Shipping runs under fulfillCtx so a cancel stops it; payment
+	// is never interrupted mid-charge, the cancel is applied once it returns.
+	// After the charge only a forced cancel is honoured since it refunds the payment.
+	fulfillCtx, cancelFulfillment := workflow.WithCancel(ctx)
+	var lateCancel *types.CancelRequest
+	if workflow.GetVersion(ctx, "late-cancel", workflow.DefaultVersion, 1) >= 1 {
+		workflow.Go(ctx, func(ctx workflow.Context) {
+			for lateCancel == nil {
+				selector := workflow.NewSelector(ctx)
+				selector.AddReceive(sigCancel, func(ch workflow.ReceiveChannel, more bool) {
+					var payload types.CancelRequest
+					ch.Receive(ctx, &payload)
+					recordSignal("cancel-order")
+					payload = payload.Normalize()
+					if status.Charged && !payload.Force {
+						logger.Warn("Ignoring cancellation after charge, Force not set", "orderID", orderID, "reason", payload.Reason)
+						return
+					}
+					lateCancel = &payload
+					cancelFulfillment()
+					logger.Info("Late cancellation received", "orderID", orderID, "stage", status.Stage, "reason", payload.Reason)
+				})
+				selector.Select(ctx)
+			}
+		})
+	}
+	compensateLateCancel := func() (string, error) {
+		status.Cancelled = true
+		status.CancellationReason = lateCancel.Reason
+		status.LastError = fmt.Sprintf("cancelled: %s", lateCancel.Describe())
+		saga.Compensate(ctx)
+		sendCancellationEmail()
+		setStage("cancelled")
+		publishEvent(types.OrderEvent{Type: types.EventOrderCancelled, CancellationReason: status.CancellationReason})
+		workflow.GetMetricsHandler(ctx).Counter("order_cancelled").Inc(1)
+		return fmt.Sprintf("Order %s cancelled after payment (%s)", orderID, status.LastError), nil
+	}
+
 	// Step 4: Process Payment with typed errors (Lesson 5)
-	status.Stage = "payment"
-	err = workflow.ExecuteActivity(ctx, "ProcessPayment", orderID).Get(ctx, nil)
+	setStage("payment")
+	logger.Info("Charging order", "orderID", orderID, "subtotal", status.Total(), "discount", status.DiscountAmount, "tax", status.TaxAmount, "shipping", status.ShippingCost, "total", status.GrandTotal())
+	// Generate the idempotency key once; replays reuse the recorded key
+	idempotencyKey, err := newID(ctx, "pay")
 	if err != nil {
+		return fail(err)
+	}
+
+	// Convert the grand total into the settlement currency. The configured currency is
+	// recorded with SideEffect so a worker restarted with a different setting still replays.
+	var settlementCurrency string
+	err = workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		return SettlementCurrency
+	}).Get(&settlementCurrency)
+	if err != nil {
+		return fail(err)
+	}
+	status.OriginalAmount = status.GrandTotal()
+	status.OriginalCurrency = orderCurrency(status.Items)
+	status.SettlementCurrency = settlementCurrency
+	err = runActivity(withProfile(ctx, ProfileFastReadOnly), &status, activities.ActivityConvert, &status.SettlementAmount, status.OriginalAmount, status.OriginalCurrency, settlementCurrency)
+	if err != nil {
+		status.LastError = fmt.Sprintf("currency conversion failed: %v", err)
+		saga.Compensate(ctx)
+		return fail(err)
+	}
+	logger.Info("Settlement amount", "orderID", orderID, "amount", status.SettlementAmount, "currency", settlementCurrency)
+
+	if lateCancel != nil {
+		return compensateLateCancel()
+	}
+
+	paymentReq := types.PaymentRequest{
+		OrderID:        orderID,
+		IdempotencyKey: idempotencyKey,
+		Amount:         status.SettlementAmount,
+		Currency:       settlementCurrency,
+		Method:         paymentMethod,
+	}
+	// Critical write on the payment task queue, retried by the gateway-specific policy
+	paymentCtx := withPaymentTaskQueue(withProfile(ctx, ProfileCriticalWrite))
+	paymentCtx = workflow.WithRetryPolicy(paymentCtx, *paymentRetryPolicy())
+	var receipt types.PaymentReceipt
+	processPayment := func(ctx workflow.Context) error {
+		return runActivity(ctx, &status, activities.ActivityProcessPayment, &receipt, paymentReq)
+	}
+	if workflow.GetVersion(ctx, "payment-budget-schedule-to-close", workflow.DefaultVersion, 1) >= 1 {
+		// Cancelling ProcessPayment from a stage timer could not stop a charge in
+		// flight, as it doesn't heartbeat, and the order would fail with the card
+		// charged. The budget is the activity's ScheduleToClose instead: the
+		// worker's deadline stops the gateway call before it charges.
+		if budgets.Payment > 0 {
+			paymentCtx = workflow.WithScheduleToCloseTimeout(paymentCtx, budgets.Payment)
+		}
+		err = processPayment(paymentCtx)
+		var timeoutErr *temporal.TimeoutError
+		if errors.As(err, &timeoutErr) && timeoutErr.TimeoutType() == enums.TIMEOUT_TYPE_SCHEDULE_TO_CLOSE {
+			logger.Warn("Stage exceeded its budget", "stage", "payment", "budget", budgets.Payment)
+			err = &types.StageTimeoutError{Stage: "payment", Budget: budgets.Payment}
+		}
+	} else {
+		err = withStageTimeout(paymentCtx, "payment", budgets.Payment, processPayment)
+	}
+	if err != nil {
+		saga.Compensate(ctx)
+
+		// A decline is a business outcome, not an outage: surface it as its own error type
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) && appErr.Type() == "PermanentError" {
+			// Over the max-charge limit nothing was charged: cancel the order instead
+			if strings.HasPrefix(appErr.Message(), activities.AmountExceedsLimit) {
+				status.Cancelled = true
+				status.CancellationReason = types.ReasonAmountExceedsLimit
+				status.LastError = fmt.Sprintf("cancelled: %s", appErr.Message())
+				logger.Warn("Payment over the charge limit, cancelling order", "orderID", orderID, "amount", paymentReq.Amount, "currency", paymentReq.Currency)
+				sendCancellationEmail()
+				setStage("cancelled")
+				publishEvent(types.OrderEvent{Type: types.EventOrderCancelled, CancellationReason: status.CancellationReason})
+				workflow.GetMetricsHandler(ctx).Counter("order_cancelled").Inc(1)
+				return fmt.Sprintf("Order %s cancelled (%s)", orderID, status.LastError), nil
+			}
+			status.LastError = fmt.Sprintf("payment declined: %s", appErr.Message())
+			return fail(&types.PaymentDeclinedError{OrderID: orderID, Reason: appErr.Message()})
+		}
 		status.LastError = fmt.Sprintf("payment failed: %v", err)
-		logger.Error("Payment failed", "error", err)
-		// Compensation - release stock
-		_ = workflow.ExecuteActivity(ctx, "ReleaseStock", orderID).Get(ctx, nil)
-		return "", err
+		return fail(err)
 	}
 	status.Charged = true
-	logger.Info("Payment processed", "orderID", orderID)
+	status.PaymentReceipt = &receipt
+	saga.AddCompensation(activities.ActivityRefundPayment, func(ctx workflow.Context) error {
+		ctx = withPaymentTaskQueue(withProfile(ctx, ProfileCriticalWrite))
+		return runActivity(ctx, &status, activities.ActivityRefundPayment, nil, orderID, receipt.TransactionID)
+	})
+	logger.Info("Payment processed", "orderID", orderID, "amount", paymentReq.Amount, "currency", paymentReq.Currency, "transactionID", receipt.TransactionID)
+	publishEvent(types.OrderEvent{
+		Type:          types.EventPaymentCharged,
+		Amount:        paymentReq.Amount,
+		Currency:      paymentReq.Currency,
+		TransactionID: receipt.TransactionID,
+	})
+	if lateCancel != nil {
+		return compensateLateCancel()
+	}
 
-	// Step 5: Update Order Status
-	status.Stage = "status-update"
-	err = workflow.ExecuteActivity(ctx, "UpdateOrderStatus", orderID, "COMPLETED").Get(ctx, nil)
+	// Step 5: Create Shipment via child workflow; cancelling the order cancels the shipment
+	setStage("shipping")
+	cancelShipment := func(ctx workflow.Context) error {
+		return runActivity(withProfile(ctx, ProfileCriticalWrite), &status, activities.ActivityCancelShipment, nil, orderID)
+	}
+	childCtx := workflow.WithChildOptions(fulfillCtx, workflow.ChildWorkflowOptions{
+		WorkflowID:        "shipment-" + orderID,
+		ParentClosePolicy: enums.PARENT_CLOSE_POLICY_REQUEST_CANCEL,
+	})
+	var trackingNumber string
+	err = workflow.ExecuteChildWorkflow(childCtx, ShipmentWorkflow, orderID, status.Items).Get(ctx, &trackingNumber)
+	if lateCancel != nil {
+		// The child may have registered the shipment before the cancel reached it
+		saga.AddCompensation(activities.ActivityCancelShipment, cancelShipment)
+		return compensateLateCancel()
+	}
 	if err != nil {
-		status.LastError = fmt.Sprintf("status update failed: %v", err)
-		logger.Error("Status update failed", "error", err)
+		status.LastError = fmt.Sprintf("shipment failed: %v", err)
+		logger.Error("Shipment creation failed", "error", err)
 		// Compensation - refund and release
-		_ = workflow.ExecuteActivity(ctx, "RefundPayment", orderID).Get(ctx, nil)
-		_ = workflow.ExecuteActivity(ctx, "ReleaseStock", orderID).Get(ctx, nil)
-		return "", err
+		saga.Compensate(ctx)
+		return fail(err)
 	}
+	saga.AddCompensation(activities.ActivityCancelShipment, cancelShipment)
+	status.TrackingNumber = trackingNumber
+	logger.Info("Shipment created", "orderID", orderID, "trackingNumber", trackingNumber)
 
-	// Step 6: Send Confirmation (non-critical)
-	status.Stage = "notify"
-	err = workflow.ExecuteActivity(ctx, "SendOrderConfirmation", orderID, "customer@example.com").Get(ctx, nil)
+	// Step 6: Update Order Status
+	setStage("status-update")
+	err = withStageTimeout(fulfillCtx, "status-update", budgets.StatusUpdate, func(ctx workflow.Context) error {
+		return runActivity(withProfile(ctx, ProfileCriticalWrite), &status, activities.ActivityUpdateOrderStatus, nil, orderID, "COMPLETED")
+	})
+	if lateCancel != nil {
+		return compensateLateCancel()
+	}
 	if err != nil {
-		// Non-critical failure - log but continue
+		status.LastError = fmt.Sprintf("status update failed: %v", err)
+		// Compensation - cancel shipment, refund and release
+		saga.Compensate(ctx)
+		return fail(err)
+	}
+
+	// Step 7: Send Confirmation (non-critical)
+	setStage("notify")
+	err = sendOrderConfirmation(ctx, &status)
+	if err != nil {
+		// Non-critical failure, already logged - continue
 		status.LastError = fmt.Sprintf("confirmation failed: %v", err)
-		logger.Warn("Confirmation email failed", "error", err)
 	}
 
-	status.Stage = "completed"
-	result := fmt.Sprintf("Order %s completed (version %s)", orderID, status.Version)
+	setStage("completed")
+	publishEvent(types.OrderEvent{
+		Type:           types.EventOrderCompleted,
+		Amount:         status.SettlementAmount,
+		Currency:       status.SettlementCurrency,
+		TransactionID:  status.TransactionID(),
+		TrackingNumber: status.TrackingNumber,
+	})
+
+	// Completion webhook (non-critical). The URL is recorded with SideEffect so a
+	// worker restarted with a different setting still replays.
+	if workflow.GetVersion(ctx, "completion-webhook", workflow.DefaultVersion, 1) >= 1 {
+		var webhookURL string
+		err = workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+			return CompletionWebhookURL
+		}).Get(&webhookURL)
+		if err == nil && webhookURL != "" {
+			if err := runActivity(withProfile(ctx, ProfileExternalIO), &status, activities.ActivityNotifyCompletion, nil, webhookURL, status); err != nil {
+				logger.Warn("Completion webhook failed", "orderID", orderID, "error", err)
+			}
+		}
+	}
+
+	result := fmt.Sprintf("Order %s completed (version %s, %d items backordered, subtotal %.2f, discount %.2f, tax %.2f, shipping %.2f, total %.2f, transaction %s)",
+		orderID, status.Version, totalQuantity(status.BackorderedItems), status.Total(), status.DiscountAmount, status.TaxAmount, status.ShippingCost, status.GrandTotal(), status.TransactionID())
 	logger.Info("Workflow completed", "orderID", orderID)
+	// Metrics handler from the workflow context is replay-safe
+	workflow.GetMetricsHandler(ctx).Counter("order_completed").Inc(1)
 
 	return result, nil
 }
+
+// OrderWorkflowShim is registered as "OrderWorkflow" so runs started before
+// OrderInput still replay. Those carry the positional arguments (orderID,
+// items, resume, gift, paymentMethod): the order ID decodes into input and the
+// rest into the trailing parameters, which are folded into input. Runs started
+// with an OrderInput leave the trailing parameters nil, and continue-as-new
+// always passes an OrderInput. The shim can be replaced by OrderWorkflow once
+// no run started with positional arguments is open.
+func OrderWorkflowShim(ctx workflow.Context, input types.OrderInput, items []types.LineItem, resume *types.OrderWorkflowStatus,
+	gift *types.GiftInfo, paymentMethod *types.PaymentMethod) (string, error) {
+	if items != nil {
+		input.Items = items
+	}
+	if resume != nil {
+		input.Resume = resume
+	}
+	if gift != nil {
+		input.Gift = gift
+	}
+	if paymentMethod != nil {
+		input.PaymentMethod = paymentMethod
+	}
+	return OrderWorkflow(ctx, input)
+}
+
+// sendOrderConfirmation resolves the customer's email into status and sends
+// the confirmation. Histories recorded before FetchCustomerEmail existed
+// confirm to the old placeholder address. A customer who opted out of email
+// gets no confirmation, and one whose preferences can't be read is not sent
+// one either; histories recorded before FetchNotificationPrefs always confirm.
+func sendOrderConfirmation(ctx workflow.Context, status *types.OrderWorkflowStatus) error {
+	if workflow.GetVersion(ctx, "notification-prefs", workflow.DefaultVersion, 1) >= 1 {
+		var prefs types.NotificationPrefs
+		if err := runActivity(withProfile(ctx, ProfileFastReadOnly), status, activities.ActivityFetchNotificationPrefs, &prefs, status.OrderID); err != nil {
+			return err
+		}
+		if !prefs.EmailOptIn {
+			workflow.GetLogger(ctx).Info("Notification skipped, customer opted out of email", "orderID", status.OrderID)
+			status.NotificationSkipped = true
+			return nil
+		}
+	}
+
+	email := "customer@example.com"
+	if workflow.GetVersion(ctx, "customer-email", workflow.DefaultVersion, 1) >= 1 {
+		if err := runActivity(withProfile(ctx, ProfileFastReadOnly), status, activities.ActivityFetchCustomerEmail, &status.CustomerEmail, status.OrderID); err != nil {
+			return err
+		}
+		email = status.CustomerEmail
+	}
+	return runActivity(withProfile(ctx, ProfileExternalIO), status, activities.ActivitySendOrderConfirmation, nil,
+		status.OrderID, email, status.SettlementAmount, status.SettlementCurrency, status.Gift)
+}
+
+// defaultRetryPolicy is the activity retry policy for the order workflows: the
+// shared course policy plus the order-specific business errors
+func defaultRetryPolicy() *temporal.RetryPolicy {
+	policy := retry.DefaultPolicy()
+	policy.NonRetryableErrorTypes = append(policy.NonRetryableErrorTypes, "InsufficientInventoryError")
+	return policy
+}
+
+// paymentRetryPolicy retries PaymentTransientError (gateway timeouts) longer and
+// with a slower backoff than other activities, since gateway outages tend to last
+// longer than a few seconds. Declines (PermanentError) and bad requests
+// (ValidationError) stop on the first attempt.
+func paymentRetryPolicy() *temporal.RetryPolicy {
+	return &temporal.RetryPolicy{
+		InitialInterval:        2 * time.Second,
+		BackoffCoefficient:     2.0,
+		MaximumInterval:        1 * time.Minute,
+		MaximumAttempts:        10,
+		NonRetryableErrorTypes: append([]string(nil), retry.NonRetryableErrorTypes...),
+	}
+}
+
+// failureType names an error for FailureDetail: the ApplicationError type for
+// activity and child failures, otherwise the Go type (e.g. "PaymentDeclinedError")
+func failureType(err error) string {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.Type() != "" {
+		return appErr.Type()
+	}
+	t := reflect.TypeOf(err)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// approvalTimeoutForTier returns how long a customer of the given tier has to approve payment
+func approvalTimeoutForTier(tier string) time.Duration {
+	switch tier {
+	case "Platinum":
+		return 1 * time.Hour
+	case "Gold":
+		return 30 * time.Minute
+	case "Silver":
+		return 15 * time.Minute
+	case "Bronze":
+		return 10 * time.Minute
+	default:
+		return 15 * time.Minute
+	}
+}
+
+// approvalTimeRemaining returns how long until the approval deadline auto-cancels
+// the order. It is zero outside the awaiting-approval stage, when no deadline has
+// been set, or once the deadline has passed. It stands still while the order is
+// paused.
+func approvalTimeRemaining(status types.OrderWorkflowStatus, now time.Time) time.Duration {
+	if status.Stage != "awaiting-approval" || status.ApprovalDeadline.IsZero() {
+		return 0
+	}
+	if status.Paused {
+		now = status.PausedAt
+	}
+	if remaining := status.ApprovalDeadline.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// validateShippingAddressUpdate rejects address updates that are malformed or
+// arrive once the order has been handed to the carrier
+func validateShippingAddressUpdate(stage string, address types.ShippingAddress) error {
+	switch stage {
+	case "shipping", "status-update", "notify", "completed", "cancelled":
+		return fmt.Errorf("shipping address can no longer be changed (stage: %s)", stage)
+	}
+	return address.Validate()
+}
+
+// splitByAvailability divides items into the quantities that can be fulfilled
+// from the availability snapshot and the remainder that must be backordered.
+// A line item may be split across both sets when stock is only partially available.
+func splitByAvailability(items []types.LineItem, availability map[string]int) (fulfillable, backordered []types.LineItem) {
+	remaining := make(map[string]int, len(availability))
+	for sku, qty := range availability {
+		remaining[sku] = qty
+	}
+
+	for _, item := range items {
+		available := remaining[item.SKU]
+		if available > item.Quantity {
+			available = item.Quantity
+		}
+		remaining[item.SKU] -= available
+
+		if available > 0 {
+			fulfilled := item
+			fulfilled.Quantity = available
+			fulfillable = append(fulfillable, fulfilled)
+		}
+		if short := item.Quantity - available; short > 0 {
+			pending := item
+			pending.Quantity = short
+			backordered = append(backordered, pending)
+		}
+	}
+	return fulfillable, backordered
+}
+
+// skus returns the distinct SKUs of the given line items in order of appearance
+func skus(items []types.LineItem) []string {
+	seen := make(map[string]bool, len(items))
+	var result []string
+	for _, item := range items {
+		if !seen[item.SKU] {
+			seen[item.SKU] = true
+			result = append(result, item.SKU)
+		}
+	}
+	return result
+}
+
+// rankRecommendations returns the n highest-scored candidates, ties broken by
+// SKU so the result does not depend on the order the activity listed them in
+func rankRecommendations(candidates []types.Recommendation, n int) []types.Recommendation {
+	ranked := slices.Clone(candidates)
+	slices.SortFunc(ranked, func(a, b types.Recommendation) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.SKU, b.SKU)
+	})
+	return ranked[:min(n, len(ranked))]
+}
+
+// pickRecommendations selects n random candidates. The random choice is made
+// inside SideEffect so replays reuse the recorded selection. Only runs started
+// before rankRecommendations still take this path.
+func pickRecommendations(ctx workflow.Context, candidates []types.Recommendation, n int) ([]types.Recommendation, error) {
+	if len(candidates) <= n {
+		return candidates, nil
+	}
+	var picked []types.Recommendation
+	err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		selection := make([]types.Recommendation, 0, n)
+		for _, i := range rand.Perm(len(candidates))[:n] {
+			selection = append(selection, candidates[i])
+		}
+		return selection
+	}).Get(&picked)
+	return picked, err
+}
+
+// removeLineItem takes req.Quantity units of req.SKU off the items, newest
+// entries first (0 removes the SKU entirely). It returns the remaining items
+// and the number of units removed.
+func removeLineItem(items []types.LineItem, req types.LineItem) ([]types.LineItem, int) {
+	toRemove := req.Quantity
+	if toRemove <= 0 {
+		toRemove = quantityOf(items, req.SKU)
+	}
+	remaining := append([]types.LineItem(nil), items...)
+	removed := 0
+	for i := len(remaining) - 1; i >= 0 && removed < toRemove; i-- {
+		if remaining[i].SKU != req.SKU {
+			continue
+		}
+		take := min(remaining[i].Quantity, toRemove-removed)
+		remaining[i].Quantity -= take
+		removed += take
+	}
+
+	kept := remaining[:0]
+	for _, item := range remaining {
+		if item.Quantity > 0 {
+			kept = append(kept, item)
+		}
+	}
+	return kept, removed
+}
+
+// quantityOf sums the quantity of sku across the items
+func quantityOf(items []types.LineItem, sku string) int {
+	total := 0
+	for _, item := range items {
+		if item.SKU == sku {
+			total += item.Quantity
+		}
+	}
+	return total
+}
+
+// orderCurrency returns the currency the items are priced in, defaulting to USD
+func orderCurrency(items []types.LineItem) string {
+	for _, item := range items {
+		if item.Currency != "" {
+			return item.Currency
+		}
+	}
+	return "USD"
+}
+
+// totalQuantity sums the quantities of the given line items
+func totalQuantity(items []types.LineItem) int {
+	total := 0
+	for _, item := range items {
+		total += item.Quantity
+	}
+	return total
+}
@@ -0,0 +1,724 @@
+package workflows_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/testutil"
+	"go-temporal-fast-course/order-processing/types"
+	"go-temporal-fast-course/order-processing/workflows"
+)
+
+var (
+	book    = types.LineItem{SKU: "BOOK-001", Quantity: 1, UnitPrice: 24.99, Currency: "USD"}
+	address = types.ShippingAddress{Street: "1 Main", City: "Springfield", PostalCode: "12345", Country: "US"}
+)
+
+// updateCallbacks fails the test if the update is rejected or fails
+type updateCallbacks struct {
+	t testing.TB
+}
+
+func (c updateCallbacks) Accept() {}
+
+func (c updateCallbacks) Reject(err error) {
+	c.t.Errorf("update rejected: %v", err)
+}
+
+func (c updateCallbacks) Complete(_ interface{}, err error) {
+	if err != nil {
+		c.t.Errorf("update failed: %v", err)
+	}
+}
+
+// shipAndApprove sets the shipping address after a minute and approves the
+// payment as manager after two
+func shipAndApprove(t testing.TB, env *testsuite.TestWorkflowEnvironment) {
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow("update-shipping-address", "address-1", updateCallbacks{t}, address)
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("approve-payment", types.PaymentApproval{ApprovedBy: "manager"})
+	}, 2*time.Minute)
+}
+
+// runOrder executes OrderWorkflow for items and returns its result
+func runOrder(t testing.TB, env *testsuite.TestWorkflowEnvironment, items ...types.LineItem) (string, error) {
+	return runOrderInput(t, env, types.OrderInput{OrderID: "ORDER-1", Items: items})
+}
+
+// runOrderInput executes OrderWorkflow with input and returns its result
+func runOrderInput(t testing.TB, env *testsuite.TestWorkflowEnvironment, input types.OrderInput) (string, error) {
+	env.ExecuteWorkflow(workflows.OrderWorkflow, input)
+	require.True(t, env.IsWorkflowCompleted())
+	var result string
+	err := env.GetWorkflowResult(&result)
+	return result, err
+}
+
+// queryStatus returns the admin view of the workflow's status
+func queryStatus(t testing.TB, env *testsuite.TestWorkflowEnvironment) types.OrderWorkflowStatus {
+	t.Helper()
+	value, err := env.QueryWorkflow("get-status", types.RoleAdmin)
+	require.NoError(t, err)
+	var status types.OrderWorkflowStatus
+	require.NoError(t, value.Get(&status))
+	return status
+}
+
+func TestOrderWorkflowHappyPathCompletes(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t)
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+
+	status := queryStatus(t, env)
+	require.Equal(t, "completed", status.Stage)
+	require.True(t, status.Charged)
+	require.NotEmpty(t, status.TrackingNumber)
+}
+
+func TestOrderWorkflowCancelSignalReleasesStock(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t)
+	env.OnActivity(activities.ActivityReleaseStock, mock.Anything, "ORDER-1").Return(nil).Once()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("cancel-order", types.CancelRequest{Reason: types.ReasonCustomerRequested})
+	}, time.Minute)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "cancelled")
+
+	status := queryStatus(t, env)
+	require.Equal(t, "cancelled", status.Stage)
+	require.Contains(t, status.CompensationsRun, activities.ActivityReleaseStock)
+	env.AssertNotCalled(t, activities.ActivityProcessPayment, mock.Anything, mock.Anything)
+}
+
+func TestOrderWorkflowApprovalTimeoutCancels(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t)
+	env.OnActivity(activities.ActivityReleaseStock, mock.Anything, "ORDER-1").Return(nil).Once()
+
+	// Nothing is sent: the virtual clock runs to the approval deadline
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "cancelled")
+	require.Contains(t, result, types.ReasonTimeout.String())
+
+	status := queryStatus(t, env)
+	require.Equal(t, types.ReasonTimeout, status.CancellationReason)
+	env.AssertNotCalled(t, activities.ActivityProcessPayment, mock.Anything, mock.Anything)
+}
+
+func TestOrderWorkflowInsufficientInventoryFails(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityFetchInventorySnapshot, mock.Anything, mock.Anything).
+			Return(map[string]int{book.SKU: 0}, nil)
+	})
+
+	_, err := runOrder(t, env, book)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "insufficient inventory")
+	env.AssertNotCalled(t, activities.ActivityReserveStock, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Queries are answered between workflow tasks, so they don't wait for the
+// approval loop's Select to return
+func TestOrderWorkflowAnswersQueriesWhileAwaitingApproval(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t)
+	var blocked types.OrderWorkflowStatus
+	var remaining time.Duration
+	env.RegisterDelayedCallback(func() {
+		blocked = queryStatus(t, env)
+		value, err := env.QueryWorkflow("get-time-remaining")
+		require.NoError(t, err)
+		require.NoError(t, value.Get(&remaining))
+		env.SignalWorkflow("cancel-order", types.CancelRequest{Reason: types.ReasonCustomerRequested})
+	}, time.Minute)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "cancelled")
+	require.Equal(t, "awaiting-approval", blocked.Stage)
+	require.Equal(t, []types.LineItem{book}, blocked.Items)
+	require.Positive(t, remaining)
+}
+
+// An item added after the reservation is reserved on its own, and the
+// cancellation compensation releases it along with the original items
+func TestOrderWorkflowReservesAndReleasesItemAddedAfterReservation(t *testing.T) {
+	warehouses := activities.DemoWarehouses()
+	inventory := activities.NewInventoryActivities(warehouses, activities.FailureConfig{})
+	inventory.Latency = activities.Latency{Sleep: activities.NoSleep}
+	pens := types.LineItem{SKU: "PEN-042", Quantity: 2, UnitPrice: 1.5, Currency: "USD"}
+	onHand := func() map[string]int {
+		return warehouses[activities.PrimaryWarehouse].Available([]types.LineItem{book, pens})
+	}
+
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityReserveStock, mock.Anything, "ORDER-1", []types.LineItem{book}, mock.Anything).
+			Return(inventory.ReserveStock).Once()
+		env.OnActivity(activities.ActivityReserveStock, mock.Anything, "ORDER-1", []types.LineItem{pens}, mock.Anything).
+			Return(inventory.ReserveStock).Once()
+		env.OnActivity(activities.ActivityReleaseStock, mock.Anything, "ORDER-1").
+			Return(inventory.ReleaseStock).Once()
+	})
+	var afterAdd map[string]int
+	var reserved []types.LineItem
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("add-line-item", pens)
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		afterAdd = onHand()
+		reserved = queryStatus(t, env).ReservedItems
+		env.SignalWorkflow("cancel-order", types.CancelRequest{Reason: types.ReasonCustomerRequested})
+	}, 2*time.Minute)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "cancelled")
+	require.Equal(t, map[string]int{book.SKU: 99, pens.SKU: 498}, afterAdd)
+	require.Equal(t, []types.LineItem{book, pens}, reserved)
+	require.Equal(t, map[string]int{book.SKU: 100, pens.SKU: 500}, onHand())
+}
+
+// Only the admin role sees the emails and the payment receipt
+func TestOrderWorkflowRedactsStatusForCustomerRole(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t)
+	shipAndApprove(t, env)
+
+	gift := &types.GiftInfo{RecipientEmail: "friend@example.com", Message: "Enjoy"}
+	_, err := runOrderInput(t, env, types.OrderInput{OrderID: "ORDER-1", Items: []types.LineItem{book}, Gift: gift})
+	require.NoError(t, err)
+
+	admin := queryStatus(t, env)
+	require.NotEmpty(t, admin.CustomerEmail)
+	require.Equal(t, "TXN-ORDER-1", admin.TransactionID())
+	require.Equal(t, "friend@example.com", admin.Gift.RecipientEmail)
+
+	value, err := env.QueryWorkflow("get-status", "customer")
+	require.NoError(t, err)
+	var customer types.OrderWorkflowStatus
+	require.NoError(t, value.Get(&customer))
+	require.Empty(t, customer.CustomerEmail)
+	require.Nil(t, customer.PaymentReceipt)
+	require.Empty(t, customer.Gift.RecipientEmail)
+	require.Equal(t, "Enjoy", customer.Gift.Message)
+	require.Equal(t, admin.Stage, customer.Stage)
+	require.Equal(t, admin.TrackingNumber, customer.TrackingNumber)
+	require.True(t, customer.Charged)
+}
+
+// optedOut mocks the customer's preferences as opted out of email
+func optedOut(env *testsuite.TestWorkflowEnvironment) {
+	env.OnActivity(activities.ActivityFetchNotificationPrefs, mock.Anything, "ORDER-1").
+		Return(types.NotificationPrefs{EmailOptIn: false}, nil).Maybe()
+}
+
+func TestOrderWorkflowSkipsConfirmationWhenOptedOut(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, optedOut)
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+	require.True(t, queryStatus(t, env).NotificationSkipped)
+	env.AssertNotCalled(t, activities.ActivitySendOrderConfirmation, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	env.AssertNotCalled(t, activities.ActivityFetchCustomerEmail, mock.Anything, mock.Anything)
+}
+
+// Cancellation notices are legally required, so they go out despite the opt-out
+func TestOrderWorkflowSendsCancellationNoticeWhenOptedOut(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, optedOut)
+	env.OnActivity(activities.ActivitySendCancellationEmail, mock.Anything, "ORDER-1", mock.Anything).Return(nil).Once()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("cancel-order", types.CancelRequest{Reason: types.ReasonCustomerRequested})
+	}, time.Minute)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "cancelled")
+	require.Contains(t, queryStatus(t, env).CompensationsRun, activities.ActivitySendCancellationEmail)
+}
+
+// A total over the payment limit cancels the order without retrying the charge
+func TestOrderWorkflowCancelsOverChargeLimit(t *testing.T) {
+	payments := activities.NewPaymentActivities(0, nil, activities.FailureConfig{})
+	payments.Latency = activities.Latency{Sleep: activities.NoSleep}
+	payments.MaxCharge = 20
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityProcessPayment, mock.Anything, mock.Anything).Return(payments.ProcessPayment).Once()
+		env.OnActivity(activities.ActivityReleaseStock, mock.Anything, "ORDER-1").Return(nil).Once()
+	})
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "cancelled")
+	require.Contains(t, result, activities.AmountExceedsLimit)
+
+	status := queryStatus(t, env)
+	require.Equal(t, types.ReasonAmountExceedsLimit, status.CancellationReason)
+	require.False(t, status.Charged)
+}
+
+// The approval deadline is set from the customer tier found by enrichment
+func TestOrderWorkflowApprovalTimeoutByTier(t *testing.T) {
+	start := time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		tier string
+		want time.Duration
+	}{
+		{"Platinum", time.Hour},
+		{"Gold", 30 * time.Minute},
+		{"Silver", 15 * time.Minute},
+		{"Bronze", 10 * time.Minute},
+		{"Unknown", 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tier, func(t *testing.T) {
+			env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+				env.OnActivity(activities.ActivityFetchCustomerProfile, mock.Anything, "ORDER-1").Return(tt.tier, nil).Once()
+			})
+			env.SetStartTime(start)
+
+			result, err := runOrder(t, env, book)
+			require.NoError(t, err)
+			require.Contains(t, result, types.ReasonTimeout.String())
+
+			status := queryStatus(t, env)
+			require.Equal(t, tt.tier, status.Enrichment.CustomerTier)
+			require.Equal(t, tt.want, status.ApprovalDeadline.Sub(start))
+		})
+	}
+}
+
+// slowShipment makes the shipment child take ten minutes, so a cancel sent at
+// five arrives after the charge
+func slowShipment(env *testsuite.TestWorkflowEnvironment) {
+	env.OnWorkflow(workflows.ShipmentWorkflow, mock.Anything, "ORDER-1", mock.Anything).
+		After(10*time.Minute).Return("TRACK-1", nil)
+}
+
+// A forced cancel after the charge refunds the payment and releases the stock
+func TestOrderWorkflowForcedCancelAfterChargeRefunds(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, slowShipment, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityRefundPayment, mock.Anything, "ORDER-1", "TXN-ORDER-1").Return(nil).Once()
+		env.OnActivity(activities.ActivityReleaseStock, mock.Anything, "ORDER-1").Return(nil).Once()
+	})
+	shipAndApprove(t, env)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("cancel-order", types.CancelRequest{Reason: types.ReasonCustomerRequested, Force: true})
+	}, 5*time.Minute)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "cancelled after payment")
+
+	status := queryStatus(t, env)
+	require.True(t, status.Charged)
+	require.Equal(t, types.ReasonCustomerRequested, status.CancellationReason)
+	// The cancel reached the shipment in flight, so it is cancelled first
+	require.Equal(t, []string{
+		activities.ActivityCancelShipment,
+		activities.ActivityRefundPayment,
+		activities.ActivityReleaseStock,
+		activities.ActivitySendCancellationEmail,
+	}, status.CompensationsRun)
+}
+
+// Without Force a cancel after the charge is ignored and the order completes
+func TestOrderWorkflowIgnoresUnforcedCancelAfterCharge(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, slowShipment)
+	shipAndApprove(t, env)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("cancel-order", types.CancelRequest{Reason: types.ReasonCustomerRequested})
+	}, 5*time.Minute)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+	require.Equal(t, "TRACK-1", queryStatus(t, env).TrackingNumber)
+	env.AssertNotCalled(t, activities.ActivityRefundPayment, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Removing part of an added item leaves the rest on the order and releases only
+// the units removed; unknown SKUs and the last item can't be removed
+func TestOrderWorkflowAddThenRemoveItem(t *testing.T) {
+	pens := types.LineItem{SKU: "PEN-042", Quantity: 3, UnitPrice: 1.5, Currency: "USD"}
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityReleaseStockItems, mock.Anything, "ORDER-1", []types.LineItem{{SKU: "PEN-042", Quantity: 1}}).
+			Return(nil).Once()
+	})
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("add-line-item", pens)
+	}, 10*time.Second)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("remove-line-item", types.LineItem{SKU: "PEN-042", Quantity: 1})
+	}, 20*time.Second)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("remove-line-item", types.LineItem{SKU: "NOT-IN-ORDER"})
+	}, 30*time.Second)
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+
+	remainingPens := pens
+	remainingPens.Quantity = 2
+	status := queryStatus(t, env)
+	require.Equal(t, []types.LineItem{book, remainingPens}, status.Items)
+	require.Equal(t, []types.LineItem{book, remainingPens}, status.ReservedItems)
+}
+
+func TestOrderWorkflowKeepsLastItem(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("remove-line-item", types.LineItem{SKU: book.SKU})
+	}, 10*time.Second)
+	shipAndApprove(t, env)
+
+	_, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Equal(t, []types.LineItem{book}, queryStatus(t, env).Items)
+	env.AssertNotCalled(t, activities.ActivityReleaseStockItems, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Gateway timeouts are retried until the charge goes through
+func TestOrderWorkflowRetriesTransientPaymentErrors(t *testing.T) {
+	attempts := 0
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityProcessPayment, mock.Anything, mock.Anything).
+			Return(func(_ context.Context, req types.PaymentRequest) (types.PaymentReceipt, error) {
+				attempts++
+				if attempts < 3 {
+					return types.PaymentReceipt{}, &types.PaymentTransientError{Msg: "gateway timeout"}
+				}
+				return types.PaymentReceipt{TransactionID: "TXN-RETRIED", Amount: req.Amount}, nil
+			})
+	})
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+	require.Equal(t, 3, attempts)
+	require.Equal(t, "TXN-RETRIED", queryStatus(t, env).TransactionID())
+}
+
+// A decline is not retried and fails the order with PaymentDeclinedError
+func TestOrderWorkflowStopsOnPaymentDecline(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityProcessPayment, mock.Anything, mock.Anything).
+			Return(types.PaymentReceipt{}, &types.PermanentError{Msg: "card declined"}).Once()
+		env.OnActivity(activities.ActivityReleaseStock, mock.Anything, "ORDER-1").Return(nil).Once()
+	})
+	shipAndApprove(t, env)
+
+	_, err := runOrder(t, env, book)
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr), "%T: %v", err, err)
+	require.Equal(t, "PaymentDeclinedError", appErr.Type())
+	require.Contains(t, appErr.Error(), "payment declined for order ORDER-1: card declined")
+
+	status := queryStatus(t, env)
+	require.False(t, status.Charged)
+	require.Equal(t, "PaymentDeclinedError", status.LastFailure.Type)
+}
+
+// A high-value order waits for two distinct approvers; a repeat approval
+// by the same person doesn't count
+func TestOrderWorkflowRequiresTwoApprovers(t *testing.T) {
+	previous := workflows.HighValueApprovalPolicy
+	workflows.HighValueApprovalPolicy = types.ApprovalPolicy{HighValueThreshold: 20, HighValueApprovals: 2}
+	t.Cleanup(func() { workflows.HighValueApprovalPolicy = previous })
+
+	env := testutil.NewOrderTestEnv(t)
+	shipAndApprove(t, env)
+	var afterRepeat types.ApprovalStatus
+	var stageAfterRepeat string
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("approve-payment", types.PaymentApproval{ApprovedBy: "manager"})
+	}, 3*time.Minute)
+	env.RegisterDelayedCallback(func() {
+		value, err := env.QueryWorkflow("get-approvals")
+		require.NoError(t, err)
+		require.NoError(t, value.Get(&afterRepeat))
+		stageAfterRepeat = queryStatus(t, env).Stage
+		env.SignalWorkflow("approve-payment", types.PaymentApproval{ApprovedBy: "finance"})
+	}, 4*time.Minute)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+	require.Equal(t, types.ApprovalStatus{Approvers: []string{"manager"}, Required: 2, Remaining: 1}, afterRepeat)
+	require.Equal(t, "awaiting-approval", stageAfterRepeat)
+	require.Equal(t, []string{"manager", "finance"}, queryStatus(t, env).Approvers)
+}
+
+// An approval without ApprovedBy is ignored and the order keeps waiting until
+// a named approver signs off
+func TestOrderWorkflowIgnoresAnonymousApproval(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t)
+	shipAndApprove(t, env)
+	var afterAnonymous types.OrderWorkflowStatus
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("approve-payment", types.PaymentApproval{ApprovedBy: "  "})
+	}, 30*time.Second)
+	env.RegisterDelayedCallback(func() {
+		afterAnonymous = queryStatus(t, env)
+	}, 90*time.Second)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+	require.Equal(t, "awaiting-approval", afterAnonymous.Stage)
+	require.Empty(t, afterAnonymous.Approvers)
+	require.Equal(t, []string{"manager"}, queryStatus(t, env).Approvers)
+}
+
+// A gift order confirms to the buyer and to the recipient, or only to the
+// recipient when there is no address for the buyer
+func TestOrderWorkflowConfirmsGift(t *testing.T) {
+	gift := &types.GiftInfo{RecipientEmail: "friend@example.com", Message: "Enjoy"}
+	buyerMessage := "Order ORDER-1 confirmed, charged 32.14 USD (gift for friend@example.com)"
+	recipientMessage := "A gift is on its way to you (order ORDER-1). Note from the sender: Enjoy"
+	tests := []struct {
+		name       string
+		buyerEmail string
+		want       []activities.SentNotification
+	}{
+		{"buyer and recipient", "jane@example.com", []activities.SentNotification{
+			{Recipient: "jane@example.com", Message: buyerMessage},
+			{Recipient: "friend@example.com", Message: recipientMessage},
+		}},
+		{"recipient only", "", []activities.SentNotification{
+			{Recipient: "friend@example.com", Message: recipientMessage},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := &activities.FakeChannel{}
+			notifications := &activities.NotificationActivities{
+				Channels:    map[string]activities.NotificationChannel{"email": email},
+				Preferences: func(string) types.UserPreferences { return types.UserPreferences{Channel: "email"} },
+			}
+			env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+				env.OnActivity(activities.ActivityFetchCustomerEmail, mock.Anything, "ORDER-1").Return(tt.buyerEmail, nil)
+				env.OnActivity(activities.ActivitySendOrderConfirmation, mock.Anything, "ORDER-1", tt.buyerEmail, mock.Anything, mock.Anything, gift).
+					Return(notifications.SendOrderConfirmation).Once()
+			})
+			shipAndApprove(t, env)
+
+			result, err := runOrderInput(t, env, types.OrderInput{OrderID: "ORDER-1", Items: []types.LineItem{book}, Gift: gift})
+			require.NoError(t, err)
+			require.Contains(t, result, "completed")
+			require.Equal(t, tt.want, email.Messages())
+		})
+	}
+}
+
+// Each stage in the history lists the activities that ran in it
+func TestOrderWorkflowRecordsActivitiesPerStage(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t)
+	shipAndApprove(t, env)
+
+	_, err := runOrder(t, env, book)
+	require.NoError(t, err)
+
+	status := queryStatus(t, env)
+	ran := make(map[string][]string)
+	total := 0
+	for _, entry := range status.History {
+		for _, run := range entry.Activities {
+			ran[entry.Stage] = append(ran[entry.Stage], run.Name)
+			require.False(t, run.EndedAt.Before(run.StartedAt), "%s in %s", run.Name, entry.Stage)
+			total++
+		}
+	}
+	require.Contains(t, ran["reserve"], activities.ActivityReserveStock)
+	require.Contains(t, ran["payment"], activities.ActivityProcessPayment)
+	require.Contains(t, ran["status-update"], activities.ActivityUpdateOrderStatus)
+	require.Contains(t, ran["notify"], activities.ActivitySendOrderConfirmation)
+	require.Positive(t, total)
+	require.LessOrEqual(t, total, status.ActivitiesExecuted)
+}
+
+// A reservation slower than the reserve budget fails the order with a
+// StageTimeoutError before any payment is attempted
+func TestOrderWorkflowReserveStageTimesOut(t *testing.T) {
+	previous := workflows.OrderStageBudgets
+	workflows.OrderStageBudgets.Reserve = 30 * time.Second
+	t.Cleanup(func() { workflows.OrderStageBudgets = previous })
+
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityReserveStock, mock.Anything, "ORDER-1", mock.Anything, mock.Anything).
+			After(time.Hour).Return(types.ReservationResult{}, nil)
+	})
+
+	_, err := runOrder(t, env, book)
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr), "%T: %v", err, err)
+	require.Equal(t, "StageTimeoutError", appErr.Type())
+	require.Contains(t, appErr.Error(), "stage reserve exceeded its 30s budget")
+
+	status := queryStatus(t, env)
+	require.False(t, status.Reserved)
+	require.Contains(t, status.LastError, "reserve failed")
+	env.AssertNotCalled(t, activities.ActivityProcessPayment, mock.Anything, mock.Anything)
+}
+
+// The payment budget is ProcessPayment's ScheduleToClose, not a stage timer:
+// a charge that returns after the budget (the mock ignores its deadline) is
+// recorded and refundable instead of failing an order whose card was charged
+func TestOrderWorkflowKeepsChargeThatLandsAfterPaymentBudget(t *testing.T) {
+	previous := workflows.OrderStageBudgets
+	workflows.OrderStageBudgets.Payment = 30 * time.Second
+	t.Cleanup(func() { workflows.OrderStageBudgets = previous })
+
+	var budget time.Duration
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityProcessPayment, mock.Anything, mock.Anything).After(time.Minute).
+			Return(func(ctx context.Context, req types.PaymentRequest) (types.PaymentReceipt, error) {
+				info := activity.GetInfo(ctx)
+				budget = info.Deadline.Sub(info.StartedTime)
+				return types.PaymentReceipt{TransactionID: "TXN-LATE", Amount: req.Amount}, nil
+			}).Once()
+	})
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+	require.Equal(t, 30*time.Second, budget)
+
+	status := queryStatus(t, env)
+	require.True(t, status.Charged)
+	require.Equal(t, "TXN-LATE", status.TransactionID())
+}
+
+// ProcessPayment timing out at the budget fails the order with a
+// StageTimeoutError; nothing was charged, so the stock is released and no
+// refund is attempted
+func TestOrderWorkflowPaymentTimeoutFailsWithoutCharge(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityProcessPayment, mock.Anything, mock.Anything).
+			Return(types.PaymentReceipt{}, temporal.NewTimeoutError(enumspb.TIMEOUT_TYPE_SCHEDULE_TO_CLOSE, nil)).Once()
+		env.OnActivity(activities.ActivityReleaseStock, mock.Anything, "ORDER-1").Return(nil).Once()
+	})
+	shipAndApprove(t, env)
+
+	_, err := runOrder(t, env, book)
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr), "%T: %v", err, err)
+	require.Equal(t, "StageTimeoutError", appErr.Type())
+	require.Contains(t, appErr.Error(), "stage payment exceeded its")
+
+	require.False(t, queryStatus(t, env).Charged)
+	env.AssertNotCalled(t, activities.ActivityRefundPayment, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// An order started without items fails validation before enrichment or
+// reservation
+func TestOrderWorkflowRejectsEmptyOrder(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t)
+
+	_, err := runOrder(t, env)
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr), "%T: %v", err, err)
+	require.Equal(t, "ValidationError", appErr.Type())
+	require.Contains(t, appErr.Error(), "order has no line items")
+
+	require.Contains(t, queryStatus(t, env).LastError, "invalid order")
+	env.AssertNotCalled(t, activities.ActivityFetchCustomerProfile, mock.Anything, mock.Anything)
+	env.AssertNotCalled(t, activities.ActivityReserveStock, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// A happy-path order publishes its lifecycle events in order, each stamped
+// with the order ID
+func TestOrderWorkflowPublishesLifecycleEvents(t *testing.T) {
+	broker := &activities.FakeBroker{}
+	events := &activities.EventActivities{Broker: broker}
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityPublishEvent, mock.Anything, mock.Anything, mock.Anything).Return(events.Publish)
+	})
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+
+	var sequence []types.OrderEventType
+	for _, published := range broker.Events() {
+		require.Equal(t, workflows.EventTopic, published.Topic)
+		require.Equal(t, "ORDER-1", published.Event.OrderID)
+		sequence = append(sequence, published.Event.Type)
+	}
+	require.Equal(t, []types.OrderEventType{
+		types.EventOrderStarted,
+		types.EventStockReserved,
+		types.EventPaymentCharged,
+		types.EventOrderCompleted,
+	}, sequence)
+}
+
+// Recommendations are best-effort: when they fail the order completes without
+// them and the lookup is listed as unavailable
+func TestOrderWorkflowCompletesWhenRecommendationsFail(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityFetchRecommendations, mock.Anything, "ORDER-1").
+			Return(nil, &types.PermanentError{Msg: "recommendation service unavailable"}).Once()
+	})
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+
+	status := queryStatus(t, env)
+	require.Empty(t, status.Enrichment.Recommendations)
+	require.Equal(t, []string{activities.ActivityFetchRecommendations}, status.Enrichment.Unavailable)
+	require.NotEmpty(t, status.Enrichment.CustomerTier)
+	require.True(t, status.Charged)
+}
+
+// A failed primary warehouse reservation falls back to the secondary
+// warehouse, which the order then ships from
+func TestOrderWorkflowFallsBackToSecondaryWarehouse(t *testing.T) {
+	warehouses := activities.DemoWarehouses()
+	inventory := activities.NewInventoryActivities(warehouses, activities.FailureConfig{})
+	inventory.Latency = activities.Latency{Sleep: activities.NoSleep}
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityReserveStock, mock.Anything, "ORDER-1", mock.Anything, activities.PrimaryWarehouse).
+			Return(types.ReservationResult{}, &types.PermanentError{Msg: "primary warehouse unreachable"}).Once()
+		env.OnActivity(activities.ActivityReserveStock, mock.Anything, "ORDER-1", mock.Anything, activities.SecondaryWarehouse).
+			Return(inventory.ReserveStock).Once()
+	})
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+
+	status := queryStatus(t, env)
+	require.Equal(t, activities.SecondaryWarehouse, status.Warehouse)
+	require.Equal(t, []types.LineItem{book}, status.ReservedItems)
+	onHand := func(warehouse string) int {
+		return warehouses[warehouse].Available([]types.LineItem{book})[book.SKU]
+	}
+	require.Equal(t, 99, onHand(activities.SecondaryWarehouse))
+	require.Equal(t, 100, onHand(activities.PrimaryWarehouse))
+}
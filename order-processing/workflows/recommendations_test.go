@@ -0,0 +1,53 @@
+package workflows
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+var candidates = []types.Recommendation{
+	{SKU: "PEN-042", Score: 0.4},
+	{SKU: "ITEM-999", Score: 0.9},
+	{SKU: "BOOK-002", Score: 0.7},
+	{SKU: "BOOK-001", Score: 0.7},
+	{SKU: "LAST-001", Score: 0.1},
+}
+
+// Ranking depends only on the candidates, not their order, so it replays the same
+func TestRankRecommendations(t *testing.T) {
+	want := []types.Recommendation{
+		{SKU: "ITEM-999", Score: 0.9},
+		{SKU: "BOOK-001", Score: 0.7},
+		{SKU: "BOOK-002", Score: 0.7},
+	}
+	require.Equal(t, want, rankRecommendations(candidates, 3))
+
+	reversed := make([]types.Recommendation, len(candidates))
+	for i, rec := range candidates {
+		reversed[len(candidates)-1-i] = rec
+	}
+	require.Equal(t, want, rankRecommendations(reversed, 3))
+	require.Len(t, rankRecommendations(candidates[:2], 3), 2)
+	require.Equal(t, "PEN-042", candidates[0].SKU, "candidates must not be reordered")
+}
+
+// A replay returns the selection recorded in the history instead of drawing again
+func TestPickRecommendationsReplaysRecordedSelection(t *testing.T) {
+	recorded := []types.Recommendation{candidates[4], candidates[0], candidates[2]}
+
+	var replayed []types.Recommendation
+	replayer := worker.NewWorkflowReplayer()
+	replayer.RegisterWorkflowWithOptions(func(ctx workflow.Context) ([]types.Recommendation, error) {
+		picked, err := pickRecommendations(ctx, candidates, 3)
+		replayed = picked
+		return picked, err
+	}, workflow.RegisterOptions{Name: "PickRecommendationsWorkflow"})
+
+	require.NoError(t, replayer.ReplayWorkflowHistory(nil, sideEffectHistory(t, "PickRecommendationsWorkflow", recorded)))
+	require.Equal(t, recorded, replayed)
+}
@@ -0,0 +1,20 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/activities"
+)
+
+// Register registers the order workflows and the activities in acts. The order
+// worker and the test harness both register through it, so tests run against
+// the same registrations as production.
+func Register(w worker.Registry, acts activities.Set) {
+	// The shim also replays runs started with the old positional arguments
+	w.RegisterWorkflowWithOptions(OrderWorkflowShim, workflow.RegisterOptions{Name: "OrderWorkflow"})
+	w.RegisterWorkflow(ShipmentWorkflow)
+	w.RegisterWorkflow(ReprocessOrderWorkflow)
+	w.RegisterWorkflow(InventoryLockWorkflow)
+	acts.Register(w)
+}
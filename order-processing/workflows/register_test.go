@@ -0,0 +1,101 @@
+package workflows_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/testutil"
+)
+
+// activityRecorder is a worker.ActivityRegistry that records the names registered
+type activityRecorder map[string]bool
+
+func (r activityRecorder) RegisterActivity(a interface{}) {
+	panic("activities must be registered under their activities.Activity* name")
+}
+
+func (r activityRecorder) RegisterActivityWithOptions(_ interface{}, options activity.RegisterOptions) {
+	r[options.Name] = true
+}
+
+// Every activity the workflows invoke through an activities.Activity* constant
+// must be registered by activities.Set.Register, which the worker and the test
+// harness share
+func TestEveryInvokedActivityIsRegistered(t *testing.T) {
+	registered := activityRecorder{}
+	testutil.TestActivities(nil, activities.FailureConfig{}).Register(registered)
+
+	names := activityNameConstants(t, filepath.Join("..", "activities", "names.go"))
+	invoked := invokedActivityConstants(t)
+	require.NotEmpty(t, invoked)
+	for _, constant := range invoked {
+		name, ok := names[constant]
+		require.True(t, ok, "activities.%s is not declared in names.go", constant)
+		require.True(t, registered[name], "activity %s (activities.%s) is invoked but not registered", name, constant)
+	}
+}
+
+// activityNameConstants returns the value of each string constant in the file
+func activityNameConstants(t *testing.T, path string) map[string]string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	require.NoError(t, err)
+	names := make(map[string]string)
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for i, ident := range spec.Names {
+			if lit, ok := spec.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				value, err := strconv.Unquote(lit.Value)
+				require.NoError(t, err)
+				names[ident.Name] = value
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// invokedActivityConstants returns the activities.Activity* constants the
+// workflow sources refer to
+func invokedActivityConstants(t *testing.T) []string {
+	t.Helper()
+	paths, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+		require.NoError(t, err)
+		ast.Inspect(file, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name == "activities" && strings.HasPrefix(sel.Sel.Name, "Activity") {
+				seen[sel.Sel.Name] = true
+			}
+			return true
+		})
+	}
+	constants := make([]string, 0, len(seen))
+	for constant := range seen {
+		constants = append(constants, constant)
+	}
+	sort.Strings(constants)
+	return constants
+}
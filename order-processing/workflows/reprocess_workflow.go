@@ -0,0 +1,215 @@
+package workflows
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// reprocessableStages are the stages an approved order can fail in ("reserve"
+// only when a reprocess itself failed). The failed run's compensations released
+// its stock and refunded any charge, so fulfilment always restarts from the
+// reservation.
+var reprocessableStages = []string{"reserve", "payment", "shipping", "status-update"}
+
+// ReprocessOrderWorkflow recovers an order whose OrderWorkflow failed after
+// payment approval, e.g. during an infrastructure outage. It loads the status
+// the failed run persisted (PersistStatus, so DB_DSN must be set), takes the
+// order over with MarkHandedOff so it cannot be reprocessed twice, and then
+// runs the fulfilment steps again: reserve, charge, ship, update status and
+// confirm. Enrichment, approval, tax, promo and currency conversion are not
+// redone; their results come from the persisted status.
+//
+// The status only keeps the masked payment method, so an order placed with
+// one must be given it again as paymentMethod; nil charges the default card on
+// file, and is rejected for such an order rather than charging the wrong
+// instrument. Runs started before the parameter existed charge the default card.
+func ReprocessOrderWorkflow(ctx workflow.Context, orderID string, paymentMethod *types.PaymentMethod) (string, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("ReprocessOrderWorkflow started", "orderID", orderID)
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy:         defaultRetryPolicy(),
+		HeartbeatTimeout:    15 * time.Second,
+	})
+
+	var status types.OrderWorkflowStatus
+	err := workflow.ExecuteActivity(ctx, activities.ActivityLoadStatus, orderID).Get(ctx, &status)
+	if err != nil {
+		return "", err
+	}
+	workflowID := workflow.GetInfo(ctx).WorkflowExecution.ID
+	if err := validateReprocess(status, workflowID); err != nil {
+		return "", err
+	}
+	if workflow.GetVersion(ctx, "reprocess-payment-method", workflow.DefaultVersion, 1) >= 1 {
+		if err := validateReprocessPaymentMethod(status, paymentMethod); err != nil {
+			return "", err
+		}
+		if paymentMethod != nil {
+			status.PaymentMethod = paymentMethod.Masked()
+		}
+	}
+
+	err = workflow.ExecuteActivity(ctx, activities.ActivityMarkHandedOff, orderID, workflowID).Get(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	failedStage := status.Stage
+	status.HandedOffTo = workflowID
+	status.LastError = ""
+	status.LastFailure = nil
+	status.Reserved = false
+	status.Charged = false
+	status.PaymentReceipt = nil
+	status.TrackingNumber = ""
+
+	err = workflow.SetQueryHandler(ctx, "get-status", func(role string) (types.OrderWorkflowStatus, error) {
+		return status.RedactedFor(role), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Stage transitions are persisted like in OrderWorkflow (best-effort)
+	setStage := func(stage string) {
+		status.Stage = stage
+		status.History = append(status.History, types.StageTransition{Stage: stage, EnteredAt: workflow.Now(ctx)})
+		if err := scheduleActivity(ctx, &status, activities.ActivityPersistStatus, status).Get(ctx, nil); err != nil {
+			logger.Warn("Failed to persist order status", "orderID", orderID, "stage", stage, "error", err)
+		}
+	}
+	var saga Saga
+	fail := func(err error) (string, error) {
+		status.LastError = err.Error()
+		saga.Compensate(ctx)
+		status.LastFailure = &types.FailureDetail{
+			Type:      failureType(err),
+			Message:   err.Error(),
+			Stage:     status.Stage,
+			Timestamp: workflow.Now(ctx),
+		}
+		if perr := scheduleActivity(ctx, &status, activities.ActivityPersistStatus, status).Get(ctx, nil); perr != nil {
+			logger.Warn("Failed to persist order failure", "orderID", orderID, "error", perr)
+		}
+		return "", err
+	}
+	logger.Info("Reprocessing order", "orderID", orderID, "failedStage", failedStage)
+
+	// Reserve again: the failed run released its reservation
+	setStage("reserve")
+	err = runActivity(ctx, &status, activities.ActivityReserveStock, nil, orderID, status.Items, activities.PrimaryWarehouse)
+	if err != nil {
+		return fail(err)
+	}
+	status.Reserved = true
+	status.Warehouse = activities.PrimaryWarehouse
+	status.ReservedItems = append([]types.LineItem(nil), status.Items...)
+	saga.AddCompensation(activities.ActivityReleaseStock, func(ctx workflow.Context) error {
+		return runActivity(ctx, &status, activities.ActivityReleaseStock, nil, orderID)
+	})
+
+	// A failed conversion left no settlement amount behind
+	setStage("payment")
+	if status.SettlementAmount <= 0 {
+		err = runActivity(ctx, &status, activities.ActivityConvert, &status.SettlementAmount, status.OriginalAmount, status.OriginalCurrency, status.SettlementCurrency)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	// A fresh idempotency key: the failed run's charge, if any, was refunded
+	idempotencyKey, err := newID(ctx, "pay")
+	if err != nil {
+		return fail(err)
+	}
+	paymentCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		TaskQueue:           PaymentTaskQueue,
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy:         paymentRetryPolicy(),
+	})
+	var receipt types.PaymentReceipt
+	err = runActivity(paymentCtx, &status, activities.ActivityProcessPayment, &receipt, types.PaymentRequest{
+		OrderID:        orderID,
+		IdempotencyKey: idempotencyKey,
+		Amount:         status.SettlementAmount,
+		Currency:       status.SettlementCurrency,
+		Method:         paymentMethod,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	status.Charged = true
+	status.PaymentReceipt = &receipt
+	saga.AddCompensation(activities.ActivityRefundPayment, func(ctx workflow.Context) error {
+		ctx = withPaymentTaskQueue(ctx)
+		return runActivity(ctx, &status, activities.ActivityRefundPayment, nil, orderID, receipt.TransactionID)
+	})
+
+	setStage("shipping")
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:        "shipment-" + orderID,
+		ParentClosePolicy: enums.PARENT_CLOSE_POLICY_REQUEST_CANCEL,
+	})
+	err = workflow.ExecuteChildWorkflow(childCtx, ShipmentWorkflow, orderID, status.Items).Get(ctx, &status.TrackingNumber)
+	if err != nil {
+		return fail(err)
+	}
+	saga.AddCompensation(activities.ActivityCancelShipment, func(ctx workflow.Context) error {
+		return runActivity(ctx, &status, activities.ActivityCancelShipment, nil, orderID)
+	})
+
+	setStage("status-update")
+	err = runActivity(ctx, &status, activities.ActivityUpdateOrderStatus, nil, orderID, "COMPLETED")
+	if err != nil {
+		return fail(err)
+	}
+
+	// Confirmation is non-critical, as in OrderWorkflow
+	setStage("notify")
+	err = sendOrderConfirmation(ctx, &status)
+	if err != nil {
+		status.LastError = fmt.Sprintf("confirmation failed: %v", err)
+	}
+
+	setStage("completed")
+	workflow.GetMetricsHandler(ctx).Counter("order_reprocessed").Inc(1)
+	return fmt.Sprintf("Order %s reprocessed from stage %s (transaction %s, tracking %s)",
+		orderID, failedStage, status.TransactionID(), status.TrackingNumber), nil
+}
+
+// validateReprocess rejects orders that did not fail, failed before approval
+// (start a new order instead), were declined, or were handed off to another
+// workflow. A retry by the workflow the order was handed off to is allowed.
+func validateReprocess(status types.OrderWorkflowStatus, workflowID string) error {
+	switch {
+	case status.LastFailure == nil:
+		return &types.ValidationError{Msg: fmt.Sprintf("order %s has not failed (stage: %s)", status.OrderID, status.Stage)}
+	case status.HandedOffTo != "" && status.HandedOffTo != workflowID:
+		return &types.ValidationError{Msg: fmt.Sprintf("order %s was already handed off to %s", status.OrderID, status.HandedOffTo)}
+	case status.LastFailure.Type == "PaymentDeclinedError":
+		return &types.ValidationError{Msg: fmt.Sprintf("order %s was declined, not reprocessing", status.OrderID)}
+	case !status.PaymentApproved || !slices.Contains(reprocessableStages, status.Stage):
+		return &types.ValidationError{Msg: fmt.Sprintf("order %s failed in stage %s before fulfilment; start a new order instead", status.OrderID, status.Stage)}
+	}
+	return nil
+}
+
+// validateReprocessPaymentMethod rejects an invalid payment method, and a
+// missing one for an order that was placed with a payment method
+func validateReprocessPaymentMethod(status types.OrderWorkflowStatus, paymentMethod *types.PaymentMethod) error {
+	if paymentMethod != nil {
+		return paymentMethod.Validate()
+	}
+	if status.PaymentMethod != "" {
+		return &types.ValidationError{Msg: fmt.Sprintf("order %s was placed with payment method %s; pass it again to reprocess the order", status.OrderID, status.PaymentMethod)}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package workflows_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/testutil"
+	"go-temporal-fast-course/order-processing/types"
+	"go-temporal-fast-course/order-processing/workflows"
+)
+
+// failedInPayment is the status an approved order placed with a wallet left
+// behind when its charge failed
+func failedInPayment() types.OrderWorkflowStatus {
+	return types.OrderWorkflowStatus{
+		OrderID:            "ORDER-1",
+		Stage:              "payment",
+		Items:              []types.LineItem{book},
+		PaymentApproved:    true,
+		PaymentMethod:      "wallet ****9876",
+		SettlementAmount:   book.UnitPrice,
+		SettlementCurrency: "USD",
+		LastFailure:        &types.FailureDetail{Type: "PaymentTransientError", Stage: "payment"},
+	}
+}
+
+// newReprocessEnv returns an environment in which LoadStatus returns status
+// and the order can be handed off
+func newReprocessEnv(t *testing.T, status types.OrderWorkflowStatus, overrides ...func(env *testsuite.TestWorkflowEnvironment)) *testsuite.TestWorkflowEnvironment {
+	env := testutil.NewOrderTestEnv(t, overrides...)
+	env.OnActivity(activities.ActivityLoadStatus, mock.Anything, status.OrderID).Return(status, nil)
+	env.OnActivity(activities.ActivityMarkHandedOff, mock.Anything, status.OrderID, mock.Anything).Return(nil).Maybe()
+	return env
+}
+
+func TestReprocessChargesThePaymentMethodGiven(t *testing.T) {
+	method := &types.PaymentMethod{Type: types.PaymentMethodWallet, Token: "tok_wallet_9876"}
+	var charged *types.PaymentMethod
+	env := newReprocessEnv(t, failedInPayment(), func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityProcessPayment, mock.Anything, mock.Anything).
+			Return(func(_ context.Context, req types.PaymentRequest) (types.PaymentReceipt, error) {
+				charged = req.Method
+				return types.PaymentReceipt{TransactionID: "TXN-1", Amount: req.Amount}, nil
+			}).Once()
+	})
+
+	env.ExecuteWorkflow(workflows.ReprocessOrderWorkflow, "ORDER-1", method)
+
+	var result string
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Contains(t, result, "reprocessed")
+	require.Equal(t, method, charged)
+}
+
+func TestReprocessRejectsMissingPaymentMethod(t *testing.T) {
+	env := newReprocessEnv(t, failedInPayment())
+
+	env.ExecuteWorkflow(workflows.ReprocessOrderWorkflow, "ORDER-1", (*types.PaymentMethod)(nil))
+
+	err := env.GetWorkflowError()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "wallet ****9876")
+	env.AssertNotCalled(t, activities.ActivityMarkHandedOff, mock.Anything, mock.Anything, mock.Anything)
+	env.AssertNotCalled(t, activities.ActivityProcessPayment, mock.Anything, mock.Anything)
+}
+
+func TestReprocessChargesDefaultCardWithoutPaymentMethod(t *testing.T) {
+	status := failedInPayment()
+	status.PaymentMethod = ""
+	var charged bool
+	env := newReprocessEnv(t, status, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnActivity(activities.ActivityProcessPayment, mock.Anything, mock.MatchedBy(func(req types.PaymentRequest) bool {
+			return req.Method == nil
+		})).Return(func(_ context.Context, req types.PaymentRequest) (types.PaymentReceipt, error) {
+			charged = true
+			return types.PaymentReceipt{TransactionID: "TXN-1", Amount: req.Amount}, nil
+		}).Once()
+	})
+
+	env.ExecuteWorkflow(workflows.ReprocessOrderWorkflow, "ORDER-1", (*types.PaymentMethod)(nil))
+
+	require.NoError(t, env.GetWorkflowError())
+	require.True(t, charged)
+}
@@ -0,0 +1,48 @@
+package workflows
+
+import (
+	"errors"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// reserveStock reserves status.Items in the primary warehouse and, when that
+// fails, in the secondary one, recording the warehouse that holds them in
+// status. With a reserve budget the primary gets half of it, so a primary that
+// keeps timing out leaves time for the fallback; without one it runs its whole
+// retry policy first.
+//
+// Running out of stock is not an outage and does not fall back. Neither does a
+// cancelled stage. The fallback version is only taken when the primary fails,
+// so orders that failed there before still replay.
+func reserveStock(ctx workflow.Context, status *types.OrderWorkflowStatus, budget time.Duration, reservation *types.ReservationResult) error {
+	primaryCtx := ctx
+	if budget > 0 {
+		options := workflow.GetActivityOptions(ctx)
+		options.ScheduleToCloseTimeout = budget / 2
+		primaryCtx = workflow.WithActivityOptions(ctx, options)
+	}
+	err := runActivity(primaryCtx, status, activities.ActivityReserveStock, reservation, status.OrderID, status.Items, activities.PrimaryWarehouse)
+	if err == nil {
+		status.Warehouse = activities.PrimaryWarehouse
+		return nil
+	}
+
+	var appErr *temporal.ApplicationError
+	if ctx.Err() != nil || (errors.As(err, &appErr) && appErr.Type() == "InsufficientInventoryError") ||
+		workflow.GetVersion(ctx, "warehouse-fallback", workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		return err
+	}
+	workflow.GetLogger(ctx).Warn("Primary warehouse reservation failed, trying the secondary", "orderID", status.OrderID, "error", err)
+	err = runActivity(ctx, status, activities.ActivityReserveStock, reservation, status.OrderID, status.Items, activities.SecondaryWarehouse)
+	if err != nil {
+		return err
+	}
+	status.Warehouse = activities.SecondaryWarehouse
+	return nil
+}
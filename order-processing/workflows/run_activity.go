@@ -0,0 +1,48 @@
+package workflows
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// scheduleActivity starts an activity and counts it for the get-meta query.
+// Use it directly only for activities that run in parallel; runActivity is the
+// default.
+func scheduleActivity(ctx workflow.Context, status *types.OrderWorkflowStatus, name string, args ...interface{}) workflow.Future {
+	status.ActivitiesExecuted++
+	return workflow.ExecuteActivity(ctx, name, args...)
+}
+
+// scheduleLocalActivity is scheduleActivity for local activities
+func scheduleLocalActivity(ctx workflow.Context, status *types.OrderWorkflowStatus, name string, args ...interface{}) workflow.Future {
+	status.ActivitiesExecuted++
+	return workflow.ExecuteLocalActivity(ctx, name, args...)
+}
+
+// runActivity executes the activity and waits for its result, decoded into
+// valuePtr (nil to discard it). The run is recorded on the current stage's
+// history entry with workflow.Now start and end times, and a failure is logged
+// with the stage. The error is returned unchanged so callers can still match
+// typed errors and decide whether it is fatal.
+func runActivity(ctx workflow.Context, status *types.OrderWorkflowStatus, name string, valuePtr interface{}, args ...interface{}) error {
+	startedAt := workflow.Now(ctx)
+	err := scheduleActivity(ctx, status, name, args...).Get(ctx, valuePtr)
+	recordActivityRun(ctx, status, name, startedAt, err)
+	return err
+}
+
+// recordActivityRun appends a finished activity to the current stage's history
+// entry. runActivity calls it; parallel activities call it after their Get.
+func recordActivityRun(ctx workflow.Context, status *types.OrderWorkflowStatus, name string, startedAt time.Time, err error) {
+	run := types.ActivityRun{Name: name, StartedAt: startedAt, EndedAt: workflow.Now(ctx)}
+	if err != nil {
+		run.Error = err.Error()
+		workflow.GetLogger(ctx).Warn("Activity failed", "orderID", status.OrderID, "stage", status.Stage, "activity", name, "error", err)
+	}
+	if n := len(status.History); n > 0 {
+		status.History[n-1].Activities = append(status.History[n-1].Activities, run)
+	}
+}
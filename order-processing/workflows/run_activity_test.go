@@ -0,0 +1,55 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// runActivity records each run, with its virtual-clock duration and any error,
+// on the current stage's history entry
+func TestRunActivityRecordsHistory(t *testing.T) {
+	start := time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC)
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetStartTime(start)
+	env.RegisterActivityWithOptions(func(context.Context, string) (string, error) { return "", nil }, activity.RegisterOptions{Name: "Echo"})
+	env.OnActivity("Echo", mock.Anything, "slow").After(5*time.Second).Return("done", nil)
+	env.OnActivity("Echo", mock.Anything, "broken").Return("", errors.New("boom"))
+
+	env.ExecuteWorkflow(func(ctx workflow.Context) (types.OrderWorkflowStatus, error) {
+		ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+			RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 1},
+		})
+		status := types.OrderWorkflowStatus{OrderID: "ORDER-1", Stage: "payment"}
+		status.History = []types.StageTransition{{Stage: "payment", EnteredAt: workflow.Now(ctx)}}
+		var result string
+		if err := runActivity(ctx, &status, "Echo", &result, "slow"); err != nil || result != "done" {
+			return status, errors.New("slow run did not return done")
+		}
+		if err := runActivity(ctx, &status, "Echo", nil, "broken"); err == nil {
+			return status, errors.New("broken run did not fail")
+		}
+		return status, nil
+	})
+
+	var status types.OrderWorkflowStatus
+	require.NoError(t, env.GetWorkflowResult(&status))
+	require.Equal(t, 2, status.ActivitiesExecuted)
+	runs := status.History[0].Activities
+	require.Len(t, runs, 2)
+	require.Equal(t, types.ActivityRun{Name: "Echo", StartedAt: start, EndedAt: start.Add(5 * time.Second)}, runs[0])
+	require.Equal(t, "Echo", runs[1].Name)
+	require.Contains(t, runs[1].Error, "boom")
+}
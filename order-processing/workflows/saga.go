@@ -0,0 +1,46 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+)
+
+// Saga tracks the compensations for the steps of a workflow that have
+// succeeded so far (Lesson 5: Saga pattern). Register a compensation right
+// after the step it undoes; Compensate runs them most recent first.
+type Saga struct {
+	// OnCompensated, if set, is called with the name of each compensation that
+	// completes successfully
+	OnCompensated func(name string)
+
+	compensations []sagaStep
+}
+
+type sagaStep struct {
+	name string
+	fn   func(ctx workflow.Context) error
+}
+
+// AddCompensation registers fn to undo the step that just succeeded. name
+// identifies it in logs and OnCompensated.
+func (s *Saga) AddCompensation(name string, fn func(ctx workflow.Context) error) {
+	s.compensations = append(s.compensations, sagaStep{name: name, fn: fn})
+}
+
+// Compensate runs the registered compensations in reverse order and clears
+// them, so calling it twice does not undo anything twice. Compensations are
+// best-effort: a failure is logged and the remaining ones still run. ctx must
+// not be cancelled, or the compensating activities cannot be scheduled.
+func (s *Saga) Compensate(ctx workflow.Context) {
+	logger := workflow.GetLogger(ctx)
+	for i := len(s.compensations) - 1; i >= 0; i-- {
+		step := s.compensations[i]
+		if err := step.fn(ctx); err != nil {
+			logger.Warn("Compensation failed", "step", step.name, "error", err)
+			continue
+		}
+		if s.OnCompensated != nil {
+			s.OnCompensated(step.name)
+		}
+	}
+	s.compensations = nil
+}
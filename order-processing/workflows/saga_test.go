@@ -0,0 +1,38 @@
+package workflows
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Compensations run most recent first; a failing one doesn't stop the rest and
+// isn't reported as compensated; a second Compensate undoes nothing
+func TestSagaCompensatesInReverseOrder(t *testing.T) {
+	var ran, compensated []string
+	step := func(name string, err error) func(workflow.Context) error {
+		return func(workflow.Context) error {
+			ran = append(ran, name)
+			return err
+		}
+	}
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(func(ctx workflow.Context) error {
+		saga := Saga{OnCompensated: func(name string) { compensated = append(compensated, name) }}
+		saga.AddCompensation("release", step("release", nil))
+		saga.AddCompensation("refund", step("refund", errors.New("gateway down")))
+		saga.AddCompensation("cancel-shipment", step("cancel-shipment", nil))
+		saga.Compensate(ctx)
+		saga.Compensate(ctx)
+		return nil
+	})
+
+	require.NoError(t, env.GetWorkflowError())
+	require.Equal(t, []string{"cancel-shipment", "refund", "release"}, ran)
+	require.Equal(t, []string{"cancel-shipment", "release"}, compensated)
+}
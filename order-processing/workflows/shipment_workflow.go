@@ -0,0 +1,54 @@
+package workflows
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// ShipmentWorkflow handles the shipping subprocess for an order as a child workflow:
+// - Carrier selection
+// - Label creation
+// - Shipment registration returning the tracking number
+// It is started by OrderWorkflow with a REQUEST_CANCEL parent close policy, so
+// cancelling the order also cancels the shipment.
+func ShipmentWorkflow(ctx workflow.Context, orderID string, items []types.LineItem) (string, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("ShipmentWorkflow started", "orderID", orderID)
+
+	activityOptions := workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy:         defaultRetryPolicy(),
+	}
+	ctx = workflow.WithActivityOptions(ctx, activityOptions)
+
+	// Step 1: Carrier selection
+	var carrier string
+	err := workflow.ExecuteActivity(ctx, activities.ActivitySelectCarrier, items).Get(ctx, &carrier)
+	if err != nil {
+		logger.Error("Carrier selection failed", "error", err)
+		return "", err
+	}
+
+	// Step 2: Label creation
+	var labelID string
+	err = workflow.ExecuteActivity(ctx, activities.ActivityCreateShippingLabel, orderID, carrier).Get(ctx, &labelID)
+	if err != nil {
+		logger.Error("Label creation failed", "error", err)
+		return "", err
+	}
+
+	// Step 3: Register the shipment and obtain the tracking number
+	var trackingNumber string
+	err = workflow.ExecuteActivity(ctx, activities.ActivityCreateShipment, orderID, items).Get(ctx, &trackingNumber)
+	if err != nil {
+		logger.Error("Shipment creation failed", "error", err)
+		return "", err
+	}
+
+	logger.Info("ShipmentWorkflow completed", "orderID", orderID, "carrier", carrier, "label", labelID, "trackingNumber", trackingNumber)
+	return trackingNumber, nil
+}
@@ -0,0 +1,60 @@
+package workflows_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+
+	"go-temporal-fast-course/order-processing/activities"
+	"go-temporal-fast-course/order-processing/testutil"
+	"go-temporal-fast-course/order-processing/types"
+	"go-temporal-fast-course/order-processing/workflows"
+)
+
+// The tracking number the parent records is the one the child returned
+func TestOrderWorkflowRecordsShipmentChildResult(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnWorkflow(workflows.ShipmentWorkflow, mock.Anything, "ORDER-1", []types.LineItem{book}).
+			Return("TRACK-FROM-CHILD", nil).Once()
+	})
+	shipAndApprove(t, env)
+
+	result, err := runOrder(t, env, book)
+	require.NoError(t, err)
+	require.Contains(t, result, "completed")
+	require.Equal(t, "TRACK-FROM-CHILD", queryStatus(t, env).TrackingNumber)
+}
+
+func TestShipmentWorkflowReturnsTrackingNumber(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	testutil.RegisterOrderWorker(env, nil, activities.FailureConfig{})
+	env.OnActivity(activities.ActivityCreateShipment, mock.Anything, "ORDER-1", []types.LineItem{book}).
+		Return("TRACK-1", nil).Once()
+
+	env.ExecuteWorkflow(workflows.ShipmentWorkflow, "ORDER-1", []types.LineItem{book})
+
+	require.True(t, env.IsWorkflowCompleted())
+	var trackingNumber string
+	require.NoError(t, env.GetWorkflowResult(&trackingNumber))
+	require.Equal(t, "TRACK-1", trackingNumber)
+	env.AssertExpectations(t)
+}
+
+// A shipment failure after the charge refunds, then releases the stock
+func TestOrderWorkflowShipmentFailureCompensatesInReverse(t *testing.T) {
+	env := testutil.NewOrderTestEnv(t, func(env *testsuite.TestWorkflowEnvironment) {
+		env.OnWorkflow(workflows.ShipmentWorkflow, mock.Anything, "ORDER-1", mock.Anything).
+			Return("", errors.New("carrier rejected the label"))
+		env.OnActivity(activities.ActivityRefundPayment, mock.Anything, "ORDER-1", "TXN-ORDER-1").Return(nil).Once()
+		env.OnActivity(activities.ActivityReleaseStock, mock.Anything, "ORDER-1").Return(nil).Once()
+	})
+	shipAndApprove(t, env)
+
+	_, err := runOrder(t, env, book)
+	require.ErrorContains(t, err, "carrier rejected the label")
+	require.Equal(t, []string{activities.ActivityRefundPayment, activities.ActivityReleaseStock}, queryStatus(t, env).CompensationsRun)
+}
@@ -0,0 +1,65 @@
+package workflows
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// StageBudgets caps the wall-clock time of the stages that call external
+// systems. A zero budget disables the cap for that stage.
+type StageBudgets struct {
+	Reserve      time.Duration
+	Payment      time.Duration // ProcessPayment's ScheduleToClose; must outlast the payment retry policy, or retries are cut short
+	StatusUpdate time.Duration
+}
+
+// OrderStageBudgets is set by the worker at startup; each run records the
+// budgets it started with.
+var OrderStageBudgets = StageBudgets{
+	Reserve:      2 * time.Minute,
+	Payment:      15 * time.Minute,
+	StatusUpdate: 2 * time.Minute,
+}
+
+// withStageTimeout runs fn and fails with a StageTimeoutError if it takes
+// longer than budget. The timer and fn race in a selector; when the timer
+// wins, fn's context is cancelled, which cancels its activity, and fn is
+// waited for so nothing is left running. If fn still succeeds, e.g. its
+// activity completed as the timer fired, the stage succeeded and nil is
+// returned so the caller records what was done. A budget <= 0 runs fn unbounded.
+func withStageTimeout(ctx workflow.Context, stage string, budget time.Duration, fn func(ctx workflow.Context) error) error {
+	if budget <= 0 {
+		return fn(ctx)
+	}
+
+	stageCtx, cancelStage := workflow.WithCancel(ctx)
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	done, settable := workflow.NewFuture(ctx)
+	workflow.Go(stageCtx, func(ctx workflow.Context) {
+		settable.Set(nil, fn(ctx))
+	})
+
+	var err error
+	selector := workflow.NewSelector(ctx)
+	selector.AddFuture(done, func(f workflow.Future) {
+		cancelTimer()
+		err = f.Get(ctx, nil)
+	})
+	selector.AddFuture(workflow.NewTimer(timerCtx, budget), func(f workflow.Future) {
+		cancelStage()
+		// Older histories reported a timeout even when fn succeeded; only a
+		// success after the timer takes the marker
+		if done.Get(ctx, nil) == nil &&
+			workflow.GetVersion(ctx, "stage-timeout-keeps-success", workflow.DefaultVersion, 1) >= 1 {
+			workflow.GetLogger(ctx).Warn("Stage finished as its budget ran out, keeping its result", "stage", stage, "budget", budget)
+			return
+		}
+		workflow.GetLogger(ctx).Warn("Stage exceeded its budget", "stage", stage, "budget", budget)
+		err = &types.StageTimeoutError{Stage: stage, Budget: budget}
+	})
+	selector.Select(ctx)
+	return err
+}
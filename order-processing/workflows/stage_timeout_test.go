@@ -0,0 +1,55 @@
+package workflows
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+// runStageTimeout runs withStageTimeout with a 30s budget around fn and
+// returns the workflow's error
+func runStageTimeout(t *testing.T, fn func(ctx workflow.Context) error) error {
+	t.Helper()
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(func(ctx workflow.Context) error {
+		return withStageTimeout(ctx, "reserve", 30*time.Second, fn)
+	})
+	require.True(t, env.IsWorkflowCompleted())
+	return env.GetWorkflowError()
+}
+
+func TestWithStageTimeoutReturnsResultWithinBudget(t *testing.T) {
+	err := runStageTimeout(t, func(ctx workflow.Context) error {
+		return workflow.Sleep(ctx, 10*time.Second)
+	})
+	require.NoError(t, err)
+}
+
+// A stage that stops when cancelled fails with StageTimeoutError
+func TestWithStageTimeoutFailsWhenStageIsCancelled(t *testing.T) {
+	err := runStageTimeout(t, func(ctx workflow.Context) error {
+		return workflow.Sleep(ctx, time.Minute)
+	})
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr), "%T: %v", err, err)
+	require.Equal(t, "StageTimeoutError", appErr.Type())
+	require.Contains(t, appErr.Error(), (&types.StageTimeoutError{Stage: "reserve", Budget: 30 * time.Second}).Error())
+}
+
+// A stage whose work completes after the timer fired succeeded: reporting a
+// timeout would leave its effects without a compensation
+func TestWithStageTimeoutKeepsSuccessAfterBudget(t *testing.T) {
+	err := runStageTimeout(t, func(ctx workflow.Context) error {
+		disconnected, _ := workflow.NewDisconnectedContext(ctx)
+		return workflow.Sleep(disconnected, time.Minute)
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,90 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/converter"
+
+	"go-temporal-fast-course/order-processing/types"
+)
+
+var (
+	key      = bytes.Repeat([]byte{1}, 32)
+	otherKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func sampleStatus() types.OrderWorkflowStatus {
+	return types.OrderWorkflowStatus{
+		OrderID:         "ORDER-1",
+		Stage:           "completed",
+		Items:           []types.LineItem{{SKU: "BOOK-001", Quantity: 1, UnitPrice: 24.99, Currency: "USD"}},
+		CustomerEmail:   "jane@example.com",
+		ShippingAddress: types.ShippingAddress{Street: "1 Main", City: "Springfield", PostalCode: "12345", Country: "US"},
+	}
+}
+
+func TestEncryptionDataConverterRoundTrip(t *testing.T) {
+	dc, err := NewEncryptionDataConverter(key)
+	require.NoError(t, err)
+
+	status := sampleStatus()
+	payload, err := dc.ToPayload(status)
+	require.NoError(t, err)
+	require.Equal(t, MetadataEncoding, string(payload.GetMetadata()[converter.MetadataEncoding]))
+	require.NotContains(t, string(payload.GetData()), "jane@example.com")
+	require.NotContains(t, string(payload.GetData()), "Springfield")
+
+	var decoded types.OrderWorkflowStatus
+	require.NoError(t, dc.FromPayload(payload, &decoded))
+	require.Equal(t, status, decoded)
+}
+
+func TestEncryptionDataConverterWrongKeyFails(t *testing.T) {
+	dc, err := NewEncryptionDataConverter(key)
+	require.NoError(t, err)
+	other, err := NewEncryptionDataConverter(otherKey)
+	require.NoError(t, err)
+
+	payload, err := dc.ToPayload(sampleStatus())
+	require.NoError(t, err)
+	var decoded types.OrderWorkflowStatus
+	require.ErrorContains(t, other.FromPayload(payload, &decoded), "wrong "+KeyEnv)
+}
+
+// Payloads written before encryption was enabled are still readable
+func TestEncryptionDataConverterReadsPlainPayloads(t *testing.T) {
+	dc, err := NewEncryptionDataConverter(key)
+	require.NoError(t, err)
+
+	payload, err := converter.GetDefaultDataConverter().ToPayload(sampleStatus())
+	require.NoError(t, err)
+	var decoded types.OrderWorkflowStatus
+	require.NoError(t, dc.FromPayload(payload, &decoded))
+	require.Equal(t, sampleStatus(), decoded)
+}
+
+func TestNewEncryptionDataConverterRejectsBadKey(t *testing.T) {
+	_, err := NewEncryptionDataConverter([]byte("short"))
+	require.ErrorContains(t, err, "invalid payload encryption key")
+}
+
+func TestDataConverterFromEnv(t *testing.T) {
+	t.Setenv(KeyEnv, "")
+	dc, err := DataConverterFromEnv()
+	require.NoError(t, err)
+	require.Nil(t, dc)
+
+	t.Setenv(KeyEnv, "not base64!")
+	_, err = DataConverterFromEnv()
+	require.ErrorContains(t, err, "must be base64-encoded")
+
+	t.Setenv(KeyEnv, base64.StdEncoding.EncodeToString(key))
+	dc, err = DataConverterFromEnv()
+	require.NoError(t, err)
+	payload, err := dc.ToPayload(sampleStatus())
+	require.NoError(t, err)
+	require.Equal(t, MetadataEncoding, string(payload.GetMetadata()[converter.MetadataEncoding]))
+}
@@ -0,0 +1,57 @@
+package health
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// draining is set once the worker has been asked to shut down
+var draining atomic.Bool
+
+// Draining reports whether the worker received SIGINT/SIGTERM and is shutting down
+func Draining() bool {
+	return draining.Load()
+}
+
+// InterruptCh replaces worker.InterruptCh: on SIGINT or SIGTERM it marks the
+// process as draining (so /readyz starts failing) and then stops the worker
+func InterruptCh() <-chan interface{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	ch := make(chan interface{}, 1)
+	go func() {
+		s := <-signals
+		draining.Store(true)
+		ch <- s
+	}()
+	return ch
+}
+
+// Register adds /healthz (process is up) and /readyz (503 once draining) to mux
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if Draining() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("draining\n"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ready\n"))
+	})
+}
+
+// NewServer returns an HTTP server exposing only the health endpoints, for
+// workers that don't already run one
+func NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	Register(mux)
+	return &http.Server{Addr: addr, Handler: mux}
+}
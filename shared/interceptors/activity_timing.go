@@ -0,0 +1,61 @@
+package interceptors
+
+import (
+	"context"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+)
+
+// ActivityTimingInterceptor logs the type, attempt number and elapsed duration
+// of every activity execution on the worker it is registered with
+type ActivityTimingInterceptor struct {
+	interceptor.WorkerInterceptorBase
+}
+
+// NewActivityTimingInterceptor creates a worker interceptor for activity timing telemetry
+func NewActivityTimingInterceptor() *ActivityTimingInterceptor {
+	return &ActivityTimingInterceptor{}
+}
+
+// InterceptActivity wraps the activity inbound chain with timing
+func (w *ActivityTimingInterceptor) InterceptActivity(
+	ctx context.Context,
+	next interceptor.ActivityInboundInterceptor,
+) interceptor.ActivityInboundInterceptor {
+	i := &activityTimingInbound{}
+	i.Next = next
+	return i
+}
+
+type activityTimingInbound struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+// ExecuteActivity times the activity and logs the outcome on completion
+func (a *activityTimingInbound) ExecuteActivity(
+	ctx context.Context,
+	in *interceptor.ExecuteActivityInput,
+) (interface{}, error) {
+	start := time.Now()
+	result, err := a.Next.ExecuteActivity(ctx, in)
+	elapsed := time.Since(start)
+
+	info := activity.GetInfo(ctx)
+	logger := activity.GetLogger(ctx)
+	if err != nil {
+		logger.Warn("Activity failed",
+			"activityType", info.ActivityType.Name,
+			"attempt", info.Attempt,
+			"duration", elapsed,
+			"error", err)
+	} else {
+		logger.Info("Activity completed",
+			"activityType", info.ActivityType.Name,
+			"attempt", info.Attempt,
+			"duration", elapsed)
+	}
+
+	return result, err
+}
@@ -0,0 +1,101 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+)
+
+// logEntry is one message recorded by recordingLogger, with its key-value pairs
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// recordingLogger is a log.Logger that keeps every entry
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordingLogger) record(level, msg string, keyvals []interface{}) {
+	fields := make(map[string]interface{}, len(keyvals)/2)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if key, ok := keyvals[i].(string); ok {
+			fields[key] = keyvals[i+1]
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func (l *recordingLogger) Debug(msg string, keyvals ...interface{}) { l.record("debug", msg, keyvals) }
+func (l *recordingLogger) Info(msg string, keyvals ...interface{})  { l.record("info", msg, keyvals) }
+func (l *recordingLogger) Warn(msg string, keyvals ...interface{})  { l.record("warn", msg, keyvals) }
+func (l *recordingLogger) Error(msg string, keyvals ...interface{}) { l.record("error", msg, keyvals) }
+
+// find returns the first entry with msg
+func (l *recordingLogger) find(t *testing.T, msg string) logEntry {
+	t.Helper()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range l.entries {
+		if entry.msg == msg {
+			return entry
+		}
+	}
+	t.Fatalf("no %q log entry in %+v", msg, l.entries)
+	return logEntry{}
+}
+
+// runWithTiming executes fn as activity "Sample" on a worker with the
+// interceptor and returns its error and the log
+func runWithTiming(t *testing.T, fn func(context.Context) error) (*recordingLogger, error) {
+	logger := &recordingLogger{}
+	var suite testsuite.WorkflowTestSuite
+	suite.SetLogger(logger)
+	env := suite.NewTestActivityEnvironment()
+	env.SetWorkerOptions(worker.Options{
+		Interceptors: []interceptor.WorkerInterceptor{NewActivityTimingInterceptor()},
+	})
+	env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: "Sample"})
+	_, err := env.ExecuteActivity("Sample")
+	return logger, err
+}
+
+func TestActivityTimingInterceptorRecordsDuration(t *testing.T) {
+	logger, err := runWithTiming(t, func(context.Context) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	require.NoError(t, err)
+
+	entry := logger.find(t, "Activity completed")
+	require.Equal(t, "info", entry.level)
+	require.Equal(t, "Sample", entry.fields["activityType"])
+	require.EqualValues(t, 1, entry.fields["attempt"])
+	require.GreaterOrEqual(t, entry.fields["duration"], 20*time.Millisecond)
+}
+
+func TestActivityTimingInterceptorRecordsFailure(t *testing.T) {
+	logger, err := runWithTiming(t, func(context.Context) error {
+		return errors.New("gateway down")
+	})
+	require.Error(t, err)
+
+	entry := logger.find(t, "Activity failed")
+	require.Equal(t, "warn", entry.level)
+	require.Equal(t, "Sample", entry.fields["activityType"])
+	require.IsType(t, time.Duration(0), entry.fields["duration"])
+	require.EqualError(t, entry.fields["error"].(error), "gateway down")
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.temporal.io/sdk/log"
+)
+
+// workflowIDPrefixes are stripped from workflow IDs to recover the order ID
+// ("order-workflow-ORDER-1" and its "shipment-ORDER-1" child both map to "ORDER-1")
+var workflowIDPrefixes = []string{"order-workflow-", "shipment-"}
+
+// FormatEnv selects the output format of NewCorrelatedLoggerFromEnv
+const FormatEnv = "LOG_FORMAT"
+
+// tagWorkflowID is the key the SDK uses when it attaches the workflow ID to
+// workflow and activity loggers
+const tagWorkflowID = "WorkflowID"
+
+// CorrelatedLogger is an SDK logger that moves orderID and workflowID to the
+// front of every line logged from a workflow or activity context, so logs for
+// one order can be grepped without each call site passing them
+type CorrelatedLogger struct {
+	base   log.Logger
+	prefix []interface{}
+	fields []interface{}
+}
+
+// NewCorrelatedLogger creates a correlated logger writing structured text to
+// stderr. Set it as client.Options.Logger; workers inherit the client logger.
+func NewCorrelatedLogger() *CorrelatedLogger {
+	return newCorrelatedLogger(slog.NewTextHandler(os.Stderr, nil))
+}
+
+// NewCorrelatedLoggerFromEnv creates a correlated logger in the format named by
+// FormatEnv: "text" (the default, as NewCorrelatedLogger) or "json", one JSON
+// object per line for log shippers such as ELK or Loki.
+func NewCorrelatedLoggerFromEnv() (*CorrelatedLogger, error) {
+	switch format := os.Getenv(FormatEnv); format {
+	case "", "text":
+		return NewCorrelatedLogger(), nil
+	case "json":
+		return newCorrelatedLogger(slog.NewJSONHandler(os.Stderr, nil)), nil
+	default:
+		return nil, fmt.Errorf("%s must be text or json, got %q", FormatEnv, format)
+	}
+}
+
+// newCorrelatedLogger adapts handler to the SDK logger interface
+func newCorrelatedLogger(handler slog.Handler) *CorrelatedLogger {
+	return &CorrelatedLogger{base: log.NewStructuredLogger(slog.New(handler))}
+}
+
+// With is called by the SDK with the workflow/activity info for each context;
+// the workflow ID is lifted into the correlation prefix
+func (l *CorrelatedLogger) With(keyvals ...interface{}) log.Logger {
+	child := &CorrelatedLogger{
+		base:   l.base,
+		prefix: l.prefix,
+		fields: append([]interface{}{}, l.fields...),
+	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == tagWorkflowID {
+			if workflowID, ok := keyvals[i+1].(string); ok {
+				child.prefix = correlationFields(workflowID)
+				continue
+			}
+		}
+		child.fields = append(child.fields, keyvals[i], keyvals[i+1])
+	}
+	return child
+}
+
+// WithCallerSkip keeps the reported caller pointing at the code that logged
+func (l *CorrelatedLogger) WithCallerSkip(depth int) log.Logger {
+	return &CorrelatedLogger{base: log.Skip(l.base, depth), prefix: l.prefix, fields: l.fields}
+}
+
+func (l *CorrelatedLogger) Debug(msg string, keyvals ...interface{}) {
+	l.base.Debug(msg, l.keyvals(keyvals)...)
+}
+
+func (l *CorrelatedLogger) Info(msg string, keyvals ...interface{}) {
+	l.base.Info(msg, l.keyvals(keyvals)...)
+}
+
+func (l *CorrelatedLogger) Warn(msg string, keyvals ...interface{}) {
+	l.base.Warn(msg, l.keyvals(keyvals)...)
+}
+
+func (l *CorrelatedLogger) Error(msg string, keyvals ...interface{}) {
+	l.base.Error(msg, l.keyvals(keyvals)...)
+}
+
+// keyvals assembles prefix, context fields and call-site fields, dropping a
+// call-site orderID that duplicates the correlated one
+func (l *CorrelatedLogger) keyvals(keyvals []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(l.prefix)+len(l.fields)+len(keyvals))
+	out = append(out, l.prefix...)
+	out = append(out, l.fields...)
+	hasOrderID := len(l.prefix) > 2
+	for i := 0; i < len(keyvals); i += 2 {
+		if hasOrderID && keyvals[i] == "orderID" {
+			continue
+		}
+		out = append(out, keyvals[i])
+		if i+1 < len(keyvals) {
+			out = append(out, keyvals[i+1])
+		}
+	}
+	return out
+}
+
+// correlationFields returns the orderID (when the workflow ID follows the
+// course naming) and workflowID fields
+func correlationFields(workflowID string) []interface{} {
+	for _, prefix := range workflowIDPrefixes {
+		if orderID := strings.TrimPrefix(workflowID, prefix); orderID != workflowID {
+			return []interface{}{"orderID", orderID, "workflowID", workflowID}
+		}
+	}
+	return []interface{}{"workflowID", workflowID}
+}
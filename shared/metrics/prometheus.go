@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/uber-go/tally/v4"
+	"github.com/uber-go/tally/v4/prometheus"
+	"go.temporal.io/sdk/client"
+)
+
+// sanitizeOptions restricts metric names, tag keys and tag values to the
+// characters Prometheus accepts
+var sanitizeOptions = tally.SanitizeOptions{
+	NameCharacters: tally.ValidCharacters{
+		Ranges:     tally.AlphanumericRange,
+		Characters: tally.UnderscoreCharacters,
+	},
+	KeyCharacters: tally.ValidCharacters{
+		Ranges:     tally.AlphanumericRange,
+		Characters: tally.UnderscoreCharacters,
+	},
+	ValueCharacters: tally.ValidCharacters{
+		Ranges:     tally.AlphanumericRange,
+		Characters: tally.UnderscoreCharacters,
+	},
+	ReplacementCharacter: tally.DefaultReplacementCharacter,
+}
+
+// NewPrometheusHandler creates a Temporal metrics handler backed by a tally
+// Prometheus reporter. The returned http.Handler serves the /metrics endpoint
+// and the io.Closer flushes and stops the underlying scope.
+func NewPrometheusHandler() (client.MetricsHandler, http.Handler, io.Closer) {
+	reporter := prometheus.NewReporter(prometheus.Options{})
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter:  reporter,
+		Separator:       prometheus.DefaultSeparator,
+		SanitizeOptions: &sanitizeOptions,
+	}, time.Second)
+
+	return NewTallyHandler(scope), reporter.HTTPHandler(), closer
+}
+
+// tallyHandler adapts a tally.Scope to the Temporal client.MetricsHandler interface
+type tallyHandler struct {
+	scope tally.Scope
+}
+
+// NewTallyHandler wraps a tally scope as a Temporal metrics handler
+func NewTallyHandler(scope tally.Scope) client.MetricsHandler {
+	return tallyHandler{scope: scope}
+}
+
+func (h tallyHandler) WithTags(tags map[string]string) client.MetricsHandler {
+	return tallyHandler{scope: h.scope.Tagged(tags)}
+}
+
+func (h tallyHandler) Counter(name string) client.MetricsCounter {
+	return h.scope.Counter(name)
+}
+
+func (h tallyHandler) Gauge(name string) client.MetricsGauge {
+	return h.scope.Gauge(name)
+}
+
+func (h tallyHandler) Timer(name string) client.MetricsTimer {
+	return h.scope.Timer(name)
+}
@@ -0,0 +1,45 @@
+package retry
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"go.temporal.io/sdk/client"
+)
+
+// Defaults for DialWithRetry: about a minute of retries in total, enough for a
+// local Temporal server to come up alongside the workers
+const (
+	DialAttempts = 8
+	DialBackoff  = 1 * time.Second
+)
+
+// maxDialBackoff caps the wait between dial attempts
+const maxDialBackoff = 15 * time.Second
+
+// DialWithRetry dials the Temporal server, retrying up to maxAttempts times with
+// exponential backoff starting at backoff. Processes started before the server
+// is ready (e.g. by docker compose up) then connect once it is, instead of exiting.
+func DialWithRetry(opts client.Options, maxAttempts int, backoff time.Duration) (client.Client, error) {
+	hostPort := opts.HostPort
+	if hostPort == "" {
+		hostPort = client.DefaultHostPort
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		var c client.Client
+		c, err = client.Dial(opts)
+		if err == nil {
+			return c, nil
+		}
+		if attempt == maxAttempts {
+			break
+		}
+		log.Printf("Temporal not reachable at %s (attempt %d/%d), retrying in %s: %v", hostPort, attempt, maxAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxDialBackoff)
+	}
+	return nil, fmt.Errorf("unable to connect to Temporal at %s after %d attempts: %w", hostPort, maxAttempts, err)
+}
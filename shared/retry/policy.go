@@ -0,0 +1,33 @@
+package retry
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// Tunables for the default activity retry policy. Workers may override them at
+// startup (e.g. from RETRY_MAX_ATTEMPTS); the retry policy is not part of the
+// replay determinism check, so changing them between deployments is safe.
+var (
+	// MaxAttempts caps the total number of attempts per activity, including the first
+	MaxAttempts int32 = 5
+	// MaxInterval caps the exponential backoff between attempts
+	MaxInterval = 30 * time.Second
+)
+
+// NonRetryableErrorTypes lists the business error types that must never be retried
+var NonRetryableErrorTypes = []string{"PermanentError", "ValidationError"}
+
+// DefaultPolicy returns the activity retry policy shared by all workflows in
+// the course: exponential backoff starting at 1s, doubling up to MaxInterval.
+// Each call returns a fresh copy so callers can extend NonRetryableErrorTypes.
+func DefaultPolicy() *temporal.RetryPolicy {
+	return &temporal.RetryPolicy{
+		InitialInterval:        1 * time.Second,
+		BackoffCoefficient:     2.0,
+		MaximumInterval:        MaxInterval,
+		MaximumAttempts:        MaxAttempts,
+		NonRetryableErrorTypes: append([]string(nil), NonRetryableErrorTypes...),
+	}
+}
@@ -0,0 +1,61 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// The error type a retry policy matches is the Go type name of the error
+type PermanentError struct{}
+
+func (*PermanentError) Error() string { return "declined" }
+
+type ValidationError struct{}
+
+func (*ValidationError) Error() string { return "bad input" }
+
+// attemptsUntilFailure runs an activity returning err under DefaultPolicy and
+// returns how many times it was attempted
+func attemptsUntilFailure(t *testing.T, err error) int32 {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	var attempts int32
+	env.RegisterActivityWithOptions(func(ctx context.Context) error {
+		attempts = activity.GetInfo(ctx).Attempt
+		return err
+	}, activity.RegisterOptions{Name: "Failing"})
+
+	env.ExecuteWorkflow(func(ctx workflow.Context) error {
+		ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+			RetryPolicy:         DefaultPolicy(),
+		})
+		return workflow.ExecuteActivity(ctx, "Failing").Get(ctx, nil)
+	})
+	require.True(t, env.IsWorkflowCompleted())
+	require.Error(t, env.GetWorkflowError())
+	return attempts
+}
+
+func TestDefaultPolicyHonorsNonRetryableErrorTypes(t *testing.T) {
+	require.EqualValues(t, 1, attemptsUntilFailure(t, &PermanentError{}))
+	require.EqualValues(t, 1, attemptsUntilFailure(t, &ValidationError{}))
+	require.Equal(t, MaxAttempts, attemptsUntilFailure(t, errors.New("connection reset")))
+}
+
+// Extending one policy's list leaves the shared list and other policies alone
+func TestDefaultPolicyReturnsCopies(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.NonRetryableErrorTypes = append(policy.NonRetryableErrorTypes, "InsufficientInventoryError")
+	policy.NonRetryableErrorTypes[0] = "Changed"
+
+	require.Equal(t, []string{"PermanentError", "ValidationError"}, NonRetryableErrorTypes)
+	require.Equal(t, NonRetryableErrorTypes, DefaultPolicy().NonRetryableErrorTypes)
+}
@@ -11,4 +11,7 @@ echo ""
 echo "Press Ctrl+C to stop the server"
 echo ""
 
-temporal server start-dev --db-filename ./temporal.db
\ No newline at end of file
+# Custom search attributes used by OrderWorkflow for filtering in the UI
+temporal server start-dev --db-filename ./temporal.db \
+  --search-attribute CustomerTier=Keyword \
+  --search-attribute OrderStage=Keyword
\ No newline at end of file